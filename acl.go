@@ -0,0 +1,52 @@
+package triefs
+
+import "slices"
+
+// VisibleTo checks if principal may see the content, everyone may see
+// content with empty ACL
+func (c *Content) VisibleTo(principal string) bool {
+	return len(c.ACL) == 0 || slices.Contains(c.ACL, principal)
+}
+
+// LsAs is Ls listing only children principal may see, see Content.VisibleTo.
+// Directories don't have an ACL, one is listed when principal may see any
+// file beneath it or when it holds no files at all
+func (mt *Trie) LsAs(path, principal string) []*Content {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	res := make([]*Content, 0)
+	if mt.Root == nil {
+		return res
+	}
+
+	p := CleanPath(mt.swap(path))
+	for _, c := range list(p, mt.Root) {
+		if c.IsDirectory() && !mt.dirVisibleTo(JoinPath(p, c.Name), principal) {
+			continue
+		}
+		if !c.IsDirectory() && !c.VisibleTo(principal) {
+			continue
+		}
+		res = append(res, c)
+	}
+	sortContents(res)
+	return mt.swapContents(res)
+}
+
+// dirVisibleTo checks if principal may see any file below the directory at
+// path or if there are no files at all.
+// Callers must hold at least a read lock.
+func (mt *Trie) dirVisibleTo(path, principal string) bool {
+	files := false
+	for _, e := range listRecursive(path, path, mt.Root) {
+		if e.IsDirectory() {
+			continue
+		}
+		if e.VisibleTo(principal) {
+			return true
+		}
+		files = true
+	}
+	return !files
+}
@@ -0,0 +1,116 @@
+package triefs_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func aclEntry(path string, acl ...string) *triefs.Entry {
+	e := triefs.NewEntry(path, "cid", 1, triefs.MIMEOctetStream, time.Unix(1000, 0))
+	e.ACL = acl
+	return e
+}
+
+func TestLsAs(t *testing.T) {
+	t.Parallel()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		aclEntry("/shared/public.txt"),
+		aclEntry("/shared/alice.txt", "alice"),
+		aclEntry("/shared/both.txt", "alice", "bob"),
+		aclEntry("/shared/bob/notes.txt", "bob"),
+		aclEntry("/shared/mixed/alice.txt", "alice"),
+		aclEntry("/shared/mixed/public.txt"),
+		triefs.NewEntry("/shared/empty", "", 0, triefs.MIMEDriveEntry, time.Unix(1000, 0)),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		principal string
+		path      string
+		expected  []string
+	}{
+		{principal: "alice", path: "/shared", expected: []string{"alice.txt", "both.txt", "empty", "mixed", "public.txt"}},
+		{principal: "bob", path: "/shared", expected: []string{"bob", "both.txt", "empty", "mixed", "public.txt"}},
+		{principal: "eve", path: "/shared", expected: []string{"empty", "mixed", "public.txt"}},
+		{principal: "eve", path: "/shared/mixed", expected: []string{"public.txt"}},
+		{principal: "alice", path: "/shared/mixed", expected: []string{"alice.txt", "public.txt"}},
+		{principal: "alice", path: "/", expected: []string{"shared"}},
+		{principal: "alice", path: "/missing", expected: []string{}},
+	}
+	for _, tc := range cases {
+		got := make([]string, 0)
+		for _, c := range trie.LsAs(tc.path, tc.principal) {
+			got = append(got, c.Name)
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%v %v: got %v, want %v", tc.principal, tc.path, got, tc.expected)
+		}
+	}
+}
+
+func TestACLRoundTrip(t *testing.T) {
+	t.Parallel()
+	trie := triefs.NewTrie()
+	if _, err := trie.AddFile(aclEntry("/a/file", "alice", "bob")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before, err := trie.Hash()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := trie.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decoded := triefs.NewTrie()
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	manifest, err := trie.MarshalManifest()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded, err := triefs.LoadManifest(manifest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := trie.WriteTar(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	untarred, err := triefs.ReadTar(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tr := range []*triefs.Trie{decoded, loaded, untarred} {
+		f, err := tr.File("/a/file")
+		if err != nil || !reflect.DeepEqual(f.ACL, []string{"alice", "bob"}) {
+			t.Errorf("got %v %v, want %v", f, err, []string{"alice", "bob"})
+		}
+	}
+
+	// changing only the ACL changes the hash
+	_, _, err = trie.Replace("/a/file", &triefs.Content{CID: "cid", Size: 1, CreatedAt: 1000, ACL: []string{"alice"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after, err := trie.Hash()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if before == after {
+		t.Errorf("got equal hashes %v, want different", after)
+	}
+	if got := trie.LsAs("/a", "bob"); len(got) != 0 {
+		t.Errorf("got %v, want empty", got)
+	}
+}
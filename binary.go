@@ -0,0 +1,302 @@
+package triefs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// MarshalBinary encodes the trie nodes as is, so the decoded trie
+// has exactly the same structure and Hash
+func (mt *Trie) MarshalBinary() ([]byte, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	return mt.marshalBinary(), nil
+}
+
+// marshalBinary is the lock-free core of MarshalBinary.
+// Callers must hold at least a read lock.
+func (mt *Trie) marshalBinary() []byte {
+	if mt.Root == nil {
+		return []byte{0}
+	}
+	return appendEntry([]byte{1}, mt.Root)
+}
+
+// EstimateSize returns the number of bytes MarshalBinary would produce
+// without encoding the trie, e.g. to pre-size buffers or reject large tries
+func (mt *Trie) EstimateSize() int {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if mt.Root == nil {
+		return 1
+	}
+	return 1 + entrySize(mt.Root)
+}
+
+// UnmarshalBinary replaces the trie contents with data produced by MarshalBinary
+func (mt *Trie) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	flag, err := r.ReadByte()
+	if err != nil {
+		return ErrInvalidBinary
+	}
+
+	var root *Entry
+	if flag == 1 {
+		root, err = readEntry(r)
+		if err != nil {
+			return err
+		}
+	} else if flag != 0 {
+		return ErrInvalidBinary
+	}
+	if r.Len() != 0 {
+		return ErrInvalidBinary
+	}
+
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	mt.reset(root)
+	return nil
+}
+
+func appendEntry(b []byte, e *Entry) []byte {
+	b = appendString(b, e.Path)
+	b = appendString(b, e.Name)
+	b = appendString(b, e.CID)
+	b = appendString(b, e.Type)
+	b = binary.AppendVarint(b, e.Size)
+	b = append(b, e.Version)
+	b = binary.AppendVarint(b, e.CreatedAt)
+	b = appendString(b, e.DetectedType)
+	b = appendString(b, e.Checksum)
+	b = appendString(b, e.ID)
+	b = binary.AppendVarint(b, e.RefEntries)
+	b = binary.AppendVarint(b, e.ModifiedAt)
+	b = binary.AppendUvarint(b, uint64(len(e.ACL)))
+	for _, p := range e.ACL {
+		b = appendString(b, p)
+	}
+
+	keys := make([]string, 0, len(e.Metadata))
+	for k := range e.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b = binary.AppendUvarint(b, uint64(len(keys)))
+	for _, k := range keys {
+		b = appendString(b, k)
+		b = appendString(b, e.Metadata[k])
+	}
+
+	if e.Meta == nil {
+		b = append(b, 0)
+	} else {
+		b = append(b, 1)
+		b = binary.AppendVarint(b, int64(e.Meta.FailureCode))
+		b = appendString(b, e.Meta.FailedMessage)
+		b = appendString(b, e.Meta.SuggestedAction)
+	}
+
+	// nil and empty children differ in JSON and so in Hash
+	if e.Entries == nil {
+		return binary.AppendUvarint(b, 0)
+	}
+	b = binary.AppendUvarint(b, uint64(len(e.Entries))+1)
+	for _, me := range e.Entries {
+		b = appendEntry(b, me)
+	}
+	return b
+}
+
+// entrySize is the length of appendEntry output for e
+func entrySize(e *Entry) int {
+	n := stringSize(e.Path) + stringSize(e.Name) + stringSize(e.CID) + stringSize(e.Type)
+	n += varintSize(e.Size) + 1 + varintSize(e.CreatedAt)
+	n += stringSize(e.DetectedType) + stringSize(e.Checksum) + stringSize(e.ID) + varintSize(e.RefEntries)
+	n += varintSize(e.ModifiedAt)
+	n += uvarintSize(uint64(len(e.ACL)))
+	for _, p := range e.ACL {
+		n += stringSize(p)
+	}
+	n += uvarintSize(uint64(len(e.Metadata)))
+	for k, v := range e.Metadata {
+		n += stringSize(k) + stringSize(v)
+	}
+
+	n++
+	if e.Meta != nil {
+		n += varintSize(int64(e.Meta.FailureCode)) + stringSize(e.Meta.FailedMessage) + stringSize(e.Meta.SuggestedAction)
+	}
+
+	if e.Entries == nil {
+		return n + 1
+	}
+	n += uvarintSize(uint64(len(e.Entries)) + 1)
+	for _, me := range e.Entries {
+		n += entrySize(me)
+	}
+	return n
+}
+
+func stringSize(s string) int {
+	return uvarintSize(uint64(len(s))) + len(s)
+}
+
+func uvarintSize(x uint64) int {
+	n := 1
+	for ; x >= 0x80; x >>= 7 {
+		n++
+	}
+	return n
+}
+
+// varintSize is the length of the zig-zag encoding used by binary.AppendVarint
+func varintSize(x int64) int {
+	ux := uint64(x) << 1
+	if x < 0 {
+		ux = ^ux
+	}
+	return uvarintSize(ux)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func readEntry(r *bytes.Reader) (*Entry, error) {
+	e := &Entry{}
+	var err error
+	for _, s := range []*string{&e.Path, &e.Name, &e.CID, &e.Type} {
+		*s, err = readString(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	e.Size, err = binary.ReadVarint(r)
+	if err != nil {
+		return nil, ErrInvalidBinary
+	}
+	e.Version, err = r.ReadByte()
+	if err != nil {
+		return nil, ErrInvalidBinary
+	}
+	e.CreatedAt, err = binary.ReadVarint(r)
+	if err != nil {
+		return nil, ErrInvalidBinary
+	}
+	for _, s := range []*string{&e.DetectedType, &e.Checksum, &e.ID} {
+		*s, err = readString(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	e.RefEntries, err = binary.ReadVarint(r)
+	if err != nil {
+		return nil, ErrInvalidBinary
+	}
+	e.ModifiedAt, err = binary.ReadVarint(r)
+	if err != nil {
+		return nil, ErrInvalidBinary
+	}
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	if n != 0 {
+		e.ACL = make([]string, n)
+	}
+	for i := range e.ACL {
+		e.ACL[i], err = readString(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	n, err = readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	if n != 0 {
+		e.Metadata = make(map[string]string, n)
+	}
+	for i := 0; i < n; i++ {
+		k, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		e.Metadata[k] = v
+	}
+
+	flag, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrInvalidBinary
+	}
+	if flag == 1 {
+		code, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, ErrInvalidBinary
+		}
+		e.Meta = &Meta{FailureCode: int(code)}
+		e.Meta.FailedMessage, err = readString(r)
+		if err != nil {
+			return nil, err
+		}
+		e.Meta.SuggestedAction, err = readString(r)
+		if err != nil {
+			return nil, err
+		}
+	} else if flag != 0 {
+		return nil, ErrInvalidBinary
+	}
+
+	n, err = readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return e, nil
+	}
+	e.Entries = make([]*Entry, 0, n-1)
+	for i := 0; i < n-1; i++ {
+		me, err := readEntry(r)
+		if err != nil {
+			return nil, err
+		}
+		e.Entries = append(e.Entries, me)
+	}
+	e.reindex()
+	return e, nil
+}
+
+// readCount reads a length which can't exceed the rest of the input
+func readCount(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len())+1 {
+		return 0, ErrInvalidBinary
+	}
+	return int(n), nil
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := readCount(r)
+	if err != nil || n > r.Len() {
+		return "", ErrInvalidBinary
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return "", ErrInvalidBinary
+	}
+	return string(b), nil
+}
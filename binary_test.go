@@ -0,0 +1,122 @@
+package triefs_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestBinaryRoundtrip(t *testing.T) {
+	t.Parallel()
+	trie := tarTrie(t)
+	trie.InferTypes()
+	failed := triefs.NewEntry("/failed.bin", "cid5", 1, triefs.MIMEOctetStream, time.Now())
+	failed.AddMeta(500, "upload failed", "retry")
+	if _, err := trie.AddFile(failed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := trie.Delete("/docs/sub/big.bin"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := trie.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded := triefs.NewTrie()
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.Root, trie.Root) {
+		t.Errorf("got %v, want %v", loaded.Root, trie.Root)
+	}
+
+	gotHash, _ := loaded.Hash()
+	wantHash, _ := trie.Hash()
+	if gotHash != wantHash {
+		t.Errorf("got %v, want %v", gotHash, wantHash)
+	}
+}
+
+func TestBinaryEmpty(t *testing.T) {
+	t.Parallel()
+	data, err := triefs.NewTrie().MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded := tarTrie(t)
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loaded.Root != nil {
+		t.Errorf("got %v, want nil", loaded.Root)
+	}
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	t.Parallel()
+	data, err := tarTrie(t).MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: []byte{}},
+		{name: "unknown flag", data: []byte{2}},
+		{name: "truncated", data: data[:len(data)/2]},
+		{name: "trailing bytes", data: append(append([]byte{}, data...), 0)},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := triefs.NewTrie()
+			if err := trie.UnmarshalBinary(tc.data); err != triefs.ErrInvalidBinary {
+				t.Errorf("got %v, want %v", err, triefs.ErrInvalidBinary)
+			}
+		})
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	t.Parallel()
+	failed := tarTrie(t)
+	e := triefs.NewEntry("/failed.bin", "cid5", 1, triefs.MIMEOctetStream, time.Now())
+	e.AddMeta(500, "upload failed", "retry")
+	if _, err := failed.AddFile(e); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		trie *triefs.Trie
+	}{
+		{name: "empty", trie: triefs.NewTrie()},
+		{name: "tar", trie: tarTrie(t)},
+		{name: "nested", trie: nestedTrie(t)},
+		{name: "meta", trie: failed},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			data, err := tc.trie.MarshalBinary()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, want := tc.trie.EstimateSize(), len(data)
+			if got < want*8/10 || got > want*12/10 {
+				t.Errorf("got %v, want %v within 20%%", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package triefs
+
+import "sort"
+
+// FindByCID returns sorted absolute paths of all entries referencing cid.
+// Files and references are considered, directories and symlinks are not
+// since their CID doesn't point to a blob
+func (mt *Trie) FindByCID(cid string) []string {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	paths := make([]string, 0)
+	for _, e := range mt.blobEntries() {
+		if e.CID == cid {
+			paths = append(paths, e.Path)
+		}
+	}
+	return mt.swapPaths(paths)
+}
+
+// RefCount returns number of entries referencing cid, see FindByCID
+func (mt *Trie) RefCount(cid string) int {
+	return len(mt.FindByCID(cid))
+}
+
+// DistinctCIDs returns every CID of the trie with the number of entries
+// referencing it, counted like RefCount. References count under their bucket
+// ID since it's what they point to
+func (mt *Trie) DistinctCIDs() map[string]int {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	res := make(map[string]int)
+	for _, e := range mt.blobEntries() {
+		res[e.CID]++
+	}
+	return res
+}
+
+// GroupByCID returns every CID of the trie with sorted absolute paths of
+// entries referencing it, like FindByCID for each of them. Directories and
+// symlinks are omitted
+func (mt *Trie) GroupByCID() map[string][]string {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	res := make(map[string][]string)
+	for _, e := range mt.blobEntries() {
+		res[e.CID] = append(res[e.CID], e.Path)
+	}
+	for cid, paths := range res {
+		res[cid] = mt.swapPaths(paths)
+	}
+	return res
+}
+
+// UniqueSize returns total Size of distinct blobs, entries sharing a CID are
+// counted once using the size of the first one by path. References have no
+// size of their own
+func (mt *Trie) UniqueSize() int64 {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	seen := make(map[string]bool)
+	var size int64
+	for _, e := range mt.blobEntries() {
+		if e.Type == MIMEReference || seen[e.CID] {
+			continue
+		}
+		seen[e.CID] = true
+		size += e.Size
+	}
+	return size
+}
+
+// UnreferencedCIDs returns sorted CIDs from the live set of known blobs
+// that aren't referenced by any entry of the trie, so they can be collected
+func (mt *Trie) UnreferencedCIDs(live map[string]bool) []string {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	referenced := make(map[string]bool)
+	for _, e := range mt.blobEntries() {
+		referenced[e.CID] = true
+	}
+
+	res := make([]string, 0)
+	for cid, ok := range live {
+		if ok && !referenced[cid] {
+			res = append(res, cid)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// VerifyChecksums recomputes checksums of files having one stored by calling
+// compute with their CID and returns sorted paths of mismatching files. Files
+// compute fails for are reported as well. compute is called without holding
+// the trie lock
+func (mt *Trie) VerifyChecksums(compute func(cid string) (string, error)) []string {
+	mt.lock.RLock()
+	entries := filterEntries(mt.blobEntries(), func(e *Entry) bool {
+		return e.Type != MIMEReference && len(e.Checksum) != 0
+	})
+	mt.lock.RUnlock()
+
+	paths := make([]string, 0)
+	for _, e := range entries {
+		sum, err := compute(e.CID)
+		if err != nil || sum != e.Checksum {
+			paths = append(paths, e.Path)
+		}
+	}
+	return mt.swapPaths(paths)
+}
+
+// blobEntries returns all entries which CID points to a blob sorted by path.
+// Callers must hold at least a read lock.
+func (mt *Trie) blobEntries() []*Entry {
+	return filterEntries(mt.lsRecursive(Separator), func(e *Entry) bool {
+		return !e.IsDirectory() && e.Type != MIMESymlink && len(e.CID) != 0
+	})
+}
+
+// RefEntry describes a reference created by CreateRef
+type RefEntry struct {
+	Path     string `json:"path"`
+	BucketID string `json:"bucket_id"`
+	// Size of the replaced subtree, set by CreateRefWithSize only
+	Size int64 `json:"size,omitempty"`
+}
+
+// ListRefs returns all references of the trie sorted by path
+func (mt *Trie) ListRefs() []RefEntry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	return mt.listRefs()
+}
+
+// RefsBySize returns all references sorted by Size, largest first, so big
+// restores can start early. References created without the size have zero
+// Size and, like all references of equal size, keep path order
+func (mt *Trie) RefsBySize() []RefEntry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	refs := mt.listRefs()
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].Size > refs[j].Size
+	})
+	return refs
+}
+
+// listRefs is the lock-free core of ListRefs.
+// Callers must hold at least a read lock.
+func (mt *Trie) listRefs() []RefEntry {
+	refs := make([]RefEntry, 0)
+	for _, e := range mt.lsRecursive(Separator) {
+		if e.Type == MIMEReference {
+			refs = append(refs, RefEntry{Path: mt.swap(e.Path), BucketID: e.CID, Size: e.Size})
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].Path < refs[j].Path
+	})
+	return refs
+}
@@ -0,0 +1,252 @@
+package triefs_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestRefCount(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	dirs := []*triefs.Entry{
+		triefs.NewEntry("/docs/a.txt", "shared", 10, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/backup/a.txt", "shared", 10, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/b.txt", "single", 20, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/links/a", "shared", 0, triefs.MIMESymlink, now),
+		triefs.NewEntry("/empty", "", 0, triefs.MIMEDriveEntry, now),
+	}
+	for _, d := range dirs {
+		_, err := trie.AddFile(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths := trie.FindByCID("shared")
+	if !reflect.DeepEqual(paths, []string{"/backup/a.txt", "/docs/a.txt"}) {
+		t.Errorf("got %v, want %v", paths, []string{"/backup/a.txt", "/docs/a.txt"})
+	}
+	if trie.RefCount("shared") != 2 {
+		t.Errorf("got %v, want %v", trie.RefCount("shared"), 2)
+	}
+
+	err := trie.Delete("/docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trie.RefCount("shared") != 1 {
+		t.Errorf("got %v, want %v", trie.RefCount("shared"), 1)
+	}
+
+	live := map[string]bool{"shared": true, "single": true, "orphan": true, "gone": true}
+	unreferenced := trie.UnreferencedCIDs(live)
+	if !reflect.DeepEqual(unreferenced, []string{"gone", "orphan"}) {
+		t.Errorf("got %v, want %v", unreferenced, []string{"gone", "orphan"})
+	}
+
+	err = trie.Delete("/backup/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trie.RefCount("shared") != 0 {
+		t.Errorf("got %v, want %v", trie.RefCount("shared"), 0)
+	}
+	unreferenced = trie.UnreferencedCIDs(live)
+	if !reflect.DeepEqual(unreferenced, []string{"gone", "orphan", "shared"}) {
+		t.Errorf("got %v, want %v", unreferenced, []string{"gone", "orphan", "shared"})
+	}
+}
+
+func TestListRefs(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	dirs := []*triefs.Entry{
+		triefs.NewEntry("/aaa/bbb/file", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/ccc/fbb/f", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/ccc/fiee/file", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/file", "test_cid", 512, triefs.MIMEOctetStream, now),
+	}
+	for _, d := range dirs {
+		_, err := trie.AddFile(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	refs := trie.ListRefs()
+	if !reflect.DeepEqual(refs, []triefs.RefEntry{}) {
+		t.Errorf("got %v, want empty", refs)
+	}
+
+	if _, err := trie.CreateRef("/ccc", "bucket-dir", now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trie.CreateRef("/aaa/bbb/file", "bucket-file", now); err != nil {
+		t.Fatal(err)
+	}
+
+	refs = trie.ListRefs()
+	expected := []triefs.RefEntry{
+		{Path: "/aaa/bbb/file", BucketID: "bucket-file"},
+		{Path: "/ccc", BucketID: "bucket-dir"},
+	}
+	if !reflect.DeepEqual(refs, expected) {
+		t.Errorf("got %v, want %v", refs, expected)
+	}
+}
+
+func TestRefsBySize(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	dirs := []*triefs.Entry{
+		triefs.NewEntry("/aaa/small", "test_cid", 10, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/bbb/big/f1", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/bbb/big/f2", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/ccc/unknown", "test_cid", 2048, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/ddd/unknown", "test_cid", 2048, triefs.MIMEOctetStream, now),
+	}
+	for _, d := range dirs {
+		_, err := trie.AddFile(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range []string{"/aaa/small", "/bbb/big"} {
+		if _, err := trie.CreateRefWithSize(p, "bucket"+p, now, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// sizes aren't recorded without keepSize
+	for _, p := range []string{"/ddd", "/ccc"} {
+		if _, err := trie.CreateRef(p, "bucket"+p, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []triefs.RefEntry{
+		{Path: "/bbb/big", BucketID: "bucket/bbb/big", Size: 1024},
+		{Path: "/aaa/small", BucketID: "bucket/aaa/small", Size: 10},
+		{Path: "/ccc", BucketID: "bucket/ccc"},
+		{Path: "/ddd", BucketID: "bucket/ddd"},
+	}
+	if refs := trie.RefsBySize(); !reflect.DeepEqual(refs, expected) {
+		t.Errorf("got %v, want %v", refs, expected)
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	sums := map[string]string{"cid1": "sum1", "cid2": "sum2", "cid3": "sum3"}
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/ok.txt", "cid1", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/corrupted.txt", "cid2", 2, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/missing.txt", "lost", 3, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/unchecked.txt", "cid3", 4, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/link", "cid2", 0, triefs.MIMESymlink, now),
+	} {
+		e.Checksum = sums[e.CID]
+		if e.Name == "corrupted.txt" || e.Name == "missing.txt" || e.Type == triefs.MIMESymlink {
+			e.Checksum = "stale"
+		}
+		if e.Name == "unchecked.txt" {
+			e.Checksum = ""
+		}
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compute := func(cid string) (string, error) {
+		sum, ok := sums[cid]
+		if !ok {
+			return "", triefs.ErrFileNotExist
+		}
+		return sum, nil
+	}
+	expected := []string{"/docs/corrupted.txt", "/docs/missing.txt"}
+	if got := trie.VerifyChecksums(compute); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+
+	c := triefs.NewContent("corrupted.txt", "cid2", 2, triefs.MIMEOctetStream, now)
+	c.Checksum = "sum2"
+	if _, _, err := trie.Replace("/docs/corrupted.txt", &c); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"/docs/missing.txt"}
+	if got := trie.VerifyChecksums(compute); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+}
+
+func TestDistinctCIDs(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/a.bin", "shared", 100, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/copies/a.bin", "shared", 100, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/copies/b.bin", "shared", 100, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/single.bin", "single", 20, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/link", "shared", 0, triefs.MIMESymlink, now),
+		triefs.NewEntry("/empty", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/shared/x.bin", "remote", 7, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := trie.CreateRef("/shared", "bucket", now); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int{"shared": 3, "single": 1, "bucket": 1}
+	if got := trie.DistinctCIDs(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+	if got := trie.UniqueSize(); got != 120 {
+		t.Errorf("got %v, want %v", got, 120)
+	}
+}
+
+func TestGroupByCID(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/copies/b.bin", "first", 100, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/a.bin", "first", 100, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/z/second.bin", "second", 20, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/copies/a.bin", "first", 100, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/b/second.bin", "second", 20, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/link", "first", 0, triefs.MIMESymlink, now),
+		triefs.NewEntry("/empty", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string][]string{
+		"first":  {"/a.bin", "/copies/a.bin", "/copies/b.bin"},
+		"second": {"/b/second.bin", "/z/second.bin"},
+	}
+	got := trie.GroupByCID()
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+	for cid, paths := range got {
+		if want := trie.FindByCID(cid); !reflect.DeepEqual(paths, want) {
+			t.Errorf("got %v, want %v", paths, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package triefs
+
+import "path/filepath"
+
+// Len returns the number of files and directories in the trie, root not
+// included, the same as LsRecursive of root returns. It's maintained on
+// every change, so Root must not be changed directly
+func (mt *Trie) Len() int {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	return mt.files + mt.dirs
+}
+
+// DirAgg holds totals of a directory subtree, see Aggregate
+type DirAgg struct {
+	// FileCount is the number of files, references included
+	FileCount int
+	// DirCount is the number of directories, the directory itself not included
+	DirCount int
+	// TotalSize is the same as DiskUsage of the directory
+	TotalSize int64
+}
+
+// Aggregate returns DirAgg of the directory at path and of every directory
+// beneath it keyed by absolute path. Every subtree is summed once, so the
+// whole map takes a single traversal. Returns nil if path is missing or
+// isn't a directory
+func (mt *Trie) Aggregate(path string) map[string]DirAgg {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if len(path) == 0 {
+		return nil
+	}
+	p := CleanPath(mt.swap(path))
+	c, err := mt.statPath(p)
+	if err != nil || !c.IsDirectory() {
+		return nil
+	}
+
+	entries := mt.lsRecursive(p)
+	paths := make([]string, len(entries))
+	aggs := map[string]DirAgg{p: {}}
+	for i, e := range entries {
+		paths[i] = JoinPath(p, e.Path)
+		if e.IsDirectory() {
+			aggs[paths[i]] = DirAgg{}
+		}
+	}
+
+	// entries follow their directories, so going backwards every subtree is
+	// complete before it's added to its parent
+	for i := len(entries) - 1; i >= 0; i-- {
+		parent := filepath.Dir(paths[i])
+		agg := aggs[parent]
+		if e := entries[i]; e.IsDirectory() {
+			sub := aggs[paths[i]]
+			agg.FileCount += sub.FileCount
+			agg.DirCount += sub.DirCount + 1
+			agg.TotalSize += sub.TotalSize
+		} else {
+			agg.FileCount++
+			agg.TotalSize += usage(&e.Content)
+		}
+		aggs[parent] = agg
+	}
+
+	if !mt.custom() {
+		return aggs
+	}
+	res := make(map[string]DirAgg, len(aggs))
+	for dir, agg := range aggs {
+		res[mt.swap(dir)] = agg
+	}
+	return res
+}
+
+// reset replaces the trie contents with root recounting everything kept
+// along with them.
+// Callers must hold the write lock.
+func (mt *Trie) reset(root *Entry) {
+	mt.Root = root
+	mt.pool.tree(root)
+	mt.cache.clear()
+	mt.epoch++
+	mt.used, mt.files, mt.dirs = mt.count(Separator)
+	mt.reindexSearch()
+}
+
+// counted adds sign times the number of files and directories among entries.
+// Callers must hold the write lock.
+func (mt *Trie) counted(entries []*Entry, sign int) {
+	for _, e := range entries {
+		if e.IsDirectory() {
+			mt.dirs += sign
+		} else {
+			mt.files += sign
+		}
+	}
+}
+
+// count returns the size counted against the quota and numbers of files
+// and directories at cleaned path p, p itself included unless it's root.
+// Callers must hold at least a read lock.
+func (mt *Trie) count(p string) (int64, int, int) {
+	if mt.Root == nil {
+		return 0, 0, 0
+	}
+
+	var used int64
+	var files, dirs int
+	if p != Separator {
+		c := stat(p, mt.Root)
+		if c == nil {
+			return 0, 0, 0
+		}
+		if !c.IsDirectory() {
+			return usage(c), 1, 0
+		}
+		dirs++
+	}
+	for _, e := range mt.lsRecursive(p) {
+		if e.IsDirectory() {
+			dirs++
+			continue
+		}
+		files++
+		used += usage(&e.Content)
+	}
+	return used, files, dirs
+}
@@ -0,0 +1,166 @@
+package triefs_test
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestLen(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	segments := []string{"a", "ab", "b", "ba"}
+	for _, opts := range []triefs.Options{{}, {NoEmptyDirs: true}, {KeepSorted: true}} {
+		rnd := rand.New(rand.NewSource(888))
+		randomPath := func() string {
+			parts := make([]string, 1+rnd.Intn(3))
+			for i := range parts {
+				parts[i] = segments[rnd.Intn(len(segments))]
+			}
+			return "/" + strings.Join(parts, "/")
+		}
+
+		trie, err := triefs.NewTrieWithOptions(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		steps := make([]string, 0)
+		for i := 0; i < 400; i++ {
+			p, q := randomPath(), randomPath()
+			switch rnd.Intn(10) {
+			case 0:
+				steps = append(steps, "delete "+p)
+				_ = trie.Delete(p)
+			case 1:
+				steps = append(steps, "delete all "+p)
+				_ = trie.DeleteAll(p)
+			case 2:
+				steps = append(steps, "move "+p+" "+q)
+				_ = trie.Move(p, q)
+			case 3:
+				steps = append(steps, "move into "+p+" "+q)
+				_, _ = trie.MoveInto(p, q)
+			case 4:
+				steps = append(steps, "ref "+p)
+				_, _ = trie.CreateRef(p, "bucket", now)
+			case 5:
+				steps = append(steps, "add dir "+p)
+				_, _ = trie.AddFile(triefs.NewEntry(p, "", 0, triefs.MIMEDriveEntry, now))
+			case 6:
+				steps = append(steps, "upsert "+p)
+				_, _ = trie.Upsert(p, &triefs.Content{CID: "cid", Size: 1})
+			case 7:
+				steps = append(steps, "add to dir "+p)
+				_, _ = trie.AddToDir(p, []*triefs.Entry{triefs.NewEntry("x/y", "cid", 1, triefs.MIMEOctetStream, now)})
+			default:
+				steps = append(steps, "add "+p)
+				_, _ = trie.AddFile(triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now))
+			}
+
+			if got, want := trie.Len(), len(trie.LsRecursive("/")); got != want {
+				t.Fatalf("got %v, want %v after %v", got, want, strings.Join(steps, ", "))
+			}
+			if err := trie.Validate(); err != nil {
+				t.Fatalf("got %v after %v", err, strings.Join(steps, ", "))
+			}
+		}
+	}
+}
+
+func TestLenDecoded(t *testing.T) {
+	t.Parallel()
+	trie := tarTrie(t)
+	want := len(trie.LsRecursive("/"))
+	if got := trie.Len(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	data, err := json.Marshal(trie)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fromJSON := triefs.Trie{}
+	if err := json.Unmarshal(data, &fromJSON); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err = trie.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fromBinary := triefs.NewTrie()
+	if err := fromBinary.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, loaded := range []*triefs.Trie{&fromJSON, fromBinary} {
+		if got := loaded.Len(); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if err := loaded.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/aaa", "test_cid", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/aaa/bbb/file1.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/bba/file2.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/bbb/aaa/file1.txt", "test_cid", 0, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	all := map[string]triefs.DirAgg{
+		"/":        {FileCount: 3, DirCount: 5, TotalSize: 1024},
+		"/aaa":     {FileCount: 2, DirCount: 2, TotalSize: 1024},
+		"/aaa/bbb": {FileCount: 1, DirCount: 0, TotalSize: 512},
+		"/aaa/bba": {FileCount: 1, DirCount: 0, TotalSize: 512},
+		"/bbb":     {FileCount: 1, DirCount: 1, TotalSize: 0},
+		"/bbb/aaa": {FileCount: 1, DirCount: 0, TotalSize: 0},
+	}
+	cases := []struct {
+		name     string
+		path     string
+		expected map[string]triefs.DirAgg
+	}{
+		{name: "root", path: "/", expected: all},
+		{name: "directory", path: "/aaa/", expected: map[string]triefs.DirAgg{
+			"/aaa":     all["/aaa"],
+			"/aaa/bbb": all["/aaa/bbb"],
+			"/aaa/bba": all["/aaa/bba"],
+		}},
+		{name: "leaf directory", path: "/bbb/aaa", expected: map[string]triefs.DirAgg{"/bbb/aaa": all["/bbb/aaa"]}},
+		{name: "file", path: "/aaa/bbb/file1.txt"},
+		{name: "missing", path: "/ccc"},
+	}
+
+	for _, tc := range cases {
+		got := trie.Aggregate(tc.path)
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%v: got %v, want %v", tc.name, got, tc.expected)
+		}
+	}
+
+	for dir, agg := range trie.Aggregate("/") {
+		size, err := trie.DiskUsage(dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if agg.TotalSize != size {
+			t.Errorf("%v: got %v, want %v", dir, agg.TotalSize, size)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package triefs
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+)
+
+// AddToDir adds entries under the existing directory dir under a single
+// lock. Paths of entries are relative to dir and may contain subdirectories.
+// The trie ends up as after AddFile of every entry in order, but nothing is
+// added if any of them collides with an existing entry or with each other,
+// ErrConflict is returned instead. Files equal to existing ones are skipped
+// if Options.IdempotentReAdd is set. Returns the added entries like AddFile
+func (mt *Trie) AddToDir(dir string, entries []*Entry) ([]*Entry, error) {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if len(dir) == 0 {
+		return nil, ErrEmptyPath
+	}
+
+	d := CleanPath(mt.swap(dir))
+	err := mt.checkDir(d)
+	if err != nil {
+		return nil, err
+	}
+
+	// every entry lands below d, so descend to it once for the whole batch
+	n, prefix := mt.dirNode(d)
+	batch := make([]*Entry, 0, len(entries))
+	// paths taken by the batch, true for files and false for directories
+	taken := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if e == nil {
+			return nil, ErrConflict
+		}
+		if mt.noEmptyDirs && e.Type == MIMEDriveEntry {
+			return nil, ErrEmptyDirNotAllowed
+		}
+		err := mt.checkType(e.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		m := e.copy()
+		if mt.custom() {
+			m = mt.internalEntry(e)
+		}
+		m.Path = JoinPath(d, m.Path)
+		if m.Path == d {
+			return nil, ErrEmptyPath
+		}
+		err = mt.prepare(m)
+		if err != nil {
+			return nil, err
+		}
+
+		if mt.idempotentReAdd && m.Type != MIMEDriveEntry && mt.Root != nil {
+			if f := find(m.Path, mt.Root); f != nil && f.Equal(&m.Content) {
+				if _, ok := taken[m.Path]; ok {
+					return nil, ErrConflict
+				}
+				taken[m.Path] = true
+				continue
+			}
+		}
+		err = mt.checkFree(m.Path, d, taken, n, prefix)
+		if err != nil {
+			return nil, err
+		}
+		taken[m.Path] = m.Type != MIMEDriveEntry
+		batch = append(batch, m)
+	}
+
+	rollback := mt.savepoint()
+	// the trie keeps entries of the batch, their paths are kept aside
+	paths := make([]string, 0, len(batch))
+	res := make([]*Entry, 0, len(batch))
+	for _, m := range batch {
+		ok, err := mt.admit(m)
+		if err == nil && ok {
+			paths = append(paths, m.Path)
+			if n == nil {
+				n, prefix = mt.dirNode(d)
+			}
+			var added []*Entry
+			added, err = mt.insertAt(m, n, prefix)
+			res = append(res, added...)
+		}
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+	for _, p := range paths {
+		mt.notify(OpCreate, p, nil)
+	}
+	return mt.swapEntries(res), nil
+}
+
+// SyncDir makes the existing directory dir hold exactly desired entries under
+// a single lock. Paths of desired are relative to dir like in AddToDir.
+// Entries missing from desired are removed, new ones are added and files
+// with different content, see Content.Equal, are replaced in place bumping
+// their Version like Upsert, while equal ones are left untouched. Version of
+// desired entries isn't compared. Entries turning from files to directories
+// or back are removed and added anew. Returns sorted absolute paths of added,
+// replaced included, and removed entries. Nothing changes if any entry can't
+// be added
+func (mt *Trie) SyncDir(dir string, desired []*Entry) ([]string, []string, error) {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if len(dir) == 0 {
+		return nil, nil, ErrEmptyPath
+	}
+
+	d := CleanPath(mt.swap(dir))
+	err := mt.checkDir(d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	batch := make([]*Entry, 0, len(desired))
+	for _, e := range desired {
+		if e == nil {
+			return nil, nil, ErrConflict
+		}
+		if mt.noEmptyDirs && e.Type == MIMEDriveEntry {
+			return nil, nil, ErrEmptyDirNotAllowed
+		}
+		err := mt.checkType(e.Type)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		m := e.copy()
+		if mt.custom() {
+			m = mt.internalEntry(e)
+		}
+		if rel := CleanPath(m.Path); len(rel) == 0 || rel == Separator {
+			return nil, nil, ErrEmptyPath
+		}
+		m.Path = JoinPath(d, m.Path)
+		m.Normalize()
+		err = m.Validate()
+		if err != nil {
+			return nil, nil, err
+		}
+		batch = append(batch, m)
+	}
+	err = PreflightBatch(batch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// desired paths along with their parents, nil for implied directories
+	wanted := make(map[string]*Entry)
+	for _, m := range batch {
+		wanted[m.Path] = m
+		for p := filepath.Dir(m.Path); p != d; p = filepath.Dir(p) {
+			if _, ok := wanted[p]; !ok {
+				wanted[p] = nil
+			}
+		}
+	}
+
+	rollback := mt.savepoint()
+	kept := make(map[string]bool)
+	// files replaced in place
+	changed := make(map[string]bool)
+	gone := make(map[string]*Content)
+	current := mt.lsRecursive(d)
+	for i := len(current) - 1; i >= 0; i-- {
+		e := current[i]
+		p := JoinPath(d, e.Path)
+		if w, ok := wanted[p]; ok {
+			dir := w == nil || w.IsDirectory()
+			if dir && e.IsDirectory() {
+				kept[p] = true
+				continue
+			}
+			if !dir && !e.IsDirectory() {
+				c := w.Content
+				c.Version = e.Version
+				kept[p] = e.Equal(&c)
+				changed[p] = !kept[p]
+				continue
+			}
+		}
+		mt.remove(p)
+		gone[p] = e.Content.copy()
+	}
+
+	added := make([]string, 0)
+	for _, m := range batch {
+		if kept[m.Path] || m.IsDirectory() && mt.Root != nil && stat(m.Path, mt.Root) != nil {
+			continue
+		}
+		if changed[m.Path] {
+			f := find(m.Path, mt.Root)
+			_, err := mt.replace(m.Path, f, &m.Content, m.Type)
+			if err != nil {
+				rollback()
+				return nil, nil, err
+			}
+			if f.Version < math.MaxUint8 {
+				f.Version++
+			}
+			added = append(added, m.Path)
+			continue
+		}
+		_, err := mt.addFile(m)
+		if err != nil {
+			rollback()
+			return nil, nil, err
+		}
+		added = append(added, m.Path)
+	}
+
+	for _, p := range added {
+		if changed[p] {
+			mt.notify(OpUpdate, p, nil)
+			continue
+		}
+		if _, ok := gone[p]; ok {
+			delete(gone, p)
+			mt.notify(OpUpdate, p, nil)
+			continue
+		}
+		mt.notify(OpCreate, p, nil)
+	}
+	removed := make([]string, 0, len(gone))
+	for p := range gone {
+		removed = append(removed, p)
+	}
+	sort.Strings(removed)
+	for _, p := range removed {
+		mt.notify(OpRemove, p, gone[p])
+	}
+	sort.Strings(added)
+	return mt.swapPaths(added), mt.swapPaths(removed), nil
+}
+
+// checkFree returns ErrConflict if path exists, is taken by the batch or
+// any of its parents below dir is a file. Lookups start at the node n of dir
+// found by dirNode.
+// Callers must hold at least a read lock.
+func (mt *Trie) checkFree(path string, dir string, taken map[string]bool, n *Entry, prefix string) error {
+	if _, ok := taken[path]; ok {
+		return ErrConflict
+	}
+	if mt.statAt(path, n, prefix) != nil {
+		return ErrConflict
+	}
+
+	for p := filepath.Dir(path); p != dir; p = filepath.Dir(p) {
+		if file, ok := taken[p]; ok {
+			if file {
+				return ErrConflict
+			}
+			continue
+		}
+		if f := mt.statAt(p, n, prefix); f != nil && !f.IsDirectory() {
+			return ErrConflict
+		}
+		taken[p] = false
+	}
+	return nil
+}
+
+// PreflightBatch checks entries for conflicts between each other before
+// adding them, no trie is involved. Duplicate paths and entries under a path
+// another entry adds as a file conflict, e.g. "/a/f" and "/a/f/g". Returns
+// ErrConflict wrapped with the first conflicting pair of paths
+func PreflightBatch(entries []*Entry) error {
+	type owner struct {
+		path     string
+		file     bool
+		explicit bool
+	}
+	// paths taken by the batch, implicit ones are parents of entries
+	taken := make(map[string]owner)
+	for i, e := range entries {
+		if e == nil {
+			return fmt.Errorf("%w: nil entry at %d", ErrConflict, i)
+		}
+		p := CleanPath(e.Path)
+		if len(p) == 0 || p == Separator {
+			return ErrEmptyPath
+		}
+
+		file := !e.IsDirectory()
+		if o, ok := taken[p]; ok && (o.explicit || file) {
+			return batchConflict(o.path, e.Path)
+		}
+		for dir := filepath.Dir(p); dir != Separator; dir = filepath.Dir(dir) {
+			if o, ok := taken[dir]; ok {
+				if o.file {
+					return batchConflict(o.path, e.Path)
+				}
+				// parents of dir are taken already
+				break
+			}
+			taken[dir] = owner{path: e.Path}
+		}
+		taken[p] = owner{path: e.Path, file: file, explicit: true}
+	}
+	return nil
+}
+
+func batchConflict(a, b string) error {
+	return fmt.Errorf("%w: %q and %q", ErrConflict, a, b)
+}
@@ -0,0 +1,431 @@
+package triefs_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestAddToDir(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	base := func() *triefs.Trie {
+		trie := triefs.NewTrie()
+		for _, e := range []*triefs.Entry{
+			triefs.NewEntry("/uploads/existing.txt", "cid", 1, triefs.MIMEOctetStream, now),
+			triefs.NewEntry("/uploads/file", "cid", 1, triefs.MIMEOctetStream, now),
+			triefs.NewEntry("/other.txt", "cid", 1, triefs.MIMEOctetStream, now),
+			triefs.NewEntry("/spare", "", 0, triefs.MIMEDriveEntry, now),
+		} {
+			if _, err := trie.AddFile(e); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return trie
+	}
+	children := func() []*triefs.Entry {
+		return []*triefs.Entry{
+			triefs.NewEntry("a.txt", "cid1", 1, triefs.MIMEOctetStream, now),
+			triefs.NewEntry("/b.txt", "cid2", 2, triefs.MIMEOctetStream, now),
+			triefs.NewEntry("sub/c.txt", "cid3", 3, triefs.MIMEOctetStream, now),
+			triefs.NewEntry("empty", "", 0, triefs.MIMEDriveEntry, now),
+			triefs.NewEntry("existing.txt.bak", "cid4", 4, triefs.MIMEOctetStream, now),
+		}
+	}
+
+	for _, dir := range []string{"/uploads/", "/", "/spare", "/uploads/sub"} {
+		bulk, single := base(), base()
+		if dir == "/uploads/sub" {
+			_, _ = bulk.AddFile(triefs.NewEntry(dir, "", 0, triefs.MIMEDriveEntry, now))
+			_, _ = single.AddFile(triefs.NewEntry(dir, "", 0, triefs.MIMEDriveEntry, now))
+		}
+		added, err := bulk.AddToDir(dir, children())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", dir, err)
+		}
+		if len(added) == 0 {
+			t.Errorf("%s: got no added entries", dir)
+		}
+
+		for _, e := range children() {
+			e.Path = triefs.JoinPath(dir, e.Path)
+			if _, err := single.AddFile(e); err != nil {
+				t.Fatalf("%s: unexpected error: %v", dir, err)
+			}
+		}
+		got, _ := bulk.Hash()
+		want, _ := single.Hash()
+		if got != want {
+			t.Errorf("%s: got %v, want %v", dir, got, want)
+		}
+		if err := bulk.Validate(); err != nil {
+			t.Errorf("%s: unexpected error: %v", dir, err)
+		}
+		p := triefs.JoinPath(dir, "sub/c.txt")
+		if c, err := bulk.File(p); err != nil || c.Name != "c.txt" {
+			t.Errorf("%s: got %v %v, want c.txt", dir, c, err)
+		}
+	}
+
+	cases := []struct {
+		name    string
+		dir     string
+		entries []*triefs.Entry
+		err     error
+	}{
+		{
+			name:    "existing file",
+			dir:     "/uploads",
+			entries: []*triefs.Entry{triefs.NewEntry("new.txt", "cid", 1, triefs.MIMEOctetStream, now), triefs.NewEntry("existing.txt", "cid", 1, triefs.MIMEOctetStream, now)},
+			err:     triefs.ErrConflict,
+		},
+		{
+			name:    "duplicate in batch",
+			dir:     "/uploads",
+			entries: []*triefs.Entry{triefs.NewEntry("new.txt", "cid", 1, triefs.MIMEOctetStream, now), triefs.NewEntry("/new.txt/", "cid", 1, triefs.MIMEOctetStream, now)},
+			err:     triefs.ErrConflict,
+		},
+		{
+			name:    "under file in batch",
+			dir:     "/uploads",
+			entries: []*triefs.Entry{triefs.NewEntry("new", "cid", 1, triefs.MIMEOctetStream, now), triefs.NewEntry("new/child", "cid", 1, triefs.MIMEOctetStream, now)},
+			err:     triefs.ErrConflict,
+		},
+		{
+			name:    "under existing file",
+			dir:     "/uploads",
+			entries: []*triefs.Entry{triefs.NewEntry("file/child", "cid", 1, triefs.MIMEOctetStream, now)},
+			err:     triefs.ErrConflict,
+		},
+		{
+			name:    "missing directory",
+			dir:     "/missing",
+			entries: []*triefs.Entry{triefs.NewEntry("new.txt", "cid", 1, triefs.MIMEOctetStream, now)},
+			err:     triefs.ErrFileNotExist,
+		},
+		{
+			name:    "file as directory",
+			dir:     "/other.txt",
+			entries: []*triefs.Entry{triefs.NewEntry("new.txt", "cid", 1, triefs.MIMEOctetStream, now)},
+			err:     triefs.ErrNotADirectory,
+		},
+		{
+			name:    "empty child path",
+			dir:     "/uploads",
+			entries: []*triefs.Entry{triefs.NewEntry("/", "cid", 1, triefs.MIMEOctetStream, now)},
+			err:     triefs.ErrEmptyPath,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := base()
+			before, _ := trie.Hash()
+			if _, err := trie.AddToDir(tc.dir, tc.entries); err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if after, _ := trie.Hash(); after != before {
+				t.Errorf("trie changed after failed AddToDir")
+			}
+		})
+	}
+}
+
+func TestAddToDirRollback(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	if _, err := trie.AddFile(triefs.NewEntry("/up", "", 0, triefs.MIMEDriveEntry, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	trie.SetQuota(10)
+	before, _ := trie.Hash()
+	length, epoch := trie.Len(), trie.Epoch()
+
+	_, err := trie.AddToDir("/up", []*triefs.Entry{
+		triefs.NewEntry("sub/a", "cid1", 5, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("sub/deep/b", "cid2", 5, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("c", "cid3", 1, triefs.MIMEOctetStream, now),
+	})
+	if err != triefs.ErrQuotaExceeded {
+		t.Fatalf("got %v, want %v", err, triefs.ErrQuotaExceeded)
+	}
+	if after, _ := trie.Hash(); after != before {
+		t.Errorf("trie changed after failed AddToDir")
+	}
+	if _, err := trie.Stat("/up/sub"); err != triefs.ErrFileNotExist {
+		t.Errorf("got %v, want %v", err, triefs.ErrFileNotExist)
+	}
+	if got := trie.Len(); got != length {
+		t.Errorf("got %v, want %v", got, length)
+	}
+	if got := trie.Epoch(); got != epoch {
+		t.Errorf("got %v, want %v", got, epoch)
+	}
+}
+
+func TestAddToDirIdempotentReAdd(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie, err := triefs.NewTrieWithOptions(triefs.Options{IdempotentReAdd: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/up/a", "cid1", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	added, err := trie.AddToDir("/up", []*triefs.Entry{
+		triefs.NewEntry("a", "cid1", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("b", "cid2", 1, triefs.MIMEOctetStream, now),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(added) != 1 || added[0].Path != "/up/b" {
+		t.Errorf("got %v, want /up/b only", added)
+	}
+
+	_, err = trie.AddToDir("/up", []*triefs.Entry{triefs.NewEntry("a", "cid3", 1, triefs.MIMEOctetStream, now)})
+	if err != triefs.ErrConflict {
+		t.Errorf("got %v, want %v", err, triefs.ErrConflict)
+	}
+}
+
+func dirEntries(n int, now time.Time) []*triefs.Entry {
+	entries := make([]*triefs.Entry, n)
+	for i := range entries {
+		entries[i] = triefs.NewEntry(fmt.Sprintf("file%d.txt", i), "cid", 1, triefs.MIMEOctetStream, now)
+	}
+	return entries
+}
+
+// uploadsTrie holds 400 directories like /deep3/nested7/uploads, each with
+// a single file
+func uploadsTrie(now time.Time) *triefs.Trie {
+	trie := triefs.NewTrie()
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 20; j++ {
+			p := fmt.Sprintf("/deep%d/nested%d/uploads/existing.txt", i, j)
+			_, _ = trie.AddFile(triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now))
+		}
+	}
+	return trie
+}
+
+func BenchmarkAddToDir(b *testing.B) {
+	now := time.Now()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		trie := uploadsTrie(now)
+		b.StartTimer()
+		_, _ = trie.AddToDir("/deep10/nested10/uploads", dirEntries(1000, now))
+	}
+}
+
+func BenchmarkAddFileToDir(b *testing.B) {
+	now := time.Now()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		trie := uploadsTrie(now)
+		b.StartTimer()
+		for _, e := range dirEntries(1000, now) {
+			e.Path = triefs.JoinPath("/deep10/nested10/uploads", e.Path)
+			_, _ = trie.AddFile(e)
+		}
+	}
+}
+
+func TestPreflightBatch(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	file := func(p string) *triefs.Entry {
+		return triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now)
+	}
+	dir := func(p string) *triefs.Entry {
+		return triefs.NewEntry(p, "", 0, triefs.MIMEDriveEntry, now)
+	}
+	cases := []struct {
+		name    string
+		entries []*triefs.Entry
+		pair    []string
+	}{
+		{
+			name:    "no conflicts",
+			entries: []*triefs.Entry{file("/a/f"), file("/a/g/h"), dir("/a/g"), dir("/b"), file("/b/c"), file("/a/fg")},
+		},
+		{
+			name:    "file then child",
+			entries: []*triefs.Entry{file("/a/f"), file("/a/f/g")},
+			pair:    []string{"/a/f", "/a/f/g"},
+		},
+		{
+			name:    "child then file",
+			entries: []*triefs.Entry{file("/a/f/g/h"), file("/x"), file("/a/f")},
+			pair:    []string{"/a/f/g/h", "/a/f"},
+		},
+		{
+			name:    "duplicate file",
+			entries: []*triefs.Entry{file("/a/f"), file("/a//f/")},
+			pair:    []string{"/a/f", "/a//f/"},
+		},
+		{
+			name:    "duplicate directory",
+			entries: []*triefs.Entry{dir("/a"), dir("/a")},
+			pair:    []string{"/a", "/a"},
+		},
+		{
+			name:    "directory over file",
+			entries: []*triefs.Entry{file("/a"), dir("/a")},
+			pair:    []string{"/a", "/a"},
+		},
+	}
+
+	for _, tc := range cases {
+		err := triefs.PreflightBatch(tc.entries)
+		if tc.pair == nil {
+			if err != nil {
+				t.Errorf("%v: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		want := fmt.Sprintf("%v: %q and %q", triefs.ErrConflict, tc.pair[0], tc.pair[1])
+		if !errors.Is(err, triefs.ErrConflict) || err.Error() != want {
+			t.Errorf("%v: got %v, want %v", tc.name, err, want)
+		}
+	}
+
+	if err := triefs.PreflightBatch([]*triefs.Entry{file("/a"), nil}); !errors.Is(err, triefs.ErrConflict) {
+		t.Errorf("got %v, want %v", err, triefs.ErrConflict)
+	}
+	if err := triefs.PreflightBatch([]*triefs.Entry{file("/")}); err != triefs.ErrEmptyPath {
+		t.Errorf("got %v, want %v", err, triefs.ErrEmptyPath)
+	}
+}
+
+func TestSyncDir(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/docs/a.txt", "cid-a", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/b.txt", "cid-b", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/old/c.txt", "cid-c", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/other.txt", "cid-o", 1, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	events := make([]string, 0)
+	trie.OnChange(func(op triefs.Op, path string, c *triefs.Content) {
+		events = append(events, fmt.Sprintf("%v %s", op, path))
+	})
+
+	added, removed, err := trie.SyncDir("/docs", []*triefs.Entry{
+		// unchanged apart from the time which isn't compared
+		triefs.NewEntry("a.txt", "cid-a", 1, triefs.MIMEOctetStream, now.Add(time.Hour)),
+		triefs.NewEntry("b.txt", "cid-b2", 2, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("new/d.txt", "cid-d", 1, triefs.MIMEOctetStream, now),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"/docs/b.txt", "/docs/new/d.txt"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("got %v, want %v", added, want)
+	}
+	if want := []string{"/docs/old", "/docs/old/c.txt"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("got %v, want %v", removed, want)
+	}
+	want := []string{"/docs", "/docs/a.txt", "/docs/b.txt", "/docs/new", "/docs/new/d.txt", "/other.txt"}
+	if got := trie.Paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if f, err := trie.File("/docs/b.txt"); err != nil || f.CID != "cid-b2" {
+		t.Errorf("got %v %v, want %v", f, err, "cid-b2")
+	}
+	if f, err := trie.File("/docs/a.txt"); err != nil || f.CreatedAt != now.Unix() {
+		t.Errorf("got %v %v, want CreatedAt %v", f, err, now.Unix())
+	}
+	wantEvents := []string{"update /docs/b.txt", "create /docs/new/d.txt", "remove /docs/old", "remove /docs/old/c.txt"}
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Errorf("got %v, want %v", events, wantEvents)
+	}
+
+	// a failing entry leaves the trie untouched
+	_, _, err = trie.SyncDir("/docs", []*triefs.Entry{
+		triefs.NewEntry("a.txt", "cid-a", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("a.txt/x", "cid-x", 1, triefs.MIMEOctetStream, now),
+	})
+	if !errors.Is(err, triefs.ErrConflict) {
+		t.Errorf("got %v, want %v", err, triefs.ErrConflict)
+	}
+	if got := trie.Paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, _, err := trie.SyncDir("/other.txt", nil); err != triefs.ErrNotADirectory {
+		t.Errorf("got %v, want %v", err, triefs.ErrNotADirectory)
+	}
+}
+
+func TestSyncDirReplacesInPlace(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(10000, 0)
+	trie, err := triefs.NewTrieWithOptions(triefs.Options{AssignIDs: true, Clock: func() time.Time { return now }})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, p := range []string{"/docs/a.txt", "/docs/b"} {
+		if _, err := trie.AddFile(triefs.NewEntry(p, "cid1", 1, triefs.MIMEOctetStream, now)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	before, err := trie.File("/docs/a.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	desired := func() []*triefs.Entry {
+		return []*triefs.Entry{
+			triefs.NewEntry("a.txt", "cid2", 2, "text/plain", now),
+			triefs.NewEntry("b/c.txt", "cid3", 3, triefs.MIMEOctetStream, now),
+		}
+	}
+	added, removed, err := trie.SyncDir("/docs", desired())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"/docs/a.txt", "/docs/b/c.txt"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("got %v, want %v", added, want)
+	}
+	if want := []string{"/docs/b"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("got %v, want %v", removed, want)
+	}
+	f, err := trie.File("/docs/a.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.ID != before.ID || f.Version != 2 || f.ModifiedAt != now.Unix() || f.CID != "cid2" || f.Type != "text/plain" {
+		t.Errorf("got %+v, want cid2 of text/plain with ID %v, version 2 and modified at %v", f, before.ID, now.Unix())
+	}
+	if err := trie.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the bumped Version doesn't make the same files differ next time
+	added, removed, err = trie.SyncDir("/docs", desired())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(added) != 0 || len(removed) != 0 {
+		t.Errorf("got %v and %v, want nothing changed", added, removed)
+	}
+}
@@ -0,0 +1,78 @@
+package triefs
+
+// Epoch returns the number of changes made to the trie so far. It only
+// grows, so an unchanged epoch means the trie wasn't modified in between
+func (mt *Trie) Epoch() uint64 {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	return mt.epoch
+}
+
+// CompareAndSwapEpoch calls mutate only if Epoch still equals expected and
+// returns ErrEpochMismatch otherwise. No other change can happen meanwhile:
+// mutate gets a trie sharing the contents which must be used instead of the
+// original one, calling the original trie from mutate deadlocks. Changes
+// made before mutate returns an error are kept, along with quota, allowed
+// types and search index set or rebuilt by mutate. OnChange callbacks are
+// called once mutate returns
+func (mt *Trie) CompareAndSwapEpoch(expected uint64, mutate func(*Trie) error) error {
+	mt.lock.Lock()
+	if mt.epoch != expected {
+		mt.lock.Unlock()
+		return ErrEpochMismatch
+	}
+
+	tx := mt.detached()
+	events := make([]event, 0)
+	if mt.observed() {
+		tx.listeners = append(tx.listeners, func(op Op, path string, c *Content) {
+			events = append(events, event{op: op, path: path, c: c})
+		})
+	}
+	err := mutate(tx)
+
+	mt.Root, mt.epoch = tx.Root, tx.epoch
+	mt.used, mt.files, mt.dirs = tx.used, tx.files, tx.dirs
+	mt.quota, mt.allowedTypes, mt.search = tx.quota, tx.allowedTypes, tx.search
+	listeners := mt.listeners
+	mt.lock.Unlock()
+
+	// events were already translated to the trie separator by tx
+	for _, e := range events {
+		for _, fn := range listeners {
+			fn(e.op, e.path, e.c)
+		}
+	}
+	return err
+}
+
+// detached returns a trie with the same contents, options and cache but
+// its own lock and no listeners.
+// Callers must hold the write lock.
+func (mt *Trie) detached() *Trie {
+	return &Trie{
+		Root:                 mt.Root,
+		createdAt:            mt.createdAt,
+		sep:                  mt.sep,
+		cache:                mt.cache,
+		noEmptyDirs:          mt.noEmptyDirs,
+		keepSorted:           mt.keepSorted,
+		strictFileAsDir:      mt.strictFileAsDir,
+		deleteNonEmptyErrors: mt.deleteNonEmptyErrors,
+		idempotentReAdd:      mt.idempotentReAdd,
+		preserveDestTime:     mt.preserveDestTime,
+		clock:                mt.clock,
+		namePolicy:           mt.namePolicy,
+		maxChildren:          mt.maxChildren,
+		assignIDs:            mt.assignIDs,
+		pool:                 mt.pool,
+		quota:                mt.quota,
+		used:                 mt.used,
+		files:                mt.files,
+		dirs:                 mt.dirs,
+		allowedTypes:         mt.allowedTypes,
+		epoch:                mt.epoch,
+		search:               mt.search,
+	}
+}
@@ -0,0 +1,138 @@
+package triefs_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestEpoch(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	if got := trie.Epoch(); got != 0 {
+		t.Errorf("got %v, want %v", got, 0)
+	}
+
+	changes := []struct {
+		name   string
+		change func() error
+	}{
+		{
+			name: "add file",
+			change: func() error {
+				_, err := trie.AddFile(triefs.NewEntry("/a/file", "cid1", 1, triefs.MIMEOctetStream, now))
+				return err
+			},
+		},
+		{
+			name: "replace",
+			change: func() error {
+				_, _, err := trie.Replace("/a/file", &triefs.Content{CID: "cid2", Size: 2})
+				return err
+			},
+		},
+		{
+			name:   "move",
+			change: func() error { return trie.Move("/a/file", "/a/moved") },
+		},
+		{
+			name:   "delete",
+			change: func() error { return trie.Delete("/a/moved") },
+		},
+	}
+	for _, c := range changes {
+		before := trie.Epoch()
+		if err := c.change(); err != nil {
+			t.Fatalf("%v: unexpected error: %v", c.name, err)
+		}
+		if after := trie.Epoch(); after <= before {
+			t.Errorf("%v: got epoch %v, want more than %v", c.name, after, before)
+		}
+	}
+
+	before := trie.Epoch()
+	_ = trie.Delete("/missing")
+	_, _ = trie.AddFile(triefs.NewEntry("/a", "cid", 1, triefs.MIMEOctetStream, now))
+	_, _ = trie.Stat("/a")
+	if got := trie.Epoch(); got != before {
+		t.Errorf("got %v, want %v", got, before)
+	}
+}
+
+func TestCompareAndSwapEpoch(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	if _, err := trie.AddFile(triefs.NewEntry("/a/file", "cid1", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events := 0
+	trie.OnChange(func(op triefs.Op, path string, c *triefs.Content) {
+		if op == triefs.OpCreate && path == "/a/new" {
+			events++
+		}
+	})
+
+	stale := trie.Epoch()
+	err := trie.CompareAndSwapEpoch(stale, func(tx *triefs.Trie) error {
+		_, err := tx.AddFile(triefs.NewEntry("/a/new", "cid2", 2, triefs.MIMEOctetStream, now))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.Stat("/a/new"); err != nil {
+		t.Errorf("got %v, want %v", err, nil)
+	}
+	if events != 1 {
+		t.Errorf("got %v, want %v", events, 1)
+	}
+	if trie.Epoch() == stale {
+		t.Errorf("got %v, want epoch to change", stale)
+	}
+
+	called := false
+	err = trie.CompareAndSwapEpoch(stale, func(tx *triefs.Trie) error {
+		called = true
+		return tx.Delete("/a/file")
+	})
+	if err != triefs.ErrEpochMismatch {
+		t.Errorf("got %v, want %v", err, triefs.ErrEpochMismatch)
+	}
+	if called {
+		t.Errorf("got mutate called, want it skipped")
+	}
+	if _, err := trie.Stat("/a/file"); err != nil {
+		t.Errorf("got %v, want %v", err, nil)
+	}
+
+	other := triefs.NewTrie()
+	if _, err := other.AddFile(triefs.NewEntry("/b/other", "cid5", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := other.MarshalJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	trie.BuildSearchIndex()
+	err = trie.CompareAndSwapEpoch(trie.Epoch(), func(tx *triefs.Trie) error {
+		tx.SetQuota(1)
+		tx.SetAllowedTypes([]string{"text/plain"})
+		return tx.UnmarshalJSON(data)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/a/big.txt", "cid3", 2, "text/plain", now)); err != triefs.ErrQuotaExceeded {
+		t.Errorf("got %v, want %v", err, triefs.ErrQuotaExceeded)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/a/bin", "cid4", 0, triefs.MIMEOctetStream, now)); err != triefs.ErrDisallowedType {
+		t.Errorf("got %v, want %v", err, triefs.ErrDisallowedType)
+	}
+	if got := trie.SearchIndexed("other"); !reflect.DeepEqual(got, []string{"/b/other"}) {
+		t.Errorf("got %v, want %v", got, []string{"/b/other"})
+	}
+}
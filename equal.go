@@ -0,0 +1,88 @@
+package triefs
+
+import "reflect"
+
+// Equal checks if both tries hold the same entries, as listed by LsRecursive,
+// with the same contents. Internal structure and order of siblings are
+// ignored, so tries built in different order compare equal
+func Equal(a, b *Trie) bool {
+	return EqualExcept(a, b, false)
+}
+
+// EqualExcept is Equal which optionally ignores CreatedAt. Directories not
+// added explicitly take CreatedAt of entries beneath them, so it may differ
+// for the same files added at different times
+func EqualExcept(a, b *Trie, ignoreCreatedAt bool) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+
+	// tries are locked one at a time, so concurrent Equal(a, b) and
+	// Equal(b, a) can't deadlock
+	var ignore []string
+	if ignoreCreatedAt {
+		ignore = []string{"CreatedAt"}
+	}
+	ae, be := a.entries(), b.entries()
+	if len(ae) != len(be) {
+		return false
+	}
+	for i := range ae {
+		if ae[i].Path != be[i].Path || !EqualContent(&ae[i].Content, &be[i].Content, ignore...) {
+			return false
+		}
+	}
+	return true
+}
+
+// entries returns all entries of the trie sorted by absolute path
+func (mt *Trie) entries() []*Entry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	return mt.lsRecursive(Separator)
+}
+
+// Equal checks if both contents describe the same entry, only Name, Type,
+// CID, Size and Version are compared. Timestamps, metadata and other
+// fields which may change without changing the entry itself are ignored
+func (c *Content) Equal(other *Content) bool {
+	if c == nil || other == nil {
+		return c == other
+	}
+	return c.Name == other.Name &&
+		c.Type == other.Type &&
+		c.CID == other.CID &&
+		c.Size == other.Size &&
+		c.Version == other.Version
+}
+
+// EqualContent compares all fields of a and b except the ignored ones,
+// named as Content fields, e.g. "CreatedAt". Unknown names are skipped and
+// empty Metadata equals nil
+func EqualContent(a, b *Content, ignore ...string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	ac, bc := a.copy(), b.copy()
+	av, bv := reflect.ValueOf(ac).Elem(), reflect.ValueOf(bc).Elem()
+	for _, name := range ignore {
+		f := av.FieldByName(name)
+		if !f.IsValid() {
+			continue
+		}
+		f.Set(reflect.Zero(f.Type()))
+		bv.FieldByName(name).Set(reflect.Zero(f.Type()))
+	}
+	if len(ac.Metadata) == 0 {
+		ac.Metadata = nil
+	}
+	if len(bc.Metadata) == 0 {
+		bc.Metadata = nil
+	}
+	return reflect.DeepEqual(ac, bc)
+}
@@ -0,0 +1,94 @@
+package triefs_test
+
+import (
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestEqual(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	build := func(at time.Time, paths ...string) *triefs.Trie {
+		trie := triefs.NewTrie()
+		for _, p := range paths {
+			e := triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now)
+			if p == "/empty" {
+				e = triefs.NewEntry(p, "", 0, triefs.MIMEDriveEntry, at)
+			}
+			if _, err := trie.AddFile(e); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return trie
+	}
+
+	a := build(now, "/a/b/c.txt", "/a/bb.txt", "/empty", "/中文.txt")
+	b := build(now, "/中文.txt", "/empty", "/a/bb.txt", "/a/b/c.txt")
+	if !triefs.Equal(a, b) || !triefs.Equal(b, a) {
+		t.Errorf("tries built in different order aren't equal")
+	}
+	if !triefs.Equal(a, a) {
+		t.Errorf("trie isn't equal to itself")
+	}
+
+	later := build(now.Add(time.Hour), "/a/b/c.txt", "/a/bb.txt", "/empty", "/中文.txt")
+	if triefs.Equal(a, later) {
+		t.Errorf("tries with different CreatedAt are equal")
+	}
+	if !triefs.EqualExcept(a, later, true) {
+		t.Errorf("tries with different CreatedAt aren't equal ignoring it")
+	}
+
+	if triefs.Equal(a, build(now, "/a/b/c.txt", "/a/bb.txt", "/empty")) {
+		t.Errorf("tries with different files are equal")
+	}
+	if _, _, err := b.Replace("/a/bb.txt", &triefs.Content{CID: "other", Size: 1, CreatedAt: now.Unix()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if triefs.Equal(a, b) {
+		t.Errorf("tries with different contents are equal")
+	}
+	if triefs.Equal(a, nil) || !triefs.Equal(triefs.NewTrie(), triefs.NewTrie()) {
+		t.Errorf("got wrong result for empty tries")
+	}
+}
+
+func TestContentEqual(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	base := triefs.NewContent("file", "cid", 1, triefs.MIMEOctetStream, now)
+	later := base
+	later.CreatedAt = now.Add(time.Hour).Unix()
+	later.Metadata = map[string]string{"k": "v"}
+
+	cases := []struct {
+		name    string
+		other   triefs.Content
+		ignore  []string
+		equal   bool
+		content bool
+	}{
+		{name: "same", other: base, equal: true, content: true},
+		{name: "different time", other: later, ignore: []string{"CreatedAt", "Metadata"}, equal: true, content: true},
+		{name: "different time not ignored", other: later, ignore: []string{"Metadata"}, equal: true, content: false},
+		{name: "unknown field", other: later, ignore: []string{"CreatedAt", "Metadata", "Missing"}, equal: true, content: true},
+		{name: "different cid", other: triefs.NewContent("file", "cid2", 1, triefs.MIMEOctetStream, now), ignore: []string{"CID"}, equal: false, content: true},
+		{name: "different size", other: triefs.NewContent("file", "cid", 2, triefs.MIMEOctetStream, now), equal: false, content: false},
+		{name: "different type", other: triefs.NewContent("file", "cid", 1, "text/plain", now), equal: false, content: false},
+	}
+	for _, tc := range cases {
+		other := tc.other
+		if got := base.Equal(&other); got != tc.equal {
+			t.Errorf("%v: got %v, want %v", tc.name, got, tc.equal)
+		}
+		if got := triefs.EqualContent(&base, &other, tc.ignore...); got != tc.content {
+			t.Errorf("%v: got %v, want %v", tc.name, got, tc.content)
+		}
+	}
+
+	if base.Equal(nil) || triefs.EqualContent(&base, nil) || !triefs.EqualContent(nil, nil) {
+		t.Errorf("got wrong result for nil contents")
+	}
+}
@@ -0,0 +1,97 @@
+package triefs
+
+import "path/filepath"
+
+// Op is a kind of trie mutation reported to OnChange callbacks
+type Op int
+
+const (
+	// OpCreate reported by AddFile and AddFileUnique
+	OpCreate Op = iota + 1
+	// OpRemove reported by Delete with the content of the removed entry
+	OpRemove
+	// OpUpdate reported by Replace
+	OpUpdate
+	// OpMove reported by Move, MoveInto, MoveManyInto and Rename twice: first
+	// with the source path and nil content, then with the destination path
+	OpMove
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpCreate:
+		return "create"
+	case OpRemove:
+		return "remove"
+	case OpUpdate:
+		return "update"
+	case OpMove:
+		return "move"
+	}
+	return "unknown"
+}
+
+type event struct {
+	op   Op
+	path string
+	c    *Content
+}
+
+// OnChange registers fn to be called after each successful mutation. Callbacks
+// are called in order of registration once the trie is unlocked, so they can
+// safely read the trie and always observe the state after the change
+func (mt *Trie) OnChange(fn func(op Op, path string, c *Content)) {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	mt.listeners = append(mt.listeners, fn)
+}
+
+// observed checks if there is anyone to notify about changes.
+// Callers must hold at least a read lock.
+func (mt *Trie) observed() bool {
+	return len(mt.listeners) != 0
+}
+
+// notify queues an event to be sent by unlock, if c is nil the current
+// content at path is sent.
+// Callers must hold the write lock.
+func (mt *Trie) notify(op Op, path string, c *Content) {
+	if !mt.observed() {
+		return
+	}
+	if c == nil {
+		c = mt.content(path)
+	}
+	mt.pending = append(mt.pending, event{op: op, path: path, c: c})
+}
+
+// unlock releases the write lock and sends queued events
+func (mt *Trie) unlock() {
+	events, listeners := mt.pending, mt.listeners
+	mt.pending = nil
+	mt.lock.Unlock()
+
+	for _, e := range events {
+		path, c := mt.swap(e.path), mt.swapContent(e.c)
+		for _, fn := range listeners {
+			fn(e.op, path, c)
+		}
+	}
+}
+
+// content returns a copy of file or directory content at path, nil if missing.
+// Callers must hold at least a read lock.
+func (mt *Trie) content(path string) *Content {
+	if mt.Root == nil {
+		return nil
+	}
+
+	f := stat(path, mt.Root)
+	if f == nil {
+		return nil
+	}
+	cnt := f.copy()
+	cnt.Name = filepath.Base(path)
+	return cnt
+}
@@ -0,0 +1,86 @@
+package triefs_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestOnChange(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+
+	events := make([]string, 0)
+	trie.OnChange(func(op triefs.Op, path string, c *triefs.Content) {
+		cid := "<nil>"
+		if c != nil {
+			cid = c.CID
+		}
+		events = append(events, fmt.Sprintf("%v %s %s", op, path, cid))
+	})
+
+	if _, err := trie.AddFile(triefs.NewEntry("/docs/readme.txt", "cid1", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/archive", "", 0, triefs.MIMEDriveEntry, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// conflicts and no-ops aren't reported
+	if _, err := trie.AddFile(triefs.NewEntry("/docs/readme.txt", "cid1", 1, triefs.MIMEOctetStream, now)); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := trie.Delete("/missing"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := trie.Replace("/docs/readme.txt", &triefs.Content{CID: "cid2", Size: 2}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.MoveInto("/docs/readme.txt", "/archive"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := trie.Delete("/archive/readme.txt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"create /docs/readme.txt cid1",
+		"create /archive ",
+		"update /docs/readme.txt cid2",
+		"move /docs/readme.txt <nil>",
+		"move /archive/readme.txt cid2",
+		"remove /archive/readme.txt cid2",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got %v, want %v", events, expected)
+	}
+}
+
+func TestOnChangeMultiple(t *testing.T) {
+	t.Parallel()
+	trie := triefs.NewTrie()
+
+	calls := make([]string, 0)
+	for _, name := range []string{"first", "second"} {
+		name := name
+		trie.OnChange(func(op triefs.Op, path string, c *triefs.Content) {
+			// reading from a callback must not deadlock and must see the change
+			if _, err := trie.File(path); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			calls = append(calls, name)
+		})
+	}
+
+	if _, err := trie.AddFile(triefs.NewEntry("/file", "cid", 1, triefs.MIMEOctetStream, time.Now())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"first", "second"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("got %v, want %v", calls, expected)
+	}
+}
@@ -0,0 +1,45 @@
+package triefs
+
+// DirHandle reads children of a directory in chunks. Children are captured
+// by OpenDir, so later changes of the trie aren't visible through the handle.
+// A handle isn't safe for concurrent use
+type DirHandle struct {
+	children []*Content
+	pos      int
+}
+
+// OpenDir opens the directory at path for chunked reading, see DirHandle.
+// It fails like ReadDir for missing paths and files
+func (mt *Trie) OpenDir(path string) (*DirHandle, error) {
+	entries, err := mt.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make([]*Content, len(entries))
+	for i, e := range entries {
+		children[i] = &e.Content
+	}
+	return &DirHandle{children: children}, nil
+}
+
+// ReadNext returns up to n next children in Ls order, or all remaining ones
+// if n isn't positive. An empty slice means every child was read
+func (dh *DirHandle) ReadNext(n int) []*Content {
+	rest := dh.children[dh.pos:]
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	dh.pos += len(rest)
+
+	res := make([]*Content, len(rest))
+	for i, c := range rest {
+		res[i] = c.copy()
+	}
+	return res
+}
+
+// Rewind makes the next ReadNext start from the first child again
+func (dh *DirHandle) Rewind() {
+	dh.pos = 0
+}
@@ -0,0 +1,68 @@
+package triefs_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestOpenDir(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, p := range []string{"/dir/a", "/dir/b", "/dir/c", "/dir/d/file", "/dir/e", "/dir/f", "/dir/g", "/file"} {
+		if _, err := trie.AddFile(triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	dh, err := trie.OpenDir("/dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// changes after opening aren't visible through the handle
+	if _, err := trie.AddFile(triefs.NewEntry("/dir/h", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := trie.Delete("/dir/a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := func(contents []*triefs.Content) []string {
+		res := make([]string, 0, len(contents))
+		for _, c := range contents {
+			res = append(res, c.Name)
+		}
+		return res
+	}
+
+	want := [][]string{{"a", "b", "c"}, {"d", "e", "f"}, {"g"}, {}}
+	for round := 0; round < 2; round++ {
+		for _, chunk := range want {
+			if got := names(dh.ReadNext(3)); !reflect.DeepEqual(got, chunk) {
+				t.Errorf("round %v: got %v, want %v", round, got, chunk)
+			}
+		}
+		dh.Rewind()
+	}
+
+	if got := names(dh.ReadNext(0)); !reflect.DeepEqual(got, []string{"a", "b", "c", "d", "e", "f", "g"}) {
+		t.Errorf("got %v, want all children", got)
+	}
+
+	cases := []struct {
+		path string
+		err  error
+	}{
+		{path: "", err: triefs.ErrEmptyPath},
+		{path: "/missing", err: triefs.ErrFileNotExist},
+		{path: "/file", err: triefs.ErrNotADirectory},
+	}
+	for _, tc := range cases {
+		if _, err := trie.OpenDir(tc.path); err != tc.err {
+			t.Errorf("%v: got %v, want %v", tc.path, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package triefs
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// HotspotKind tells what Hotspot.Metric measures
+type HotspotKind int
+
+const (
+	// HotspotFanOut is a directory, Metric is the number of its entries as
+	// listed by Ls
+	HotspotFanOut HotspotKind = iota + 1
+	// HotspotDepth is an entry, Metric is the number of segments of its path
+	HotspotDepth
+)
+
+// Hotspot is a path standing out in the trie structure, see Hotspots
+type Hotspot struct {
+	Kind   HotspotKind
+	Path   string
+	Metric int
+}
+
+// Hotspots returns the topN directories having the most entries followed by
+// the topN deepest entries, both ordered from the largest metric down and by
+// path on ties. Wide directories slow down lookups scanning siblings, deep
+// paths slow down every walk from root. Computed in a single traversal
+func (mt *Trie) Hotspots(topN int) []Hotspot {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if topN <= 0 || mt.Root == nil {
+		return []Hotspot{}
+	}
+
+	entries := mt.lsRecursive(Separator)
+	fanOut := map[string]int{Separator: 0}
+	depth := make([]Hotspot, 0, len(entries))
+	for _, e := range entries {
+		// directories without entries are listed too
+		if _, ok := fanOut[e.Path]; !ok && e.IsDirectory() {
+			fanOut[e.Path] = 0
+		}
+		fanOut[filepath.Dir(e.Path)]++
+		depth = append(depth, Hotspot{Kind: HotspotDepth, Path: e.Path, Metric: strings.Count(e.Path, Separator)})
+	}
+
+	wide := make([]Hotspot, 0, len(fanOut))
+	for p, n := range fanOut {
+		wide = append(wide, Hotspot{Kind: HotspotFanOut, Path: p, Metric: n})
+	}
+
+	res := append(topHotspots(wide, topN), topHotspots(depth, topN)...)
+	for i := range res {
+		res[i].Path = mt.swap(res[i].Path)
+	}
+	return res
+}
+
+// topHotspots returns the first n of spots sorted by Metric descending
+func topHotspots(spots []Hotspot, n int) []Hotspot {
+	sort.Slice(spots, func(i, j int) bool {
+		if spots[i].Metric != spots[j].Metric {
+			return spots[i].Metric > spots[j].Metric
+		}
+		return spots[i].Path < spots[j].Path
+	})
+	if len(spots) > n {
+		spots = spots[:n]
+	}
+	return spots
+}
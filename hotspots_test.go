@@ -0,0 +1,51 @@
+package triefs_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestHotspots(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for i := 0; i < 30; i++ {
+		e := triefs.NewEntry(fmt.Sprintf("/wide/file%02d", i), "cid", 1, triefs.MIMEOctetStream, now)
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	deep := "/" + strings.Repeat("d/", 11) + "file"
+	for _, p := range []string{deep, "/other/a/file", "/other/b/file"} {
+		if _, err := trie.AddFile(triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := []triefs.Hotspot{
+		{Kind: triefs.HotspotFanOut, Path: "/wide", Metric: 30},
+		{Kind: triefs.HotspotFanOut, Path: "/", Metric: 3},
+		{Kind: triefs.HotspotDepth, Path: deep, Metric: 12},
+		{Kind: triefs.HotspotDepth, Path: strings.TrimSuffix(deep, "/file"), Metric: 11},
+	}
+	if got := trie.Hotspots(2); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+
+	// ties of directories holding one entry are ordered by path
+	got := trie.Hotspots(4)
+	if len(got) != 8 || got[2].Path != "/other" || got[3].Path != "/d" {
+		t.Errorf("got %v, want /other and /d after the widest", got)
+	}
+
+	for _, n := range []int{0, -1} {
+		if got := trie.Hotspots(n); len(got) != 0 {
+			t.Errorf("%v: got %v, want nothing", n, got)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package triefs
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// FileByID returns the current path and content of the file having
+// Content.ID id. IDs aren't indexed, so it takes a traversal of the trie.
+// If several files share the ID the first one in path order is returned.
+// Returns ErrFileNotExist if there's no such file
+func (mt *Trie) FileByID(id string) (string, *Content, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if len(id) == 0 || mt.Root == nil {
+		return "", nil, ErrFileNotExist
+	}
+	for _, e := range mt.lsRecursive(Separator) {
+		if e.ID == id && !e.IsDirectory() {
+			return mt.swap(e.Path), mt.swapContent(e.Content.copy()), nil
+		}
+	}
+	return "", nil, ErrFileNotExist
+}
+
+// newID returns a random version 4 UUID, see Options.AssignIDs
+func newID() string {
+	var b [16]byte
+	// never fails since Go 1.24
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,138 @@
+package triefs_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestFileByID(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie, err := triefs.NewTrieWithOptions(triefs.Options{AssignIDs: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	explicit := triefs.NewEntry("/docs/explicit.txt", "cid2", 2, triefs.MIMEOctetStream, now)
+	explicit.ID = "explicit-id"
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/docs/report.txt", "cid1", 1, triefs.MIMEOctetStream, now),
+		explicit,
+		triefs.NewEntry("/archive", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	f, err := trie.File("/docs/report.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	id := f.ID
+	if len(id) == 0 {
+		t.Fatalf("expected assigned ID")
+	}
+	if f, _ := trie.File("/docs/explicit.txt"); f == nil || f.ID != "explicit-id" {
+		t.Errorf("got %v, want ID explicit-id", f)
+	}
+	if d, _ := trie.Stat("/archive"); d == nil || len(d.ID) != 0 {
+		t.Errorf("got %v, want directory without ID", d)
+	}
+
+	steps := []struct {
+		name   string
+		change func() error
+		path   string
+	}{
+		{
+			name: "move",
+			change: func() error {
+				return trie.Move("/docs/report.txt", "/archive/report.txt")
+			},
+			path: "/archive/report.txt",
+		},
+		{
+			name: "rename",
+			change: func() error {
+				_, err := trie.Rename("/archive/report.txt", "final.txt")
+				return err
+			},
+			path: "/archive/final.txt",
+		},
+		{
+			name: "replace",
+			change: func() error {
+				c := triefs.NewContent("final.txt", "cid3", 3, triefs.MIMEOctetStream, now)
+				_, _, err := trie.Replace("/archive/final.txt", &c)
+				return err
+			},
+			path: "/archive/final.txt",
+		},
+	}
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%v: unexpected error: %v", step.name, err)
+		}
+		path, c, err := trie.FileByID(id)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", step.name, err)
+		}
+		if path != step.path || c.ID != id {
+			t.Errorf("%v: got %v %v, want %v %v", step.name, path, c.ID, step.path, id)
+		}
+	}
+
+	for _, missing := range []string{"", "unknown"} {
+		if _, _, err := trie.FileByID(missing); err != triefs.ErrFileNotExist {
+			t.Errorf("%q: got %v, want %v", missing, err, triefs.ErrFileNotExist)
+		}
+	}
+
+	data, err := json.Marshal(trie)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decoded := triefs.NewTrie()
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path, _, err := decoded.FileByID(id); err != nil || path != "/archive/final.txt" {
+		t.Errorf("got %v %v, want %v", path, err, "/archive/final.txt")
+	}
+
+	var buf bytes.Buffer
+	if err := trie.Snapshot(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	restored, err := triefs.RestoreSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path, _, err := restored.FileByID(id); err != nil || path != "/archive/final.txt" {
+		t.Errorf("got %v %v, want %v", path, err, "/archive/final.txt")
+	}
+
+	// copies are independent, but keep the ID
+	sub, err := trie.Subtree("/archive")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, c, err := sub.FileByID(id); err != nil || c.ID != id {
+		t.Errorf("got %v %v, want %v", c, err, id)
+	}
+}
+
+func TestNoAssignIDs(t *testing.T) {
+	t.Parallel()
+	trie := triefs.NewTrie()
+	if _, err := trie.AddFile(triefs.NewEntry("/file", "cid", 1, triefs.MIMEOctetStream, time.Now())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f, _ := trie.File("/file"); f == nil || len(f.ID) != 0 {
+		t.Errorf("got %v, want no ID", f)
+	}
+}
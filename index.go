@@ -0,0 +1,73 @@
+package triefs
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// indexThreshold is the number of children from which a node keeps them
+// indexed by the first rune of their path. Siblings never share it, so
+// lookups in wide directories don't have to scan every child
+const indexThreshold = 32
+
+// child returns the child which path starts with r, nil if there is none
+func (entry *Entry) child(r rune) *Entry {
+	if entry.index != nil {
+		return entry.index[r]
+	}
+	for _, me := range entry.Entries {
+		if firstRune(me.Path) == r {
+			return me
+		}
+	}
+	return nil
+}
+
+// appendChild adds me to the children keeping the index up to date
+func (entry *Entry) appendChild(me *Entry) {
+	entry.Entries = append(entry.Entries, me)
+	if entry.index != nil {
+		entry.index[firstRune(me.Path)] = me
+		return
+	}
+	entry.reindex()
+}
+
+// placeChild moves the child me to its place among otherwise sorted
+// children, found by binary search over their labels
+func (entry *Entry) placeChild(me *Entry) {
+	entries := entry.Entries
+	i := 0
+	for i < len(entries) && entries[i] != me {
+		i++
+	}
+	if i == len(entries) {
+		return
+	}
+	entries = append(entries[:i], entries[i+1:]...)
+	j := sort.Search(len(entries), func(k int) bool {
+		return entries[k].Path >= me.Path
+	})
+	entries = append(entries, nil)
+	copy(entries[j+1:], entries[j:])
+	entries[j] = me
+	entry.Entries = entries
+}
+
+// reindex rebuilds the index, it must be called whenever children are
+// replaced or removed other than by appendChild
+func (entry *Entry) reindex() {
+	if len(entry.Entries) < indexThreshold {
+		entry.index = nil
+		return
+	}
+	entry.index = make(map[rune]*Entry, len(entry.Entries))
+	for _, me := range entry.Entries {
+		entry.index[firstRune(me.Path)] = me
+	}
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
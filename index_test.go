@@ -0,0 +1,145 @@
+package triefs_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+// wideNames returns n names starting with distinct runes, so all of them
+// become children of a single trie node
+func wideNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("%c%d.bin", rune(0x20000+i), i)
+	}
+	return names
+}
+
+func wideTrieOf(tb testing.TB, names []string) *triefs.Trie {
+	tb.Helper()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, name := range names {
+		if _, err := trie.AddFile(triefs.NewEntry("/wide/"+name, "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+			tb.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return trie
+}
+
+func TestWideDirectory(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	names := wideNames(100)
+	trie := wideTrieOf(t, names)
+
+	// mutations of a wide node must keep lookups and the binary encoding,
+	// which rebuilds the node from scratch, consistent
+	check := func(step string, present []string, missing []string) {
+		t.Helper()
+		for _, name := range present {
+			if _, err := trie.Stat("/wide/" + name); err != nil {
+				t.Errorf("%v: stat %v: unexpected error: %v", step, name, err)
+			}
+		}
+		for _, name := range missing {
+			if _, err := trie.Stat("/wide/" + name); err != triefs.ErrFileNotExist {
+				t.Errorf("%v: stat %v: got %v, want %v", step, name, err, triefs.ErrFileNotExist)
+			}
+		}
+		if err := trie.Validate(); err != nil {
+			t.Errorf("%v: unexpected error: %v", step, err)
+		}
+
+		data, err := trie.MarshalBinary()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		decoded := triefs.NewTrie()
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(decoded.Root, trie.Root) {
+			t.Errorf("%v: decoded trie differs", step)
+		}
+	}
+	check("add", names, nil)
+
+	deleted := make([]string, 0)
+	kept := make([]string, 0)
+	for i, name := range names {
+		if i%3 == 0 {
+			if err := trie.Delete("/wide/" + name); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			deleted = append(deleted, name)
+			continue
+		}
+		kept = append(kept, name)
+	}
+	check("delete", kept, deleted)
+
+	// names sharing first runes with existing children split them
+	for _, name := range kept[:10] {
+		if _, err := trie.AddFile(triefs.NewEntry("/wide/"+name+"x", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := trie.AddFile(triefs.NewEntry("/wide/"+name+"d/child", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// and a sibling of the wide directory splits the node holding it
+	if _, err := trie.AddFile(triefs.NewEntry("/wi.bin", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check("split", append(kept, kept[0]+"x", kept[0]+"d/child"), deleted)
+
+	if err := trie.DeleteAll("/wide/" + kept[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := trie.Move("/wide/"+kept[1], "/wide/"+deleted[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check("move", append(kept[2:], deleted[0]), append(deleted[1:], kept[0], kept[1]))
+
+	for _, name := range kept {
+		if err := trie.DeleteAll("/wide/" + name); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	check("shrink", deleted[:1], kept)
+}
+
+func BenchmarkWideAddFile(b *testing.B) {
+	names := wideNames(50000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wideTrieOf(b, names)
+	}
+}
+
+func BenchmarkWideFile(b *testing.B) {
+	names := wideNames(50000)
+	trie := wideTrieOf(b, names)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := trie.File("/wide/" + names[i%len(names)]); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkWideStat(b *testing.B) {
+	names := wideNames(50000)
+	trie := wideTrieOf(b, names)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := trie.Stat("/wide/" + names[i%len(names)]); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
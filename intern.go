@@ -0,0 +1,68 @@
+package triefs
+
+import "strings"
+
+// stringPool shares equal strings kept by trie nodes, see
+// Options.InternStrings. A nil pool interns nothing
+type stringPool struct {
+	strs map[string]string
+}
+
+func newStringPool(enabled bool) *stringPool {
+	if !enabled {
+		return nil
+	}
+	return &stringPool{strs: make(map[string]string)}
+}
+
+// intern returns the pooled string equal to s. New strings are cloned, so
+// they don't keep the whole path they were cut from alive
+func (sp *stringPool) intern(s string) string {
+	if sp == nil || len(s) == 0 {
+		return s
+	}
+	if v, ok := sp.strs[s]; ok {
+		return v
+	}
+	s = strings.Clone(s)
+	sp.strs[s] = s
+	return s
+}
+
+// entry interns the path label, name and type of e, children are left as is
+func (sp *stringPool) entry(e *Entry) {
+	e.Path = sp.intern(e.Path)
+	e.Name = sp.intern(e.Name)
+	e.Type = sp.intern(e.Type)
+}
+
+// path interns nodes along path down to its marker, the same nodes
+// sortPath visits
+func (sp *stringPool) path(path string, subtrie *Entry) {
+	if sp == nil {
+		return
+	}
+	for subtrie != nil && strings.HasPrefix(path, subtrie.Path) {
+		path = strings.TrimPrefix(path, subtrie.Path)
+		sp.entry(subtrie)
+
+		if len(path) == 0 {
+			if me := subtrie.child(rune(SpecialPathSymbol[0])); me != nil {
+				sp.entry(me)
+			}
+			return
+		}
+		subtrie = subtrie.child(firstRune(path))
+	}
+}
+
+// tree interns every node of subtrie
+func (sp *stringPool) tree(subtrie *Entry) {
+	if sp == nil || subtrie == nil {
+		return
+	}
+	sp.entry(subtrie)
+	for _, me := range subtrie.Entries {
+		sp.tree(me)
+	}
+}
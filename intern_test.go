@@ -0,0 +1,115 @@
+package triefs_test
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+// projectTrie adds files of n projects sharing directory and file names,
+// paths are built on the fly like when they come from requests
+func projectTrie(tb testing.TB, opts triefs.Options, n int) *triefs.Trie {
+	tb.Helper()
+	trie, err := triefs.NewTrieWithOptions(opts)
+	if err != nil {
+		tb.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Unix(1000, 0)
+	for i := 0; i < n; i++ {
+		for _, name := range []string{"src/main.go", "src/util.go", "docs/README.md", "tests/main_test.go"} {
+			e := triefs.NewEntry(fmt.Sprintf("/projects/project%d/%s", i, name), "cid", 1, triefs.MIMEOctetStream, now)
+			if _, err := trie.AddFile(e); err != nil {
+				tb.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	return trie
+}
+
+func TestInternStrings(t *testing.T) {
+	t.Parallel()
+	plain := projectTrie(t, triefs.Options{}, 50)
+	interned := projectTrie(t, triefs.Options{InternStrings: true}, 50)
+	if !triefs.Equal(plain, interned) {
+		t.Errorf("expected equal tries")
+	}
+	want, _ := plain.Hash()
+	if got, _ := interned.Hash(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	sub, err := interned.Subtree("/projects/project1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clone, err := interned.Subtree("/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tc := range []struct {
+		trie *triefs.Trie
+		path string
+	}{
+		{trie: sub, path: "/src"},
+		{trie: clone, path: "/projects/project1/src"},
+	} {
+		if _, err := tc.trie.Rename(tc.path, "lib"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := tc.trie.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if !triefs.Equal(plain, interned) {
+		t.Errorf("copies changed the original trie")
+	}
+
+	if err := interned.Move("/projects/project2/src/main.go", "/projects/project3/main.go"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := interned.DeleteAll("/projects/project4"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := interned.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := interned.File("/projects/project3/main.go"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// BenchmarkInternStrings reports heap kept by the built trie as retained-B/op
+func BenchmarkInternStrings(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts triefs.Options
+	}{
+		{name: "plain"},
+		{name: "interned", opts: triefs.Options{InternStrings: true}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var before, after runtime.MemStats
+			var retained int64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				b.StartTimer()
+
+				trie := projectTrie(b, bc.opts, 5000)
+
+				b.StopTimer()
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(trie)
+				retained += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package triefs
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// MarshalJSON encodes the trie with children of every node sorted by their
+// path labels, so tries holding the same nodes encode to the same bytes no
+// matter the insertion order. Metadata keys are sorted by encoding/json
+func (mt *Trie) MarshalJSON() ([]byte, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	return mt.marshal()
+}
+
+// marshal is the lock-free core of MarshalJSON.
+// Callers must hold at least a read lock.
+func (mt *Trie) marshal() ([]byte, error) {
+	var root *Entry
+	if mt.Root != nil {
+		root = sortedEntry(mt.Root)
+	}
+	return json.Marshal(struct {
+		Root *Entry `json:"root"`
+	}{Root: root})
+}
+
+// sortedEntry returns a shallow copy of e with children sorted by path down
+// the whole subtree, e is left untouched
+func sortedEntry(e *Entry) *Entry {
+	cp := &Entry{Content: e.Content, Path: e.Path, Meta: e.Meta}
+	if e.Entries == nil {
+		return cp
+	}
+	cp.Entries = make([]*Entry, len(e.Entries))
+	for i, me := range e.Entries {
+		cp.Entries[i] = sortedEntry(me)
+	}
+	sort.Slice(cp.Entries, func(i, j int) bool {
+		return cp.Entries[i].Path < cp.Entries[j].Path
+	})
+	return cp
+}
+
+// UnmarshalJSON replaces the trie contents with JSON produced by
+// json.Marshal of a trie
+func (mt *Trie) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Root *Entry `json:"root"`
+	}
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	mt.reset(v.Root)
+	return nil
+}
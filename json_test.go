@@ -0,0 +1,89 @@
+package triefs_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestMarshalJSONDeterministic(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	entries := []*triefs.Entry{
+		triefs.NewEntry("/a/b.txt", "cid1", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/a/a.txt", "cid2", 2, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/a.txt", "cid3", 3, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/ba/c", "cid4", 4, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/b", "cid5", 5, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/empty", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/中文/文件.txt", "cid6", 6, triefs.MIMEOctetStream, now),
+	}
+	entries[0].SetMetadata("z", "1")
+	entries[0].SetMetadata("a", "2")
+
+	tries := make([]*triefs.Trie, 0)
+	for _, order := range [][]int{{0, 1, 2, 3, 4, 5, 6}, {6, 5, 4, 3, 2, 1, 0}, {3, 0, 6, 2, 5, 1, 4}} {
+		trie := triefs.NewTrie()
+		for _, i := range order {
+			if _, err := trie.AddFile(entries[i]); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		tries = append(tries, trie)
+	}
+	// without KeepSorted the insertion order shows in the structure
+	if tries[0].Root.Entries[0].Path == tries[1].Root.Entries[0].Path {
+		t.Fatalf("expected children in insertion order")
+	}
+
+	want, err := json.Marshal(tries[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantManifest, err := tries[0].MarshalManifest()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantHash, _ := tries[0].Hash()
+	for _, trie := range tries[1:] {
+		if !triefs.Equal(trie, tries[0]) {
+			t.Fatalf("expected equal tries")
+		}
+		got, err := json.Marshal(trie)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("got %s, want %s", got, want)
+		}
+		gotManifest, err := trie.MarshalManifest()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(gotManifest, wantManifest) {
+			t.Errorf("got %s, want %s", gotManifest, wantManifest)
+		}
+		if hash, _ := trie.Hash(); hash != wantHash {
+			t.Errorf("got %v, want %v", hash, wantHash)
+		}
+	}
+
+	// decoding and encoding again keeps the bytes
+	decoded := triefs.NewTrie()
+	if err := json.Unmarshal(want, decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := decoded.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	got, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
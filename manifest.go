@@ -0,0 +1,170 @@
+package triefs
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ManifestEntry is a flat representation of a trie entry with absolute path
+type ManifestEntry struct {
+	Path         string            `json:"path"`
+	Name         string            `json:"name"`
+	CID          string            `json:"cid"`
+	Size         int64             `json:"size"`
+	Type         string            `json:"type"`
+	Version      byte              `json:"version"`
+	CreatedAt    int64             `json:"createdAt"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Checksum     string            `json:"checksum,omitempty"`
+	RefEntries   int64             `json:"refEntries,omitempty"`
+	ACL          []string          `json:"acl,omitempty"`
+	ID           string            `json:"id,omitempty"`
+	ModifiedAt   int64             `json:"modifiedAt,omitempty"`
+	DetectedType string            `json:"detectedType,omitempty"`
+}
+
+// MarshalManifest returns JSON array of all files and directories
+// with absolute paths sorted by path
+func (mt *Trie) MarshalManifest() ([]byte, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	return json.Marshal(mt.manifest())
+}
+
+// manifest returns flat entries of the whole trie sorted by path.
+// Callers must hold at least a read lock.
+func (mt *Trie) manifest() []ManifestEntry {
+	entries := mt.lsRecursive(Separator)
+	manifest := make([]ManifestEntry, 0, len(entries))
+	for _, e := range entries {
+		manifest = append(manifest, manifestEntry(e))
+	}
+	return manifest
+}
+
+func manifestEntry(e *Entry) ManifestEntry {
+	return ManifestEntry{
+		Path:         e.Path,
+		Name:         e.Name,
+		CID:          e.CID,
+		Size:         e.Size,
+		Type:         e.Type,
+		Version:      e.Version,
+		CreatedAt:    e.CreatedAt,
+		Metadata:     e.Metadata,
+		Checksum:     e.Checksum,
+		RefEntries:   e.RefEntries,
+		ACL:          e.ACL,
+		ID:           e.ID,
+		ModifiedAt:   e.ModifiedAt,
+		DetectedType: e.DetectedType,
+	}
+}
+
+// content returns file content of me named after its path
+func (me *ManifestEntry) content() Content {
+	return Content{
+		Name:         filepath.Base(me.Path),
+		CID:          me.CID,
+		Type:         me.Type,
+		Size:         me.Size,
+		Version:      me.Version,
+		CreatedAt:    me.CreatedAt,
+		Metadata:     me.Metadata,
+		Checksum:     me.Checksum,
+		RefEntries:   me.RefEntries,
+		ACL:          me.ACL,
+		ID:           me.ID,
+		ModifiedAt:   me.ModifiedAt,
+		DetectedType: me.DetectedType,
+	}
+}
+
+// LoadManifest builds new trie from the JSON produced by MarshalManifest.
+// Directories are implied by their children, only empty ones are added explicitly.
+// Entries are added in path order
+func LoadManifest(data []byte) (*Trie, error) {
+	var manifest []ManifestEntry
+	err := json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	return fromManifest(manifest)
+}
+
+// fromManifest builds new trie from flat entries, see LoadManifest
+func fromManifest(manifest []ManifestEntry) (*Trie, error) {
+	sort.SliceStable(manifest, func(i, j int) bool {
+		return manifest[i].Path < manifest[j].Path
+	})
+
+	parents := make(map[string]bool)
+	for _, me := range manifest {
+		parents[filepath.Dir(CleanPath(me.Path))] = true
+	}
+
+	mt := NewTrie()
+	for _, me := range manifest {
+		var e *Entry
+		if me.Type == MIMEDriveDirectory {
+			if parents[CleanPath(me.Path)] {
+				continue
+			}
+			e = NewEntry(me.Path, "", 0, MIMEDriveEntry, time.Unix(me.CreatedAt, 0))
+		} else {
+			e = &Entry{Path: me.Path, Content: me.content()}
+		}
+
+		_, err := mt.AddFile(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mt, nil
+}
+
+// PathContent pairs content with its absolute path, see BuildTrie
+type PathContent struct {
+	Path    string
+	Content Content
+}
+
+// BuildTrie builds new trie from items in any order. Like LoadManifest
+// directories are implied by their children and only empty ones are added,
+// the result is the same as of AddFile of every item in path order.
+// Returns the first error AddFile fails with
+func BuildTrie(items []PathContent) (*Trie, error) {
+	sorted := make([]PathContent, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return CleanPath(sorted[i].Path) < CleanPath(sorted[j].Path)
+	})
+
+	parents := make(map[string]bool)
+	for _, item := range sorted {
+		parents[filepath.Dir(CleanPath(item.Path))] = true
+	}
+
+	mt := NewTrie()
+	for _, item := range sorted {
+		var e *Entry
+		if item.Content.IsDirectory() {
+			if parents[CleanPath(item.Path)] {
+				continue
+			}
+			e = NewEntry(item.Path, "", 0, MIMEDriveEntry, time.Unix(item.Content.CreatedAt, 0))
+		} else {
+			e = &Entry{Path: item.Path, Content: *item.Content.copy()}
+			e.Name = filepath.Base(CleanPath(item.Path))
+		}
+
+		_, err := mt.AddFile(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mt, nil
+}
@@ -0,0 +1,174 @@
+package triefs_test
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestMarshalManifest(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/aaa/bbb/f", "cid1", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/empty", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	data, err := trie.MarshalManifest()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var manifest []triefs.ManifestEntry
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []triefs.ManifestEntry{
+		{Path: "/aaa", Name: "aaa", Type: triefs.MIMEDriveDirectory, CreatedAt: now.Unix()},
+		{Path: "/aaa/bbb", Name: "bbb", Type: triefs.MIMEDriveDirectory, CreatedAt: now.Unix()},
+		{Path: "/aaa/bbb/f", Name: "f", CID: "cid1", Size: 512, Type: triefs.MIMEOctetStream, Version: 1, CreatedAt: now.Unix()},
+		{Path: "/aaa/empty", Name: "empty", Type: triefs.MIMEDriveDirectory, CreatedAt: now.Unix()},
+	}
+	if !reflect.DeepEqual(manifest, expected) {
+		t.Errorf("got %+v, want %+v", manifest, expected)
+	}
+}
+
+func TestMarshalManifestEmpty(t *testing.T) {
+	t.Parallel()
+	data, err := triefs.NewTrie().MarshalManifest()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "[]" {
+		t.Errorf("got %s, want []", data)
+	}
+}
+
+func TestManifestRoundtrip(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	withMeta := triefs.NewEntry("/file", "cid5", 1, "text/plain", now)
+	withMeta.SetMetadata("owner", "alice")
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/aaa/bbb/file", "cid1", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/bbb/f", "cid2", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa b", "cid3", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/中文/文件.txt", "cid4", 1, triefs.MIMEOctetStream, now),
+		withMeta,
+		triefs.NewEntry("/aaa/empty", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// detected types are hashed too
+	trie.InferTypes()
+
+	data, err := trie.MarshalManifest()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded, err := triefs.LoadManifest(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, p := range []string{"/", "/aaa", "/aaa/bbb", "/中文"} {
+		if got, want := loaded.Ls(p), trie.Ls(p); !reflect.DeepEqual(got, want) {
+			t.Errorf("Ls(%q): got %v, want %v", p, got, want)
+		}
+	}
+
+	gotHash, err := loaded.Hash()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantHash, err := trie.Hash()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotHash != wantHash {
+		t.Errorf("got %v, want %v", gotHash, wantHash)
+	}
+}
+
+func TestLoadManifestInvalid(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		data string
+	}{
+		{name: "malformed json", data: "{"},
+		{name: "illegal name", data: `[{"path":"/a:b","type":"text/plain"}]`},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := triefs.LoadManifest([]byte(tc.data)); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestBuildTrie(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	items := []triefs.PathContent{
+		{Path: "/aaa", Content: triefs.NewContent("aaa", "", 0, triefs.MIMEDriveDirectory, now)},
+		{Path: "/aaa b", Content: triefs.NewContent("aaa b", "cid3", 1, triefs.MIMEOctetStream, now)},
+		{Path: "/aaa/bbb/f", Content: triefs.NewContent("f", "cid2", 512, triefs.MIMEOctetStream, now)},
+		{Path: "/aaa/bbb/file", Content: triefs.NewContent("file", "cid1", 512, triefs.MIMEOctetStream, now)},
+		{Path: "/aaa/empty", Content: triefs.NewContent("", "", 0, triefs.MIMEDriveEntry, now)},
+		{Path: "/file", Content: triefs.NewContent("file", "cid5", 1, "text/plain", now)},
+		{Path: "/中文/文件.txt", Content: triefs.NewContent("文件.txt", "cid4", 1, triefs.MIMEOctetStream, now)},
+	}
+
+	want, err := triefs.BuildTrie(items)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := len(want.LsRecursive("/")); got != 9 {
+		t.Errorf("got %v, want %v", got, 9)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		shuffled := make([]triefs.PathContent, len(items))
+		copy(shuffled, items)
+		r.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+
+		got, err := triefs.BuildTrie(shuffled)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !triefs.Equal(got, want) {
+			t.Errorf("got %v, want %v", got.LsRecursive("/"), want.LsRecursive("/"))
+		}
+	}
+
+	conflicting := append(items, triefs.PathContent{
+		Path:    "/file/nested",
+		Content: triefs.NewContent("nested", "cid6", 1, triefs.MIMEOctetStream, now),
+	})
+	if _, err := triefs.BuildTrie(conflicting); err != triefs.ErrConflict {
+		t.Errorf("got %v, want %v", err, triefs.ErrConflict)
+	}
+}
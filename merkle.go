@@ -0,0 +1,168 @@
+package triefs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+)
+
+// SubtreeHash returns a Merkle hash of the entry at path. A file hashes its
+// JSON encoded content, a directory the names and hashes of its entries, so
+// a change beneath a directory changes its hash and the hashes of its
+// parents while untouched siblings keep theirs, see ChangedSince. Unlike
+// Hash it doesn't depend on the trie structure and doesn't cover directory
+// timestamps. Returns ErrFileNotExist for a missing path
+func (mt *Trie) SubtreeHash(path string) (string, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if len(path) == 0 {
+		return "", ErrEmptyPath
+	}
+	p := CleanPath(mt.swap(path))
+	hashes, err := mt.subtreeHashes(p)
+	if err != nil {
+		return "", err
+	}
+	return hashes[p], nil
+}
+
+// StatWithHash returns Stat and SubtreeHash of path under a single lock, so
+// the hash describes exactly the returned entry. For files it's the hash of
+// the file itself. Returns ErrFileNotExist for a missing path
+func (mt *Trie) StatWithHash(path string) (*Content, string, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if len(path) == 0 {
+		return nil, "", ErrEmptyPath
+	}
+	p := CleanPath(mt.swap(path))
+	c, err := mt.statPath(p)
+	if err != nil {
+		return nil, "", err
+	}
+	hashes, err := mt.subtreeHashes(p)
+	if err != nil {
+		return nil, "", err
+	}
+	return mt.swapContent(c), hashes[p], nil
+}
+
+// ChangedSince returns sorted paths of entries whose SubtreeHash differs
+// from knownSubtreeHashes, hashes a client computed for its copy of the trie
+// keyed by absolute path. The trie is walked from root down and subtrees
+// having the known hash are skipped along with everything beneath them.
+// Entries the client doesn't know are returned with their whole subtrees,
+// known entries missing from the trie are returned when their directory is
+// reached. Nothing is returned when root hashes match
+func (mt *Trie) ChangedSince(knownSubtreeHashes map[string]string) []string {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	hashes, err := mt.subtreeHashes(Separator)
+	if err != nil {
+		return []string{}
+	}
+
+	known := make(map[string]string, len(knownSubtreeHashes))
+	// known paths by their parent to find removed ones
+	knownChildren := make(map[string][]string)
+	for path, hash := range knownSubtreeHashes {
+		p := CleanPath(mt.swap(path))
+		known[p] = hash
+		if p != Separator {
+			dir := filepath.Dir(p)
+			knownChildren[dir] = append(knownChildren[dir], p)
+		}
+	}
+
+	children := make(map[string][]string)
+	for p := range hashes {
+		if p != Separator {
+			dir := filepath.Dir(p)
+			children[dir] = append(children[dir], p)
+		}
+	}
+
+	res := make([]string, 0)
+	var walk func(p string)
+	walk = func(p string) {
+		if hash, ok := known[p]; ok && hash == hashes[p] {
+			return
+		}
+		res = append(res, p)
+		for _, c := range children[p] {
+			walk(c)
+		}
+		for _, c := range knownChildren[p] {
+			if _, ok := hashes[c]; !ok {
+				res = append(res, c)
+			}
+		}
+	}
+	walk(Separator)
+
+	sort.Strings(res)
+	return mt.swapPaths(res)
+}
+
+// subtreeHashes returns SubtreeHash of the cleaned path p and of every entry
+// beneath it keyed by absolute path, each subtree is hashed once.
+// Callers must hold at least a read lock.
+func (mt *Trie) subtreeHashes(p string) (map[string]string, error) {
+	c, err := mt.statPath(p)
+	if err != nil {
+		return nil, err
+	}
+	if !c.IsDirectory() {
+		return map[string]string{p: fileHash(p, c)}, nil
+	}
+
+	entries := mt.lsRecursive(p)
+	paths := make([]string, len(entries))
+	children := make(map[string][]string)
+	for i, e := range entries {
+		paths[i] = JoinPath(p, e.Path)
+		dir := filepath.Dir(paths[i])
+		children[dir] = append(children[dir], paths[i])
+	}
+
+	// entries follow their directories, so going backwards every directory
+	// is hashed after its entries
+	hashes := make(map[string]string, len(entries)+1)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if e := entries[i]; e.IsDirectory() {
+			hashes[paths[i]] = dirHash(children[paths[i]], hashes)
+		} else {
+			hashes[paths[i]] = fileHash(paths[i], &e.Content)
+		}
+	}
+	hashes[p] = dirHash(children[p], hashes)
+	return hashes, nil
+}
+
+// fileHash hashes c named after the base of path p
+func fileHash(p string, c *Content) string {
+	cnt := c.copy()
+	cnt.Name = filepath.Base(p)
+	// Content has no values json can't encode
+	data, _ := json.Marshal(cnt)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// dirHash hashes names and hashes of entries of a directory
+func dirHash(entries []string, hashes map[string]string) string {
+	sort.Strings(entries)
+	h := sha256.New()
+	for _, p := range entries {
+		h.Write([]byte(filepath.Base(p)))
+		h.Write([]byte{0})
+		h.Write([]byte(hashes[p]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,141 @@
+package triefs_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func merkleTrie(t *testing.T, now time.Time, paths []string) *triefs.Trie {
+	t.Helper()
+	trie := triefs.NewTrie()
+	for _, p := range paths {
+		if _, err := trie.AddFile(triefs.NewEntry(p, "cid"+p, 1, triefs.MIMEOctetStream, now)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return trie
+}
+
+// knownHashes returns SubtreeHash of every path of trie
+func knownHashes(t *testing.T, trie *triefs.Trie) map[string]string {
+	t.Helper()
+	known := make(map[string]string)
+	for _, p := range append([]string{"/"}, trie.Paths()...) {
+		hash, err := trie.SubtreeHash(p)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		known[p] = hash
+	}
+	return known
+}
+
+func TestSubtreeHash(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	paths := []string{"/a/f1", "/a/sub/f2", "/b/f3", "/b/f4"}
+	trie := merkleTrie(t, now, paths)
+	reversed := merkleTrie(t, now, []string{paths[3], paths[2], paths[1], paths[0]})
+	if got, want := knownHashes(t, reversed), knownHashes(t, trie); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	before := knownHashes(t, trie)
+	c := triefs.NewContent("f2", "cid5", 5, triefs.MIMEOctetStream, now)
+	if _, _, err := trie.Replace("/a/sub/f2", &c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after := knownHashes(t, trie)
+	for _, p := range []string{"/", "/a", "/a/sub", "/a/sub/f2"} {
+		if after[p] == before[p] {
+			t.Errorf("%v: expected hash to change", p)
+		}
+	}
+	for _, p := range []string{"/a/f1", "/b", "/b/f3", "/b/f4"} {
+		if after[p] != before[p] {
+			t.Errorf("%v: got %v, want %v", p, after[p], before[p])
+		}
+	}
+
+	if _, err := trie.SubtreeHash("/missing"); err != triefs.ErrFileNotExist {
+		t.Errorf("got %v, want %v", err, triefs.ErrFileNotExist)
+	}
+}
+
+func TestStatWithHash(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := merkleTrie(t, now, []string{"/a/f1", "/a/sub/f2", "/b/f3"})
+
+	for _, p := range []string{"/", "/a", "/a/sub", "/a/f1"} {
+		c, hash, err := trie.StatWithHash(p)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", p, err)
+		}
+		stat, _ := trie.Stat(p)
+		if !reflect.DeepEqual(c, stat) {
+			t.Errorf("%v: got %v, want %v", p, c, stat)
+		}
+		if want, _ := trie.SubtreeHash(p); hash != want {
+			t.Errorf("%v: got %v, want %v", p, hash, want)
+		}
+	}
+
+	_, before, _ := trie.StatWithHash("/a")
+	c := triefs.NewContent("f2", "cid7", 7, triefs.MIMEOctetStream, now)
+	if _, _, err := trie.Replace("/a/sub/f2", &c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, after, err := trie.StatWithHash("/a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if after == before {
+		t.Errorf("expected hash to change")
+	}
+	if want, _ := trie.SubtreeHash("/a"); after != want {
+		t.Errorf("got %v, want %v", after, want)
+	}
+
+	if _, _, err := trie.StatWithHash("/missing"); err != triefs.ErrFileNotExist {
+		t.Errorf("got %v, want %v", err, triefs.ErrFileNotExist)
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	paths := []string{"/a/f1", "/a/gone", "/a/sub/f2", "/b/f3", "/b/sub/f4"}
+	client := merkleTrie(t, now, paths)
+	server := merkleTrie(t, now, paths)
+	known := knownHashes(t, client)
+
+	if got := server.ChangedSince(known); len(got) != 0 {
+		t.Errorf("got %v, want nothing", got)
+	}
+
+	c := triefs.NewContent("f1", "cid6", 6, triefs.MIMEOctetStream, now)
+	if _, _, err := server.Replace("/a/f1", &c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := server.Delete("/a/gone"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := server.AddFile(triefs.NewEntry("/a/new/f5", "cid5", 5, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"/", "/a", "/a/f1", "/a/gone", "/a/new", "/a/new/f5"}
+	if got := server.ChangedSince(known); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+
+	// a client knowing nothing gets everything
+	all := append([]string{"/"}, server.Paths()...)
+	if got := server.ChangedSince(nil); !reflect.DeepEqual(got, all) {
+		t.Errorf("got %v, want %v", got, all)
+	}
+}
@@ -0,0 +1,416 @@
+package triefs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Rename changes the last segment of path to newName keeping the entry in
+// the same parent directory and returns the new absolute path. Directories
+// are renamed along with all their descendants. ErrConflict is returned
+// when a sibling with newName already exists
+func (mt *Trie) Rename(path, newName string) (string, error) {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if len(path) == 0 {
+		return "", ErrEmptyPath
+	}
+	newName = mt.swap(newName)
+	err := ValidateName(newName)
+	if err != nil {
+		return "", err
+	}
+	newName, err = mt.sanitizeName(newName)
+	if err != nil {
+		return "", err
+	}
+
+	p := CleanPath(mt.swap(path))
+	if p == Separator {
+		return "", ErrFileNotExist
+	}
+
+	dst := JoinPath(filepath.Dir(p), newName)
+	return mt.swap(dst), mt.move(p, dst)
+}
+
+// Move moves the entry at src along with its descendants to dst, the parent
+// directory of dst must exist. ErrConflict is returned when dst already exists
+// and ErrInvalidMove when dst is src itself or its descendant
+func (mt *Trie) Move(src, dst string) error {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if len(src) == 0 || len(dst) == 0 {
+		return ErrEmptyPath
+	}
+
+	s, d := CleanPath(mt.swap(src)), CleanPath(mt.swap(dst))
+	if s == Separator || d == Separator {
+		return ErrFileNotExist
+	}
+	err := ValidateName(filepath.Base(d))
+	if err != nil {
+		return err
+	}
+	name, err := mt.sanitizeName(filepath.Base(d))
+	if err != nil {
+		return err
+	}
+	d = JoinPath(filepath.Dir(d), name)
+	if isSubpath(d, s) {
+		return ErrInvalidMove
+	}
+	err = mt.checkDir(filepath.Dir(d))
+	if err != nil {
+		return err
+	}
+	return mt.move(s, d)
+}
+
+// MoveInto moves src into destDir keeping its name and returns the new
+// absolute path. A directory can't be moved into itself or its descendant
+func (mt *Trie) MoveInto(src, destDir string) (string, error) {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if len(src) == 0 || len(destDir) == 0 {
+		return "", ErrEmptyPath
+	}
+
+	d := CleanPath(mt.swap(destDir))
+	err := mt.checkDir(d)
+	if err != nil {
+		return "", err
+	}
+	dst, err := mt.moveInto(src, d)
+	return mt.swap(dst), err
+}
+
+// MoveManyInto moves every src into destDir keeping their names and returns
+// the new absolute paths in order of srcs. It's all or nothing: if any src
+// can't be moved the trie is restored from a copy taken beforehand and the
+// returned error joins errors of every failed src prefixed with it, e.g.
+// ErrConflict for collisions or ErrInvalidMove for a directory moved into
+// itself. Use errors.Is to check for them
+func (mt *Trie) MoveManyInto(srcs []string, destDir string) ([]string, error) {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if len(destDir) == 0 {
+		return nil, ErrEmptyPath
+	}
+
+	d := CleanPath(mt.swap(destDir))
+	err := mt.checkDir(d)
+	if err != nil {
+		return nil, err
+	}
+
+	rollback := mt.savepoint()
+	moved := make([]string, 0, len(srcs))
+	errs := make([]error, 0)
+	for _, src := range srcs {
+		dst, err := mt.moveInto(src, d)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", src, err))
+			continue
+		}
+		moved = append(moved, mt.swap(dst))
+	}
+
+	if len(errs) != 0 {
+		rollback()
+		return nil, errors.Join(errs...)
+	}
+	return moved, nil
+}
+
+// MoveGlob moves entries matching pattern, see Glob, into destDir keeping
+// their names and returns the new absolute paths in path order of the
+// matches. Descendants of a matched directory move along with it, entries
+// already in destDir stay and destDir with its ancestors is never moved.
+// Name collisions in destDir fail with ErrConflict unless unique is set,
+// then the entry gets a free name like in AddFileUnique. It's all or
+// nothing, on failure the trie is restored and the error names the entry
+func (mt *Trie) MoveGlob(pattern, destDir string, unique bool) ([]string, error) {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if len(destDir) == 0 {
+		return nil, ErrEmptyPath
+	}
+
+	d := CleanPath(mt.swap(destDir))
+	err := mt.checkDir(d)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := mt.glob(mt.swap(pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	rollback := mt.savepoint()
+	moved := make([]string, 0, len(matches))
+	taken := make(map[string]bool, len(matches))
+	for _, src := range matches {
+		if isSubpath(d, src) || movedWithParent(src, taken) {
+			continue
+		}
+		taken[src] = true
+
+		dst := src
+		if filepath.Dir(src) != d {
+			name := filepath.Base(src)
+			if unique {
+				name = mt.suggestName(d, name)
+			}
+			dst = JoinPath(d, name)
+			err := mt.move(src, dst)
+			if err != nil {
+				rollback()
+				return nil, fmt.Errorf("%s: %w", mt.swap(src), err)
+			}
+		}
+		moved = append(moved, mt.swap(dst))
+	}
+	return moved, nil
+}
+
+// RenameExtension renames files beneath the directory at path whose names
+// end with "."+from to end with "."+to instead, e.g. "jpeg" to "jpg", and
+// returns the number of renamed files. Leading dots of from and to are
+// optional. Directories and references are left alone. Name collisions fail
+// with ErrConflict unless unique is set, then the file gets a free name like
+// in AddFileUnique. It's all or nothing like MoveGlob
+func (mt *Trie) RenameExtension(path, from, to string, unique bool) (int, error) {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if len(path) == 0 {
+		return 0, ErrEmptyPath
+	}
+	from, to = "."+strings.TrimPrefix(mt.swap(from), "."), "."+strings.TrimPrefix(mt.swap(to), ".")
+	for _, ext := range []string{from, to} {
+		err := ValidateName(ext[1:])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	p := CleanPath(mt.swap(path))
+	err := mt.checkDir(p)
+	if err != nil {
+		return 0, err
+	}
+	if from == to {
+		return 0, nil
+	}
+
+	rollback := mt.savepoint()
+	renamed := 0
+	for _, e := range mt.lsRecursive(p) {
+		if e.IsDirectory() || e.Type == MIMEReference || len(e.Name) <= len(from) || !strings.HasSuffix(e.Name, from) {
+			continue
+		}
+		src := JoinPath(p, e.Path)
+		dir := filepath.Dir(src)
+		name := strings.TrimSuffix(e.Name, from) + to
+		if unique {
+			name = mt.suggestName(dir, name)
+		}
+		err := mt.move(src, JoinPath(dir, name))
+		if err != nil {
+			rollback()
+			return 0, fmt.Errorf("%s: %w", mt.swap(src), err)
+		}
+		renamed++
+	}
+	return renamed, nil
+}
+
+// movedWithParent checks if any parent of path is in moved
+func movedWithParent(path string, moved map[string]bool) bool {
+	for dir := filepath.Dir(path); dir != Separator; dir = filepath.Dir(dir) {
+		if moved[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// savepoint copies the trie and returns a func restoring it along with
+// queued events, the epoch and the counters.
+// Callers must hold the write lock until the returned func is called.
+func (mt *Trie) savepoint() func() {
+	var backup *Entry
+	if mt.Root != nil {
+		backup = mt.Root.copy()
+	}
+	pending, epoch := len(mt.pending), mt.epoch
+	used, files, dirs := mt.used, mt.files, mt.dirs
+	return func() {
+		mt.Root = backup
+		mt.pending = mt.pending[:pending]
+		mt.epoch = epoch
+		mt.used, mt.files, mt.dirs = used, files, dirs
+		mt.cache.clear()
+		mt.reindexSearch()
+	}
+}
+
+// moveInto moves src into the existing directory at cleaned path dir and
+// returns the new internal path.
+// Callers must hold the write lock.
+func (mt *Trie) moveInto(src string, dir string) (string, error) {
+	if len(src) == 0 {
+		return "", ErrEmptyPath
+	}
+
+	s := CleanPath(mt.swap(src))
+	if s == Separator {
+		return "", ErrFileNotExist
+	}
+	if isSubpath(dir, s) {
+		return "", ErrInvalidMove
+	}
+
+	dst := JoinPath(dir, filepath.Base(s))
+	return dst, mt.move(s, dst)
+}
+
+// MoveToRoot moves src to the top level keeping its name and returns the
+// new absolute path
+func (mt *Trie) MoveToRoot(src string) (string, error) {
+	return mt.MoveInto(src, string(mt.Separator()))
+}
+
+// isSubpath checks if cleaned path is equal to or a descendant of cleaned
+// ancestor. Only whole segments match, so "/a/bc" isn't a subpath of "/a/b"
+func isSubpath(path, ancestor string) bool {
+	if path == ancestor || ancestor == Separator {
+		return true
+	}
+	return strings.HasPrefix(path, ancestor+Separator)
+}
+
+// checkDir returns nil if path is an existing directory.
+// Callers must hold at least a read lock.
+func (mt *Trie) checkDir(path string) error {
+	if path == Separator {
+		return nil
+	}
+	if mt.Root == nil {
+		return ErrFileNotExist
+	}
+
+	f := stat(path, mt.Root)
+	if f == nil {
+		return ErrFileNotExist
+	}
+	if !f.IsDirectory() {
+		return ErrNotADirectory
+	}
+	return nil
+}
+
+// move relocates the entry at src along with its descendants to dst. Entries
+// are re-added under dst first and removed from src afterwards, a failed add
+// rolls back what was already added. Moved entries don't count twice against
+// the quota or, within the same directory, against MaxChildren.
+// Callers must hold the write lock.
+func (mt *Trie) move(src, dst string) error {
+	entries := mt.subtree(src)
+	if len(entries) == 0 {
+		return ErrFileNotExist
+	}
+	if src == dst {
+		return nil
+	}
+	if stat(dst, mt.Root) != nil {
+		return ErrConflict
+	}
+
+	// the source is credited while its copy is added
+	var moved int64
+	for _, e := range entries {
+		moved += usage(&e.Content)
+	}
+	mt.used -= moved
+	maxChildren := mt.maxChildren
+	if maxChildren > 0 && filepath.Dir(src) == filepath.Dir(dst) {
+		mt.maxChildren++
+	}
+	err := mt.readd(entries, dst)
+	mt.used += moved
+	mt.maxChildren = maxChildren
+	if err != nil {
+		return err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		mt.remove(JoinPath(src, entries[i].Path))
+	}
+	mt.notify(OpMove, src, nil)
+	mt.notify(OpMove, dst, nil)
+	return nil
+}
+
+// readd adds entries returned by subtree under dst, a failed add rolls back
+// what was already added.
+// Callers must hold the write lock.
+func (mt *Trie) readd(entries []*Entry, dst string) error {
+	added := make([]string, 0, len(entries))
+	for _, e := range entries {
+		p := JoinPath(dst, e.Path)
+		var m *Entry
+		if e.IsDirectory() {
+			m = NewEntry(p, "", 0, MIMEDriveEntry, time.Unix(e.CreatedAt, 0))
+		} else {
+			m = &Entry{Content: *e.Content.copy(), Path: p}
+			m.Name = filepath.Base(p)
+		}
+
+		_, err := mt.addFile(m)
+		if err != nil {
+			for i := len(added) - 1; i >= 0; i-- {
+				mt.remove(added[i])
+			}
+			return err
+		}
+		added = append(added, p)
+	}
+	return nil
+}
+
+// subtree returns the entry at path followed by all its descendants, parents
+// always go before their children. Paths are relative to path, the entry
+// itself has an empty path.
+// Callers must hold at least a read lock.
+func (mt *Trie) subtree(path string) []*Entry {
+	entries := make([]*Entry, 0)
+	if mt.Root == nil || path == Separator {
+		return entries
+	}
+
+	f := find(path, mt.Root)
+	children := listRecursive(path, path, mt.Root)
+	if f != nil && !f.IsDirectory() {
+		entries = append(entries, &Entry{Content: *f.copy()})
+	}
+	if (f != nil && f.IsDirectory()) || len(children) != 0 {
+		var createdAt int64
+		if d := stat(path, mt.Root); d != nil {
+			createdAt = d.CreatedAt
+		}
+		cnt := NewContent(filepath.Base(path), "", 0, MIMEDriveDirectory, time.Unix(createdAt, 0))
+		entries = append(entries, &Entry{Content: cnt})
+		entries = append(entries, children...)
+	}
+	return entries
+}
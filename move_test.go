@@ -0,0 +1,754 @@
+package triefs_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func recursivePaths(trie *triefs.Trie, path string) []string {
+	paths := make([]string, 0)
+	for _, e := range trie.LsRecursive(path) {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+func TestRename(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	cases := []struct {
+		name     string
+		path     string
+		newName  string
+		dirs     []*triefs.Entry
+		err      error
+		newPath  string
+		expected []string
+	}{
+		{
+			name:    "rename file",
+			path:    "/docs/readme.txt",
+			newName: "README.md",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/docs/readme.txt", "cid1", 128, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/docs/notes.txt", "cid2", 64, triefs.MIMEOctetStream, now),
+			},
+			newPath:  "/docs/README.md",
+			expected: []string{"/docs", "/docs/README.md", "/docs/notes.txt"},
+		},
+		{
+			name:    "rename non-empty directory",
+			path:    "/test",
+			newName: "test rename",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/test", "", 0, triefs.MIMEDriveEntry, now),
+				triefs.NewEntry("/test/some folder", "", 0, triefs.MIMEDriveEntry, now),
+				triefs.NewEntry("/test/some folder/file", "cid1", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/test/file", "cid2", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/tests", "cid3", 1, triefs.MIMEOctetStream, now),
+			},
+			newPath: "/test rename",
+			expected: []string{
+				"/test rename",
+				"/test rename/file",
+				"/test rename/some folder",
+				"/test rename/some folder/file",
+				"/tests",
+			},
+		},
+		{
+			name:    "rename to existing sibling",
+			path:    "/docs/readme.txt",
+			newName: "notes.txt",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/docs/readme.txt", "cid1", 128, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/docs/notes.txt", "cid2", 64, triefs.MIMEOctetStream, now),
+			},
+			err:      triefs.ErrConflict,
+			expected: []string{"/docs", "/docs/notes.txt", "/docs/readme.txt"},
+		},
+		{
+			name:    "rename to existing sibling directory",
+			path:    "/docs/readme.txt",
+			newName: "sub",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/docs/readme.txt", "cid1", 128, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/docs/sub", "", 0, triefs.MIMEDriveEntry, now),
+			},
+			err:      triefs.ErrConflict,
+			expected: []string{"/docs", "/docs/readme.txt", "/docs/sub"},
+		},
+		{
+			name:    "rename to empty name",
+			path:    "/Dir1",
+			newName: "",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/Dir1", "", 0, triefs.MIMEDriveEntry, now),
+			},
+			err:      triefs.ErrEmptyName,
+			expected: []string{"/Dir1"},
+		},
+		{
+			name:    "rename to name with slash",
+			path:    "/Test.txt",
+			newName: "/",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/Test.txt", "fake_cid", 512, triefs.MIMEOctetStream, now),
+			},
+			err:      triefs.ErrIllegalNameChars,
+			expected: []string{"/Test.txt"},
+		},
+		{
+			name:    "rename to name with semicolon",
+			path:    "/Test.txt",
+			newName: "a:b",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/Test.txt", "fake_cid", 512, triefs.MIMEOctetStream, now),
+			},
+			err:      triefs.ErrIllegalNameChars,
+			expected: []string{"/Test.txt"},
+		},
+		{
+			name:    "rename missing",
+			path:    "/missing",
+			newName: "found",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/Test.txt", "fake_cid", 512, triefs.MIMEOctetStream, now),
+			},
+			err:      triefs.ErrFileNotExist,
+			expected: []string{"/Test.txt"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			trie := triefs.NewTrie()
+			for _, d := range tc.dirs {
+				_, err := trie.AddFile(d)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			newPath, err := trie.Rename(tc.path, tc.newName)
+			if err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if err == nil && newPath != tc.newPath {
+				t.Errorf("got %v, want %v", newPath, tc.newPath)
+			}
+
+			paths := recursivePaths(trie, "/")
+			if !reflect.DeepEqual(paths, tc.expected) {
+				t.Errorf("got %v, want %v", paths, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRenameKeepsContent(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	e := triefs.NewEntry("/docs/readme.txt", "cid1", 128, triefs.MIMEOctetStream, now)
+	e.SetMetadata("k", "v")
+	_, err := trie.AddFile(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = trie.Rename("/docs", "documents")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := trie.File("/documents/readme.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := triefs.NewContent("readme.txt", "cid1", 128, triefs.MIMEOctetStream, now)
+	expected.Metadata = map[string]string{"k": "v"}
+	if !reflect.DeepEqual(*f, expected) {
+		t.Errorf("got %v, want %v", *f, expected)
+	}
+}
+
+func TestMove(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	dirs := []*triefs.Entry{
+		triefs.NewEntry("/docs/readme.txt", "cid1", 128, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/sub/notes.txt", "cid2", 64, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/archive", "", 0, triefs.MIMEDriveEntry, now),
+	}
+	cases := []struct {
+		name     string
+		src      string
+		dst      string
+		err      error
+		expected []string
+	}{
+		{
+			name:     "move file with new name",
+			src:      "/docs/readme.txt",
+			dst:      "/archive/old.txt",
+			expected: []string{"/archive", "/archive/old.txt", "/docs", "/docs/sub", "/docs/sub/notes.txt"},
+		},
+		{
+			name:     "move directory",
+			src:      "/docs/sub",
+			dst:      "/sub",
+			expected: []string{"/archive", "/docs", "/docs/readme.txt", "/sub", "/sub/notes.txt"},
+		},
+		{
+			name:     "missing parent",
+			src:      "/docs/readme.txt",
+			dst:      "/missing/readme.txt",
+			err:      triefs.ErrFileNotExist,
+			expected: []string{"/archive", "/docs", "/docs/readme.txt", "/docs/sub", "/docs/sub/notes.txt"},
+		},
+		{
+			name:     "parent is a file",
+			src:      "/docs/sub/notes.txt",
+			dst:      "/docs/readme.txt/notes.txt",
+			err:      triefs.ErrNotADirectory,
+			expected: []string{"/archive", "/docs", "/docs/readme.txt", "/docs/sub", "/docs/sub/notes.txt"},
+		},
+		{
+			name:     "existing destination",
+			src:      "/docs/readme.txt",
+			dst:      "/archive",
+			err:      triefs.ErrConflict,
+			expected: []string{"/archive", "/docs", "/docs/readme.txt", "/docs/sub", "/docs/sub/notes.txt"},
+		},
+		{
+			name:     "empty destination",
+			src:      "/docs/readme.txt",
+			dst:      "",
+			err:      triefs.ErrEmptyPath,
+			expected: []string{"/archive", "/docs", "/docs/readme.txt", "/docs/sub", "/docs/sub/notes.txt"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := triefs.NewTrie()
+			for _, d := range dirs {
+				if _, err := trie.AddFile(d); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			err := trie.Move(tc.src, tc.dst)
+			if err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+
+			paths := recursivePaths(trie, "/")
+			if !reflect.DeepEqual(paths, tc.expected) {
+				t.Errorf("got %v, want %v", paths, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMoveInto(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	dirs := []*triefs.Entry{
+		triefs.NewEntry("/docs/readme.txt", "cid1", 128, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/sub/notes.txt", "cid2", 64, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/archive/readme.txt", "cid3", 32, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/photos", "", 0, triefs.MIMEDriveEntry, now),
+	}
+	unchanged := []string{
+		"/archive", "/archive/readme.txt",
+		"/docs", "/docs/readme.txt", "/docs/sub", "/docs/sub/notes.txt",
+		"/photos",
+	}
+	cases := []struct {
+		name     string
+		src      string
+		destDir  string
+		err      error
+		newPath  string
+		expected []string
+	}{
+		{
+			name:    "move file",
+			src:     "/docs/sub/notes.txt",
+			destDir: "/photos",
+			newPath: "/photos/notes.txt",
+			expected: []string{
+				"/archive", "/archive/readme.txt",
+				"/docs", "/docs/readme.txt", "/docs/sub",
+				"/photos", "/photos/notes.txt",
+			},
+		},
+		{
+			name:    "move subtree",
+			src:     "/docs",
+			destDir: "/photos",
+			newPath: "/photos/docs",
+			expected: []string{
+				"/archive", "/archive/readme.txt",
+				"/photos", "/photos/docs", "/photos/docs/readme.txt", "/photos/docs/sub", "/photos/docs/sub/notes.txt",
+			},
+		},
+		{
+			name:    "move to root",
+			src:     "/docs/sub",
+			destDir: "/",
+			newPath: "/sub",
+			expected: []string{
+				"/archive", "/archive/readme.txt",
+				"/docs", "/docs/readme.txt",
+				"/photos",
+				"/sub", "/sub/notes.txt",
+			},
+		},
+		{
+			name:     "name taken in destination",
+			src:      "/docs/readme.txt",
+			destDir:  "/archive",
+			err:      triefs.ErrConflict,
+			expected: unchanged,
+		},
+		{
+			name:     "missing source",
+			src:      "/docs/missing.txt",
+			destDir:  "/archive",
+			err:      triefs.ErrFileNotExist,
+			expected: unchanged,
+		},
+		{
+			name:     "missing destination",
+			src:      "/docs/readme.txt",
+			destDir:  "/missing",
+			err:      triefs.ErrFileNotExist,
+			expected: unchanged,
+		},
+		{
+			name:     "destination is a file",
+			src:      "/docs/sub",
+			destDir:  "/docs/readme.txt",
+			err:      triefs.ErrNotADirectory,
+			expected: unchanged,
+		},
+		{
+			name:     "into own descendant",
+			src:      "/docs",
+			destDir:  "/docs/sub",
+			err:      triefs.ErrInvalidMove,
+			expected: unchanged,
+		},
+		{
+			name:     "into itself",
+			src:      "/docs",
+			destDir:  "/docs",
+			err:      triefs.ErrInvalidMove,
+			expected: unchanged,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := triefs.NewTrie()
+			for _, d := range dirs {
+				if _, err := trie.AddFile(d); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			newPath, err := trie.MoveInto(tc.src, tc.destDir)
+			if err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if err == nil && newPath != tc.newPath {
+				t.Errorf("got %v, want %v", newPath, tc.newPath)
+			}
+
+			paths := recursivePaths(trie, "/")
+			if !reflect.DeepEqual(paths, tc.expected) {
+				t.Errorf("got %v, want %v", paths, tc.expected)
+			}
+		})
+	}
+}
+
+func TestInvalidMove(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	dirs := []*triefs.Entry{
+		triefs.NewEntry("/a/b/c/file", "cid1", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/a/bc", "", 0, triefs.MIMEDriveEntry, now),
+	}
+	cases := []struct {
+		name string
+		move func(trie *triefs.Trie) error
+		err  error
+	}{
+		{
+			name: "move onto itself",
+			move: func(trie *triefs.Trie) error { return trie.Move("/a/b", "/a/b") },
+			err:  triefs.ErrInvalidMove,
+		},
+		{
+			name: "move into itself",
+			move: func(trie *triefs.Trie) error { _, err := trie.MoveInto("/a/b/", "/a/b"); return err },
+			err:  triefs.ErrInvalidMove,
+		},
+		{
+			name: "move under descendant",
+			move: func(trie *triefs.Trie) error { return trie.Move("/a/b", "/a/b/c/b") },
+			err:  triefs.ErrInvalidMove,
+		},
+		{
+			name: "move into descendant",
+			move: func(trie *triefs.Trie) error { _, err := trie.MoveInto("/a/b", "/a//b/c/"); return err },
+			err:  triefs.ErrInvalidMove,
+		},
+		{
+			name: "move root child into root descendant",
+			move: func(trie *triefs.Trie) error { _, err := trie.MoveInto("/a", "/a/bc"); return err },
+			err:  triefs.ErrInvalidMove,
+		},
+		{
+			name: "move under similar sibling",
+			move: func(trie *triefs.Trie) error { return trie.Move("/a/b", "/a/bc/b") },
+		},
+		{
+			name: "move into similar sibling",
+			move: func(trie *triefs.Trie) error { _, err := trie.MoveInto("/a/b", "/a/bc"); return err },
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := triefs.NewTrie()
+			for _, d := range dirs {
+				if _, err := trie.AddFile(d); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			err := tc.move(trie)
+			if err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+
+			paths := recursivePaths(trie, "/")
+			expected := []string{"/a", "/a/b", "/a/b/c", "/a/b/c/file", "/a/bc"}
+			if err == nil {
+				expected = []string{"/a", "/a/bc", "/a/bc/b", "/a/bc/b/c", "/a/bc/b/c/file"}
+			}
+			if !reflect.DeepEqual(paths, expected) {
+				t.Errorf("got %v, want %v", paths, expected)
+			}
+		})
+	}
+}
+
+func TestMoveToRoot(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, d := range []*triefs.Entry{
+		triefs.NewEntry("/a/b/deep/file.txt", "cid1", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/a/b/deep/sub", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/a/b/other.txt", "cid2", 2, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/top.txt", "cid3", 3, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(d); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	newPath, err := trie.MoveToRoot("/a/b/deep")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if newPath != "/deep" {
+		t.Errorf("got %v, want %v", newPath, "/deep")
+	}
+
+	expected := []string{
+		"/a", "/a/b", "/a/b/other.txt",
+		"/deep", "/deep/file.txt", "/deep/sub",
+		"/top.txt",
+	}
+	if paths := recursivePaths(trie, "/"); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("got %v, want %v", paths, expected)
+	}
+	if _, err := trie.Stat("/a/b/deep"); err != triefs.ErrFileNotExist {
+		t.Errorf("got %v, want %v", err, triefs.ErrFileNotExist)
+	}
+
+	newPath, err = trie.MoveToRoot("/top.txt")
+	if err != nil || newPath != "/top.txt" {
+		t.Errorf("got %v %v, want /top.txt without error", newPath, err)
+	}
+	if _, err := trie.MoveToRoot("/missing"); err != triefs.ErrFileNotExist {
+		t.Errorf("got %v, want %v", err, triefs.ErrFileNotExist)
+	}
+	if _, err := trie.MoveToRoot("/a/b/other.txt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/a/other.txt", "cid4", 4, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.MoveToRoot("/a/other.txt"); err != triefs.ErrConflict {
+		t.Errorf("got %v, want %v", err, triefs.ErrConflict)
+	}
+	if _, err := trie.Rename("/deep", ""); err != triefs.ErrEmptyName {
+		t.Errorf("got %v, want %v", err, triefs.ErrEmptyName)
+	}
+}
+
+func TestMoveManyInto(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	newTrie := func(t *testing.T) *triefs.Trie {
+		t.Helper()
+		trie := triefs.NewTrie()
+		for _, d := range []*triefs.Entry{
+			triefs.NewEntry("/src/a.txt", "cid1", 1, triefs.MIMEOctetStream, now),
+			triefs.NewEntry("/src/b.txt", "cid2", 2, triefs.MIMEOctetStream, now),
+			triefs.NewEntry("/src/dir/c.txt", "cid3", 3, triefs.MIMEOctetStream, now),
+			triefs.NewEntry("/dst/b.txt", "cid4", 4, triefs.MIMEOctetStream, now),
+		} {
+			if _, err := trie.AddFile(d); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return trie
+	}
+
+	cases := []struct {
+		name    string
+		srcs    []string
+		destDir string
+		errs    []error
+	}{
+		{name: "collision", srcs: []string{"/src/a.txt", "/src/b.txt", "/src/dir/c.txt"}, destDir: "/dst", errs: []error{triefs.ErrConflict}},
+		{name: "missing and collision", srcs: []string{"/src/a.txt", "/src/missing", "/src/b.txt"}, destDir: "/dst", errs: []error{triefs.ErrFileNotExist, triefs.ErrConflict}},
+		{name: "into itself", srcs: []string{"/src/a.txt", "/src"}, destDir: "/src/dir", errs: []error{triefs.ErrInvalidMove}},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := newTrie(t)
+			events := 0
+			trie.OnChange(func(op triefs.Op, path string, c *triefs.Content) {
+				events++
+			})
+			expected := recursivePaths(trie, "/")
+
+			moved, err := trie.MoveManyInto(tc.srcs, tc.destDir)
+			if moved != nil {
+				t.Errorf("got %v, want nil", moved)
+			}
+			for _, want := range tc.errs {
+				if !errors.Is(err, want) {
+					t.Errorf("got %v, want %v", err, want)
+				}
+			}
+			if paths := recursivePaths(trie, "/"); !reflect.DeepEqual(paths, expected) {
+				t.Errorf("got %v, want %v", paths, expected)
+			}
+			if c, err := trie.File("/src/a.txt"); err != nil || c.CID != "cid1" {
+				t.Errorf("got %v %v, want cid1", c, err)
+			}
+			if events != 0 {
+				t.Errorf("got %v events, want none", events)
+			}
+		})
+	}
+
+	trie := newTrie(t)
+	moved, err := trie.MoveManyInto([]string{"/src/a.txt", "/src/dir"}, "/dst")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"/dst/a.txt", "/dst/dir"}; !reflect.DeepEqual(moved, want) {
+		t.Errorf("got %v, want %v", moved, want)
+	}
+	expected := []string{"/dst", "/dst/a.txt", "/dst/b.txt", "/dst/dir", "/dst/dir/c.txt", "/src", "/src/b.txt"}
+	if paths := recursivePaths(trie, "/"); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("got %v, want %v", paths, expected)
+	}
+	if _, err := trie.MoveManyInto([]string{"/src/b.txt"}, "/src/b.txt"); err != triefs.ErrNotADirectory {
+		t.Errorf("got %v, want %v", err, triefs.ErrNotADirectory)
+	}
+}
+
+func TestMoveGlob(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	build := func(t *testing.T) *triefs.Trie {
+		trie := triefs.NewTrie()
+		for _, p := range []string{"/a/x.txt", "/b/c/y.txt", "/b/keep.md", "/collected/z.txt", "/d/x.txt"} {
+			if _, err := trie.AddFile(triefs.NewEntry(p, "cid"+p, 1, triefs.MIMEOctetStream, now)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return trie
+	}
+
+	t.Run("unique", func(t *testing.T) {
+		t.Parallel()
+		trie := build(t)
+		moved, err := trie.MoveGlob("*.txt", "/collected", true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []string{"/collected/x.txt", "/collected/y.txt", "/collected/z.txt", "/collected/x (1).txt"}
+		if !reflect.DeepEqual(moved, expected) {
+			t.Errorf("got %v, want %v", moved, expected)
+		}
+		for _, p := range []string{"/a/x.txt", "/b/c/y.txt", "/d/x.txt"} {
+			if _, err := trie.Stat(p); err != triefs.ErrFileNotExist {
+				t.Errorf("%v: got %v, want %v", p, err, triefs.ErrFileNotExist)
+			}
+		}
+		if f, err := trie.File("/collected/x (1).txt"); err != nil || f.CID != "cid/d/x.txt" {
+			t.Errorf("got %v %v, want %v", f, err, "cid/d/x.txt")
+		}
+		if _, err := trie.Stat("/b/keep.md"); err != nil {
+			t.Errorf("got %v, want %v", err, nil)
+		}
+	})
+
+	t.Run("conflict rolls back", func(t *testing.T) {
+		t.Parallel()
+		trie := build(t)
+		want := recursivePaths(trie, "/")
+		epoch := trie.Epoch()
+		moved, err := trie.MoveGlob("*.txt", "/collected", false)
+		if !errors.Is(err, triefs.ErrConflict) || moved != nil {
+			t.Errorf("got %v %v, want %v", moved, err, triefs.ErrConflict)
+		}
+		if got := recursivePaths(trie, "/"); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if trie.Epoch() != epoch {
+			t.Errorf("got %v, want %v", trie.Epoch(), epoch)
+		}
+	})
+
+	t.Run("directories", func(t *testing.T) {
+		t.Parallel()
+		trie := build(t)
+		// /collected itself matches but is never moved, /b/c moves with /b
+		moved, err := trie.MoveGlob("/*", "/collected", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []string{"/collected/a", "/collected/b", "/collected/d"}
+		if !reflect.DeepEqual(moved, expected) {
+			t.Errorf("got %v, want %v", moved, expected)
+		}
+		if _, err := trie.Stat("/collected/b/c/y.txt"); err != nil {
+			t.Errorf("got %v, want %v", err, nil)
+		}
+	})
+
+	if _, err := triefs.NewTrie().MoveGlob("*", "/missing", false); err != triefs.ErrFileNotExist {
+		t.Errorf("got %v, want %v", err, triefs.ErrFileNotExist)
+	}
+}
+
+func TestRenameExtension(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	build := func(t *testing.T) *triefs.Trie {
+		trie := triefs.NewTrie()
+		for _, p := range []string{
+			"/photos/a.jpeg",
+			"/photos/a.jpg",
+			"/photos/2024/b.jpeg",
+			"/photos/2024/c.JPEG",
+			"/photos/2024/notes.txt",
+			"/photos/album.jpeg/d.png",
+			"/photos/shared.jpeg/e.jpeg",
+			"/other/f.jpeg",
+		} {
+			if _, err := trie.AddFile(triefs.NewEntry(p, "cid"+p, 1, triefs.MIMEOctetStream, now)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if _, err := trie.CreateRef("/photos/shared.jpeg", "bucket", now); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return trie
+	}
+
+	t.Run("unique", func(t *testing.T) {
+		t.Parallel()
+		trie := build(t)
+		n, err := trie.RenameExtension("/photos", "jpeg", ".jpg", true)
+		if err != nil || n != 2 {
+			t.Fatalf("got %v %v, want %v", n, err, 2)
+		}
+		expected := []string{
+			"/2024", "/2024/b.jpg", "/2024/c.JPEG", "/2024/notes.txt",
+			"/a (1).jpg", "/a.jpg", "/album.jpeg", "/album.jpeg/d.png", "/shared.jpeg",
+		}
+		if got := recursivePaths(trie, "/photos"); !reflect.DeepEqual(got, expected) {
+			t.Errorf("got %v, want %v", got, expected)
+		}
+		if f, err := trie.File("/photos/a (1).jpg"); err != nil || f.CID != "cid/photos/a.jpeg" {
+			t.Errorf("got %v %v, want %v", f, err, "cid/photos/a.jpeg")
+		}
+		if _, err := trie.File("/other/f.jpeg"); err != nil {
+			t.Errorf("got %v, want %v", err, nil)
+		}
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		t.Parallel()
+		trie := build(t)
+		before := trie.Paths()
+		n, err := trie.RenameExtension("/photos", "jpeg", "jpg", false)
+		if !errors.Is(err, triefs.ErrConflict) || n != 0 {
+			t.Errorf("got %v %v, want %v", n, err, triefs.ErrConflict)
+		}
+		if got := trie.Paths(); !reflect.DeepEqual(got, before) {
+			t.Errorf("got %v, want %v", got, before)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		t.Parallel()
+		trie := build(t)
+		cases := []struct {
+			path string
+			from string
+			to   string
+			err  error
+		}{
+			{path: "", from: "jpeg", to: "jpg", err: triefs.ErrEmptyPath},
+			{path: "/photos", from: ".", to: "jpg", err: triefs.ErrEmptyName},
+			{path: "/photos", from: "jpeg", to: "a/b", err: triefs.ErrIllegalNameChars},
+			{path: "/missing", from: "jpeg", to: "jpg", err: triefs.ErrFileNotExist},
+			{path: "/photos/a.jpg", from: "jpeg", to: "jpg", err: triefs.ErrNotADirectory},
+		}
+		for _, tc := range cases {
+			if _, err := trie.RenameExtension(tc.path, tc.from, tc.to, true); err != tc.err {
+				t.Errorf("%v %v %v: got %v, want %v", tc.path, tc.from, tc.to, err, tc.err)
+			}
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package triefs
+
+import "strings"
+
+// NamePolicy decides what happens to names ending with spaces or dots,
+// see Options.NamePolicy
+type NamePolicy int
+
+const (
+	// NameVerbatim keeps names as given, so "a ", "." and ".." are
+	// regular names
+	NameVerbatim NamePolicy = iota
+	// NameTrim trims trailing spaces and dots of every path segment with
+	// SanitizeName
+	NameTrim
+	// NameReject fails with ErrInvalidName when SanitizeName would change
+	// any path segment
+	NameReject
+)
+
+// SanitizeName trims trailing spaces and dots of name, which some file
+// systems drop silently. Returns ErrInvalidName if nothing is left, e.g.
+// for "  " or ".."
+func SanitizeName(name string) (string, error) {
+	res := strings.TrimRight(name, " .")
+	if len(res) == 0 {
+		return "", ErrInvalidName
+	}
+	return res, nil
+}
+
+// sanitizeName applies the trie name policy to name
+func (mt *Trie) sanitizeName(name string) (string, error) {
+	if mt.namePolicy == NameVerbatim {
+		return name, nil
+	}
+	res, err := SanitizeName(name)
+	if err != nil {
+		return "", err
+	}
+	if mt.namePolicy == NameReject && res != name {
+		return "", ErrInvalidName
+	}
+	return res, nil
+}
+
+// sanitizePath applies the trie name policy to every segment of cleaned path
+func (mt *Trie) sanitizePath(path string) (string, error) {
+	if mt.namePolicy == NameVerbatim || path == Separator {
+		return path, nil
+	}
+	segments := strings.Split(path[1:], Separator)
+	for i, s := range segments {
+		res, err := mt.sanitizeName(s)
+		if err != nil {
+			return "", err
+		}
+		segments[i] = res
+	}
+	return Separator + strings.Join(segments, Separator), nil
+}
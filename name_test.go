@@ -0,0 +1,101 @@
+package triefs_test
+
+import (
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestSanitizeName(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name     string
+		expected string
+		err      error
+	}{
+		{name: "folder3 ", expected: "folder3"},
+		{name: "file.txt. . ", expected: "file.txt"},
+		{name: " lead", expected: " lead"},
+		{name: ".hidden", expected: ".hidden"},
+		{name: "   ", err: triefs.ErrInvalidName},
+		{name: "..", err: triefs.ErrInvalidName},
+		{name: ".", err: triefs.ErrInvalidName},
+		{name: "", err: triefs.ErrInvalidName},
+	}
+
+	for _, tc := range cases {
+		got, err := triefs.SanitizeName(tc.name)
+		if got != tc.expected || err != tc.err {
+			t.Errorf("%q: got %q %v, want %q %v", tc.name, got, err, tc.expected, tc.err)
+		}
+	}
+}
+
+func TestNamePolicy(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	cases := []struct {
+		name     string
+		policy   triefs.NamePolicy
+		path     string
+		expected string
+		err      error
+	}{
+		{name: "verbatim trailing space", path: "/folder3 /file", expected: "/folder3 /file"},
+		{name: "verbatim space only", path: "/   /file", expected: "/   /file"},
+		{name: "verbatim dot dot", path: "/a/..", expected: "/a/.."},
+		{name: "trim trailing space", policy: triefs.NameTrim, path: "/folder3 /file. ", expected: "/folder3/file"},
+		{name: "trim space only", policy: triefs.NameTrim, path: "/   /file", err: triefs.ErrInvalidName},
+		{name: "trim dot dot", policy: triefs.NameTrim, path: "/a/..", err: triefs.ErrInvalidName},
+		{name: "reject trailing space", policy: triefs.NameReject, path: "/folder3 /file", err: triefs.ErrInvalidName},
+		{name: "reject space only", policy: triefs.NameReject, path: "/   /file", err: triefs.ErrInvalidName},
+		{name: "reject dot dot", policy: triefs.NameReject, path: "/a/..", err: triefs.ErrInvalidName},
+		{name: "reject clean", policy: triefs.NameReject, path: "/folder3/file", expected: "/folder3/file"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie, err := triefs.NewTrieWithOptions(triefs.Options{NamePolicy: tc.policy})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, err = trie.AddFile(triefs.NewEntry(tc.path, "cid", 1, triefs.MIMEOctetStream, now))
+			if err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if tc.err != nil {
+				if paths := trie.Paths(); len(paths) != 0 {
+					t.Errorf("got %v, want no paths", paths)
+				}
+				return
+			}
+			f, err := trie.File(tc.expected)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := tc.expected[len(tc.expected)-len(f.Name):]; f.Name != want {
+				t.Errorf("got %q, want %q", f.Name, want)
+			}
+		})
+	}
+
+	trie, err := triefs.NewTrieWithOptions(triefs.Options{NamePolicy: triefs.NameTrim})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/a/file", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, err := trie.Rename("/a/file", "renamed. "); got != "/a/renamed" || err != nil {
+		t.Errorf("got %v %v, want %v", got, err, "/a/renamed")
+	}
+	if err := trie.Move("/a/renamed", "/a/moved "); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.File("/a/moved"); err != nil {
+		t.Errorf("got %v, want %v", err, nil)
+	}
+}
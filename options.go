@@ -0,0 +1,226 @@
+package triefs
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Options configures a trie created by NewTrieWithOptions
+type Options struct {
+	// Separator splits paths into segments, "/" if zero. It can't be
+	// SpecialPathSymbol. Serialized forms (JSON, manifest, tar, binary)
+	// always use "/"
+	Separator rune
+	// StatCacheSize is the number of File and Stat results kept in a LRU
+	// cache, no cache is used if zero or negative. Mutations drop cached
+	// results of the changed path, its parents and its subtree
+	StatCacheSize int
+	// NoEmptyDirs makes directories implicit like in object stores. Adding
+	// an empty directory fails with ErrEmptyDirNotAllowed and removing the
+	// last entry of a directory removes the directory too
+	NoEmptyDirs bool
+	// KeepSorted keeps children of every node sorted on insert and removal,
+	// so the trie structure doesn't depend on insertion order
+	KeepSorted bool
+	// StrictFileAsDir makes adding an entry under an existing file fail with
+	// ErrNotADirectory instead of ErrConflict
+	StrictFileAsDir bool
+	// DeleteNonEmptyErrors makes Delete of a directory having entries fail
+	// with ErrDirNotEmpty instead of leaving it untouched
+	DeleteNonEmptyErrors bool
+	// IdempotentReAdd makes AddFile of a file equal to the existing one, see
+	// Content.Equal, succeed without changes instead of failing with
+	// ErrConflict, so retried uploads don't fail
+	IdempotentReAdd bool
+	// PreserveDestTime makes Upsert overwriting a file keep its CreatedAt
+	// instead of taking the one of the new content
+	PreserveDestTime bool
+	// Clock returns the time taken by entries added with zero CreatedAt,
+	// time.Now if nil. Explicit times are kept as given
+	Clock func() time.Time
+	// NamePolicy decides whether trailing spaces and dots of added names are
+	// kept, trimmed or rejected, NameVerbatim if zero
+	NamePolicy NamePolicy
+	// MaxChildren is the number of entries a directory can hold, as listed
+	// by Ls. Adding one more fails with ErrTooManyChildren, no limit if zero
+	// or negative
+	MaxChildren int
+	// AssignIDs gives every file added without Content.ID a new random one,
+	// see FileByID. IDs differ between tries, so tries built separately
+	// aren't Equal and don't share Hash
+	AssignIDs bool
+	// InternStrings makes nodes share equal path labels, names and types,
+	// e.g. directory names repeated across the trie, and stop keeping the
+	// added paths alive. It reduces memory retained by large tries, not
+	// allocations, adds allocate at least as much as without it and get
+	// slower. The pool only grows, strings of removed entries stay in it
+	InternStrings bool
+}
+
+// NewTrieWithOptions creates new instance of user's file system trie
+// configured by opts
+func NewTrieWithOptions(opts Options) (*Trie, error) {
+	sep := opts.Separator
+	if sep == 0 {
+		sep = SeparatorRune
+	}
+	if sep == rune(SpecialPathSymbol[0]) || !utf8.ValidRune(sep) || sep == utf8.RuneError {
+		return nil, ErrInvalidSeparator
+	}
+
+	mt := &Trie{
+		lock:                 sync.RWMutex{},
+		sep:                  sep,
+		cache:                newStatCache(opts.StatCacheSize),
+		noEmptyDirs:          opts.NoEmptyDirs,
+		keepSorted:           opts.KeepSorted,
+		strictFileAsDir:      opts.StrictFileAsDir,
+		deleteNonEmptyErrors: opts.DeleteNonEmptyErrors,
+		idempotentReAdd:      opts.IdempotentReAdd,
+		preserveDestTime:     opts.PreserveDestTime,
+		clock:                opts.Clock,
+		namePolicy:           opts.NamePolicy,
+		assignIDs:            opts.AssignIDs,
+		pool:                 newStringPool(opts.InternStrings),
+		maxChildren:          opts.MaxChildren,
+	}
+	mt.createdAt = mt.now().Unix()
+	return mt, nil
+}
+
+// Separator returns the path separator of the trie
+func (mt *Trie) Separator() rune {
+	if mt.sep == 0 {
+		return SeparatorRune
+	}
+	return mt.sep
+}
+
+// CleanPath is CleanPath using the trie separator
+func (mt *Trie) CleanPath(path string) string {
+	return mt.swap(CleanPath(mt.swap(path)))
+}
+
+// JoinPath is JoinPath using the trie separator
+func (mt *Trie) JoinPath(paths ...string) string {
+	return mt.CleanPath(strings.Join(paths, string(mt.Separator())))
+}
+
+// custom checks if the trie uses a separator other than "/"
+func (mt *Trie) custom() bool {
+	return mt.sep != 0 && mt.sep != SeparatorRune
+}
+
+// swap translates a path between the trie separator and the "/" used
+// internally. Both runes trade places, so names containing "/" are still
+// allowed with a custom separator and swap is its own inverse
+func (mt *Trie) swap(s string) string {
+	if !mt.custom() {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case mt.sep:
+			return SeparatorRune
+		case SeparatorRune:
+			return mt.sep
+		}
+		return r
+	}, s)
+}
+
+// swapContent returns a copy of c with Name, and symlink target, translated
+func (mt *Trie) swapContent(c *Content) *Content {
+	if !mt.custom() || c == nil {
+		return c
+	}
+	cnt := c.copy()
+	cnt.Name = mt.swap(cnt.Name)
+	if cnt.Type == MIMESymlink {
+		cnt.CID = mt.swap(cnt.CID)
+	}
+	return cnt
+}
+
+func (mt *Trie) swapContents(contents []*Content) []*Content {
+	if !mt.custom() {
+		return contents
+	}
+	res := make([]*Content, len(contents))
+	for i, c := range contents {
+		res[i] = mt.swapContent(c)
+	}
+	sortContents(res)
+	return res
+}
+
+// swapEntry returns a deep copy of e with paths and names translated
+func (mt *Trie) swapEntry(e *Entry) *Entry {
+	if !mt.custom() || e == nil {
+		return e
+	}
+	res := &Entry{Content: *mt.swapContent(&e.Content), Path: mt.swap(e.Path), Meta: e.Meta}
+	if e.Entries != nil {
+		res.Entries = make([]*Entry, len(e.Entries))
+		for i, me := range e.Entries {
+			res.Entries[i] = mt.swapEntry(me)
+		}
+	}
+	return res
+}
+
+// internalEntry translates an entry being added. NewEntry takes the name
+// from the path split by "/", so names of files are derived from the
+// translated path instead
+func (mt *Trie) internalEntry(e *Entry) *Entry {
+	m := mt.swapEntry(e)
+	if m != nil && m.Type != MIMEDriveEntry && len(m.Entries) == 0 {
+		m.Name = filepath.Base(CleanPath(m.Path))
+	}
+	return m
+}
+
+// swapEntries translates flat entries keeping them sorted by path
+func (mt *Trie) swapEntries(entries []*Entry) []*Entry {
+	if !mt.custom() {
+		return entries
+	}
+	res := make([]*Entry, len(entries))
+	for i, e := range entries {
+		res[i] = mt.swapEntry(e)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Path < res[j].Path
+	})
+	return res
+}
+
+// swapPaths translates paths keeping them sorted
+func (mt *Trie) swapPaths(paths []string) []string {
+	if !mt.custom() {
+		return paths
+	}
+	res := make([]string, len(paths))
+	for i, p := range paths {
+		res[i] = mt.swap(p)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// now returns the current time of the trie clock
+func (mt *Trie) now() time.Time {
+	if mt.clock != nil {
+		return mt.clock()
+	}
+	return time.Now()
+}
+
+// zeroTime checks if CreatedAt is unset, either zero or the zero time.Time
+func zeroTime(createdAt int64) bool {
+	return createdAt == 0 || createdAt == (time.Time{}).Unix()
+}
@@ -0,0 +1,713 @@
+package triefs_test
+
+import (
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func pipeTrie(t *testing.T) *triefs.Trie {
+	t.Helper()
+	trie, err := triefs.NewTrieWithOptions(triefs.Options{Separator: '|'})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Now()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("|photos|2024|beach.png", "cid1", 10, "image/png", now),
+		triefs.NewEntry("|photos|a/b.txt", "cid2", 20, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("|photos|empty", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("readme", "cid3", 30, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return trie
+}
+
+func TestNewTrieWithOptions(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name      string
+		separator rune
+		expected  rune
+		err       error
+	}{
+		{name: "default", separator: 0, expected: '/'},
+		{name: "slash", separator: '/', expected: '/'},
+		{name: "pipe", separator: '|', expected: '|'},
+		{name: "reserved marker", separator: ':', err: triefs.ErrInvalidSeparator},
+		{name: "invalid rune", separator: -1, err: triefs.ErrInvalidSeparator},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie, err := triefs.NewTrieWithOptions(triefs.Options{Separator: tc.separator})
+			if err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if err == nil && trie.Separator() != tc.expected {
+				t.Errorf("got %q, want %q", trie.Separator(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestCustomSeparatorPaths(t *testing.T) {
+	t.Parallel()
+	trie := pipeTrie(t)
+
+	expected := []string{
+		"|photos",
+		"|photos|2024",
+		"|photos|2024|beach.png",
+		"|photos|a/b.txt",
+		"|photos|empty",
+		"|readme",
+	}
+	if got := trie.Paths(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+
+	got := make([]string, 0)
+	for _, e := range trie.LsRecursive("|") {
+		got = append(got, e.Path)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+
+	got = got[:0]
+	for _, e := range trie.LsRecursive("|photos|") {
+		got = append(got, e.Path)
+	}
+	relative := []string{"|2024", "|2024|beach.png", "|a/b.txt", "|empty"}
+	if !reflect.DeepEqual(got, relative) {
+		t.Errorf("got %v, want %v", got, relative)
+	}
+
+	if got, want := trie.CleanPath("photos||2024|"), "|photos|2024"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := trie.JoinPath("|photos", "2024"), "|photos|2024"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCustomSeparatorQueries(t *testing.T) {
+	t.Parallel()
+	trie := pipeTrie(t)
+
+	names := make([]string, 0)
+	for _, c := range trie.Ls("|photos") {
+		names = append(names, c.Name)
+	}
+	if expected := []string{"2024", "a/b.txt", "empty"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("got %v, want %v", names, expected)
+	}
+
+	f, err := trie.File("|photos|a/b.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Name != "a/b.txt" || f.CID != "cid2" {
+		t.Errorf("got %v, want a/b.txt with cid2", f)
+	}
+
+	d, err := trie.Stat("|photos|2024")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Name != "2024" || !d.IsDirectory() {
+		t.Errorf("got %v, want directory 2024", d)
+	}
+
+	entries, err := trie.ReadDir("|photos|2024")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != "|photos|2024|beach.png" {
+		t.Errorf("got %v, want |photos|2024|beach.png", entries)
+	}
+
+	if _, err := trie.File("/photos/a/b.txt"); err != triefs.ErrFileNotExist {
+		t.Errorf("got %v, want %v", err, triefs.ErrFileNotExist)
+	}
+
+	walked := make([]string, 0)
+	err = trie.Walk("|photos|2024", func(path string, c *triefs.Content) error {
+		walked = append(walked, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"|photos|2024", "|photos|2024|beach.png"}; !reflect.DeepEqual(walked, expected) {
+		t.Errorf("got %v, want %v", walked, expected)
+	}
+}
+
+func TestCustomSeparatorMutations(t *testing.T) {
+	t.Parallel()
+	trie := pipeTrie(t)
+
+	_, err := trie.AddFile(triefs.NewEntry("|bad", "cid", 1, triefs.MIMEOctetStream, time.Now()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("|a:b", "cid", 1, triefs.MIMEOctetStream, time.Now())); err == nil {
+		t.Errorf("expected error for the reserved marker in path")
+	}
+	if _, err := trie.Rename("|bad", "a|b"); err != triefs.ErrIllegalNameChars {
+		t.Errorf("got %v, want %v", err, triefs.ErrIllegalNameChars)
+	}
+
+	newPath, err := trie.MoveInto("|photos|a/b.txt", "|photos|2024")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if newPath != "|photos|2024|a/b.txt" {
+		t.Errorf("got %v, want %v", newPath, "|photos|2024|a/b.txt")
+	}
+
+	newPath, err = trie.Rename("|photos|2024", "2025/old")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if newPath != "|photos|2025/old" {
+		t.Errorf("got %v, want %v", newPath, "|photos|2025/old")
+	}
+
+	if err := trie.Delete("|bad"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"|photos",
+		"|photos|2025/old",
+		"|photos|2025/old|a/b.txt",
+		"|photos|2025/old|beach.png",
+		"|photos|empty",
+		"|readme",
+	}
+	if got := trie.Paths(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+}
+
+func TestNoEmptyDirs(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie, err := triefs.NewTrieWithOptions(triefs.Options{NoEmptyDirs: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = trie.AddFile(triefs.NewEntry("/empty", "", 0, triefs.MIMEDriveEntry, now))
+	if err != triefs.ErrEmptyDirNotAllowed {
+		t.Errorf("got %v, want %v", err, triefs.ErrEmptyDirNotAllowed)
+	}
+	_, err = trie.AddFileUnique(triefs.NewEntry("/empty", "", 0, triefs.MIMEDriveEntry, now))
+	if err != triefs.ErrEmptyDirNotAllowed {
+		t.Errorf("got %v, want %v", err, triefs.ErrEmptyDirNotAllowed)
+	}
+
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/a/b/c/file", "cid1", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/a/other", "cid2", 2, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := trie.Delete("/a/b/c/file"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"/a", "/a/other"}; !reflect.DeepEqual(trie.Paths(), expected) {
+		t.Errorf("got %v, want %v", trie.Paths(), expected)
+	}
+
+	if err := trie.Delete("/a/other"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if paths := trie.Paths(); len(paths) != 0 {
+		t.Errorf("got %v, want empty trie", paths)
+	}
+
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/x/y/file", "cid3", 3, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/z/keep", "cid4", 4, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := trie.Move("/x/y/file", "/z/file"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"/z", "/z/file", "/z/keep"}; !reflect.DeepEqual(trie.Paths(), expected) {
+		t.Errorf("got %v, want %v", trie.Paths(), expected)
+	}
+	if err := trie.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func sortedChildren(e *triefs.Entry) bool {
+	for i, me := range e.Entries {
+		if i > 0 && e.Entries[i-1].Path >= me.Path {
+			return false
+		}
+		if !sortedChildren(me) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestKeepSorted(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	paths := []string{
+		"/a.txt", "/a/b.txt", "/a/a.txt", "/a/c/d", "/b", "/ba", "/bz/x", "/c.txt", "/中文/文件.txt",
+	}
+
+	hashes := make([]string, 0)
+	for _, order := range [][]int{{0, 1, 2, 3, 4, 5, 6, 7, 8}, {8, 7, 6, 5, 4, 3, 2, 1, 0}, {4, 0, 8, 2, 6, 1, 5, 3, 7}} {
+		trie, err := triefs.NewTrieWithOptions(triefs.Options{KeepSorted: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, i := range order {
+			if _, err := trie.AddFile(triefs.NewEntry(paths[i], "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if !sortedChildren(trie.Root) {
+			t.Errorf("children aren't sorted for insert order %v", order)
+		}
+
+		names := make([]string, 0)
+		for _, c := range trie.Ls("/") {
+			names = append(names, c.Name)
+		}
+		if expected := []string{"a", "a.txt", "b", "ba", "bz", "c.txt", "中文"}; !reflect.DeepEqual(names, expected) {
+			t.Errorf("got %v, want %v", names, expected)
+		}
+
+		hash, err := trie.Hash()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		hashes = append(hashes, hash)
+
+		for _, p := range []string{"/ba", "/a/a.txt"} {
+			if err := trie.Delete(p); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !sortedChildren(trie.Root) {
+				t.Errorf("children aren't sorted after deleting %v", p)
+			}
+		}
+		if err := trie.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	for _, hash := range hashes[1:] {
+		if hash != hashes[0] {
+			t.Errorf("got %v, want %v", hash, hashes[0])
+		}
+	}
+}
+
+func TestFuzzyKeepSorted(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	segments := []string{"a", "b", "ab", "ba", "a.txt", "e"}
+	randPath := func() string {
+		p := ""
+		for i := r.Intn(3); i >= 0; i-- {
+			p += "/" + segments[r.Intn(len(segments))]
+		}
+		return p
+	}
+
+	for i := 0; i < 100; i++ {
+		trie, err := triefs.NewTrieWithOptions(triefs.Options{KeepSorted: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for j := 0; j < 50; j++ {
+			p := randPath()
+			switch r.Intn(3) {
+			case 0:
+				_, _ = trie.AddFile(triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now))
+			case 1:
+				_, _ = trie.AddFile(triefs.NewEntry(p, "", 0, triefs.MIMEDriveEntry, now))
+			default:
+				_ = trie.Delete(p)
+			}
+			if trie.Root != nil && !sortedChildren(trie.Root) {
+				t.Fatalf("children aren't sorted after changing %v", p)
+			}
+		}
+	}
+}
+
+func TestStrictFileAsDir(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	cases := []struct {
+		name   string
+		strict bool
+		err    error
+	}{
+		{name: "permissive", err: triefs.ErrConflict},
+		{name: "strict", strict: true, err: triefs.ErrNotADirectory},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie, err := triefs.NewTrieWithOptions(triefs.Options{StrictFileAsDir: tc.strict})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := trie.AddFile(triefs.NewEntry("/a/file", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, e := range []*triefs.Entry{
+				triefs.NewEntry("/a/file/child", "cid", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/a/file/b/c", "cid", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/a/file/dir", "", 0, triefs.MIMEDriveEntry, now),
+			} {
+				if _, err := trie.AddFile(e); err != tc.err {
+					t.Errorf("%v: got %v, want %v", e.Path, err, tc.err)
+				}
+			}
+			if _, err := trie.AddFile(triefs.NewEntry("/a/file2/child", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteNonEmptyErrors(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	entries := []*triefs.Entry{
+		triefs.NewEntry("/aaa/fbb/f", "cid1", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/file", "cid2", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/fiee/file", "cid3", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/empty", "", 0, triefs.MIMEDriveEntry, now),
+	}
+	cases := []struct {
+		name   string
+		errors bool
+		err    error
+	}{
+		{name: "no-op", err: nil},
+		{name: "errors", errors: true, err: triefs.ErrDirNotEmpty},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie, err := triefs.NewTrieWithOptions(triefs.Options{DeleteNonEmptyErrors: tc.errors})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, e := range entries {
+				if _, err := trie.AddFile(e); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			want := recursivePaths(trie, "/")
+
+			for _, p := range []string{"/aaa", "/aaa/fbb", "/"} {
+				if err := trie.Delete(p); err != tc.err {
+					t.Errorf("%v: got %v, want %v", p, err, tc.err)
+				}
+			}
+			if got := recursivePaths(trie, "/"); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+
+			// files and empty folders are deleted either way
+			for _, p := range []string{"/aaa/empty", "/aaa/fbb/f", "/missing"} {
+				if err := trie.Delete(p); err != nil {
+					t.Errorf("%v: unexpected error: %v", p, err)
+				}
+			}
+
+			if err := trie.DeleteAll("/aaa"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got, want := recursivePaths(trie, "/"), []string{"/file"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if err := trie.DeleteAll("/"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := trie.LsRecursive("/"); len(got) != 0 {
+				t.Errorf("got %v, want empty trie", got)
+			}
+		})
+	}
+}
+
+func TestIdempotentReAdd(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	cases := []struct {
+		name       string
+		idempotent bool
+		err        error
+	}{
+		{name: "conflict", err: triefs.ErrConflict},
+		{name: "idempotent", idempotent: true, err: nil},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie, err := triefs.NewTrieWithOptions(triefs.Options{IdempotentReAdd: tc.idempotent})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := trie.AddFile(triefs.NewEntry("/folder1/myfile", "test_cid", 512, triefs.MIMEOctetStream, now)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			events := 0
+			trie.OnChange(func(op triefs.Op, path string, c *triefs.Content) {
+				events++
+			})
+			epoch := trie.Epoch()
+
+			// the retry only differs in CreatedAt which isn't compared
+			added, err := trie.AddFile(triefs.NewEntry("/folder1/myfile", "test_cid", 512, triefs.MIMEOctetStream, now.Add(time.Hour)))
+			if err != tc.err {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			if (err == nil && len(added) != 0) || events != 0 || trie.Epoch() != epoch {
+				t.Errorf("got %v added, %v events, epoch %v, want no changes", added, events, trie.Epoch())
+			}
+			c, err := trie.Stat("/folder1/myfile")
+			if err != nil || c.CreatedAt != now.Unix() {
+				t.Errorf("got %v %v, want CreatedAt %v", c, err, now.Unix())
+			}
+
+			// different contents always conflict
+			for _, e := range []*triefs.Entry{
+				triefs.NewEntry("/folder1/myfile", "other_cid", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/folder1/myfile", "test_cid", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/folder1", "test_cid", 512, triefs.MIMEOctetStream, now),
+			} {
+				if _, err := trie.AddFile(e); err != triefs.ErrConflict {
+					t.Errorf("%v: got %v, want %v", e.Path, err, triefs.ErrConflict)
+				}
+			}
+		})
+	}
+}
+
+func TestPreserveDestTime(t *testing.T) {
+	t.Parallel()
+	created := time.Unix(1000, 0)
+	updated := time.Unix(2000, 0)
+	cases := []struct {
+		name     string
+		preserve bool
+		expected int64
+	}{
+		{name: "source time", expected: updated.Unix()},
+		{name: "destination time", preserve: true, expected: created.Unix()},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie, err := triefs.NewTrieWithOptions(triefs.Options{PreserveDestTime: tc.preserve})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := trie.AddFile(triefs.NewEntry("/a/file", "cid1", 1, triefs.MIMEOctetStream, created)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			c := triefs.NewContent("file", "cid2", 2, triefs.MIMEOctetStream, updated)
+			createdNew, err := trie.Upsert("/a/file", &c)
+			if err != nil || createdNew {
+				t.Fatalf("got %v %v, want overwrite", createdNew, err)
+			}
+			f, err := trie.File("/a/file")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if f.CreatedAt != tc.expected || f.CID != "cid2" {
+				t.Errorf("got %v %v, want %v %v", f.CreatedAt, f.CID, tc.expected, "cid2")
+			}
+
+			// new files always take the given time
+			if _, err := trie.Upsert("/a/new", &c); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if f, _ := trie.File("/a/new"); f == nil || f.CreatedAt != updated.Unix() {
+				t.Errorf("got %v, want %v", f, updated.Unix())
+			}
+		})
+	}
+}
+
+func TestClock(t *testing.T) {
+	t.Parallel()
+	fixed := time.Unix(5000, 0)
+	explicit := time.Unix(1000, 0)
+	trie, err := triefs.NewTrieWithOptions(triefs.Options{Clock: func() time.Time { return fixed }})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		change   func() error
+		path     string
+		expected int64
+	}{
+		{
+			name: "zero time",
+			change: func() error {
+				_, err := trie.AddFile(triefs.NewEntry("/a/zero", "cid", 1, triefs.MIMEOctetStream, time.Time{}))
+				return err
+			},
+			path:     "/a/zero",
+			expected: fixed.Unix(),
+		},
+		{
+			name: "explicit time",
+			change: func() error {
+				_, err := trie.AddFile(triefs.NewEntry("/a/explicit", "cid", 1, triefs.MIMEOctetStream, explicit))
+				return err
+			},
+			path:     "/a/explicit",
+			expected: explicit.Unix(),
+		},
+		{
+			name: "upsert unset time",
+			change: func() error {
+				_, err := trie.Upsert("/a/upsert", &triefs.Content{CID: "cid", Size: 1, Type: triefs.MIMEOctetStream})
+				return err
+			},
+			path:     "/a/upsert",
+			expected: fixed.Unix(),
+		},
+		{
+			name: "replace unset time",
+			change: func() error {
+				_, _, err := trie.Replace("/a/explicit", &triefs.Content{CID: "cid2", Size: 2})
+				return err
+			},
+			path:     "/a/explicit",
+			expected: fixed.Unix(),
+		},
+		{
+			name: "ref zero time",
+			change: func() error {
+				_, err := trie.CreateRef("/a", "bucket", time.Time{})
+				return err
+			},
+			path:     "/a",
+			expected: fixed.Unix(),
+		},
+	}
+
+	for _, tc := range cases {
+		if err := tc.change(); err != nil {
+			t.Fatalf("%v: unexpected error: %v", tc.name, err)
+		}
+		f, err := trie.File(tc.path)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tc.name, err)
+		}
+		if f.CreatedAt != tc.expected {
+			t.Errorf("%v: got %v, want %v", tc.name, f.CreatedAt, tc.expected)
+		}
+	}
+}
+
+func TestMaxChildren(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie, err := triefs.NewTrieWithOptions(triefs.Options{MaxChildren: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, p := range []string{"/a/file1", "/a/file2", "/a/sub/file", "/a/sub/other", "/b/file"} {
+		if _, err := trie.AddFile(triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+			t.Fatalf("%v: unexpected error: %v", p, err)
+		}
+	}
+
+	cases := []struct {
+		name  string
+		entry *triefs.Entry
+		err   error
+	}{
+		{name: "file over the limit", entry: triefs.NewEntry("/a/file3", "cid", 1, triefs.MIMEOctetStream, now), err: triefs.ErrTooManyChildren},
+		{name: "new directory over the limit", entry: triefs.NewEntry("/a/new/deep/file", "cid", 1, triefs.MIMEOctetStream, now), err: triefs.ErrTooManyChildren},
+		{name: "empty directory over the limit", entry: triefs.NewEntry("/a/empty", "", 0, triefs.MIMEDriveEntry, now), err: triefs.ErrTooManyChildren},
+		{name: "existing subdirectory", entry: triefs.NewEntry("/a/sub/file3", "cid", 1, triefs.MIMEOctetStream, now)},
+		{name: "sibling directory", entry: triefs.NewEntry("/b/file2", "cid", 1, triefs.MIMEOctetStream, now)},
+		{name: "root", entry: triefs.NewEntry("/c/file", "cid", 1, triefs.MIMEOctetStream, now)},
+		{name: "root over the limit", entry: triefs.NewEntry("/d/file", "cid", 1, triefs.MIMEOctetStream, now), err: triefs.ErrTooManyChildren},
+	}
+
+	for _, tc := range cases {
+		if _, err := trie.AddFile(tc.entry); err != tc.err {
+			t.Errorf("%v: got %v, want %v", tc.name, err, tc.err)
+		}
+	}
+	if got := len(trie.Ls("/a")); got != 3 {
+		t.Errorf("got %v, want %v", got, 3)
+	}
+
+	// a removed entry frees its slot
+	if err := trie.Delete("/a/file1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/a/file3", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Errorf("got %v, want %v", err, nil)
+	}
+
+	// renames within a full directory keep the number of its entries
+	for _, p := range []string{"/a/file2", "/a/sub"} {
+		if _, err := trie.Rename(p, "renamed"+filepath.Base(p)); err != nil {
+			t.Errorf("%v: got %v, want %v", p, err, nil)
+		}
+	}
+	if err := trie.Move("/b/file", "/a/file4"); err != triefs.ErrTooManyChildren {
+		t.Errorf("got %v, want %v", err, triefs.ErrTooManyChildren)
+	}
+	if got := len(trie.Ls("/a")); got != 3 {
+		t.Errorf("got %v, want %v", got, 3)
+	}
+	if got := len(trie.Ls("/a/renamedsub")); got != 3 {
+		t.Errorf("got %v, want %v", got, 3)
+	}
+}
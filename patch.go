@@ -0,0 +1,130 @@
+package triefs
+
+import "time"
+
+// TriePatch lists changes turning one trie into another, see Patch. Paths
+// are absolute and use "/" like the manifest, so a patch can be sent as JSON
+type TriePatch struct {
+	// Adds are new entries in path order, directories come before their
+	// entries
+	Adds []ManifestEntry `json:"adds,omitempty"`
+	// Removes are paths of removed entries, descendants before their
+	// directories
+	Removes []string `json:"removes,omitempty"`
+	// Changes are files with different content, replaced as a whole
+	Changes []ManifestEntry `json:"changes,omitempty"`
+}
+
+// Patch returns changes turning from into this trie. An entry changing
+// between file and directory is removed and added again. Directories are
+// compared by presence only. A nil from is treated as an empty trie
+func (mt *Trie) Patch(from *Trie) (*TriePatch, error) {
+	// tries are locked one at a time like in Equal
+	var old []*Entry
+	if from != nil {
+		old = from.entries()
+	}
+	cur := mt.entries()
+
+	p := &TriePatch{}
+	i, j := 0, 0
+	for i < len(old) || j < len(cur) {
+		switch {
+		case j == len(cur) || i < len(old) && old[i].Path < cur[j].Path:
+			p.Removes = append(p.Removes, old[i].Path)
+			i++
+		case i == len(old) || cur[j].Path < old[i].Path:
+			p.Adds = append(p.Adds, manifestEntry(cur[j]))
+			j++
+		default:
+			o, c := old[i], cur[j]
+			i++
+			j++
+			if o.IsDirectory() != c.IsDirectory() {
+				p.Removes = append(p.Removes, o.Path)
+				p.Adds = append(p.Adds, manifestEntry(c))
+				continue
+			}
+			if !c.IsDirectory() && !EqualContent(&o.Content, &c.Content) {
+				p.Changes = append(p.Changes, manifestEntry(c))
+			}
+		}
+	}
+
+	// children sort after their directory
+	for l, r := 0, len(p.Removes)-1; l < r; l, r = l+1, r-1 {
+		p.Removes[l], p.Removes[r] = p.Removes[r], p.Removes[l]
+	}
+	return p, nil
+}
+
+// ApplyPatch applies p made by Patch under a single lock. Removes go first,
+// then adds and changes. Removing a missing path is ignored like by Delete,
+// while an add meeting an existing entry returns ErrConflict and a change of
+// a missing file ErrFileNotExist. Nothing changes if any step fails
+func (mt *Trie) ApplyPatch(p *TriePatch) error {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if p == nil {
+		return nil
+	}
+
+	rollback := mt.savepoint()
+	for _, path := range p.Removes {
+		path = CleanPath(path)
+		old := mt.content(path)
+		if old == nil {
+			continue
+		}
+		mt.remove(path)
+		mt.notify(OpRemove, path, old)
+	}
+
+	for _, me := range p.Adds {
+		var e *Entry
+		if me.Type == MIMEDriveDirectory {
+			if mt.noEmptyDirs {
+				continue
+			}
+			e = NewEntry(me.Path, "", 0, MIMEDriveEntry, time.Unix(me.CreatedAt, 0))
+		} else {
+			e = &Entry{Path: me.Path, Content: me.content()}
+		}
+		if mt.Root != nil && stat(CleanPath(e.Path), mt.Root) != nil {
+			rollback()
+			return ErrConflict
+		}
+		_, err := mt.addFile(e)
+		if err != nil {
+			rollback()
+			return err
+		}
+		mt.notify(OpCreate, e.Path, nil)
+	}
+
+	for _, me := range p.Changes {
+		path := CleanPath(me.Path)
+		var f *Content
+		if mt.Root != nil {
+			f = find(path, mt.Root)
+		}
+		if f == nil || f.IsDirectory() {
+			rollback()
+			return ErrFileNotExist
+		}
+		c := me.content()
+		delta := usage(&c) - usage(f)
+		err := mt.checkQuota(delta)
+		if err != nil {
+			rollback()
+			return err
+		}
+		mt.cache.invalidate(path)
+		mt.epoch++
+		mt.used += delta
+		*f = *c.copy()
+		mt.notify(OpUpdate, path, nil)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package triefs_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func cloneTrie(t *testing.T, trie *triefs.Trie) *triefs.Trie {
+	t.Helper()
+	clone, err := trie.Subtree("/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return clone
+}
+
+func TestPatch(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	a := tarTrie(t)
+	b := cloneTrie(t, a)
+	if err := b.DeleteAll("/docs/sub"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.Delete("/docs/link"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/docs/link/file", "cid5", 5, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/new/deep/file", "cid6", 6, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/new/empty", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := b.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, _, err := b.Replace("/docs/readme.txt", &triefs.Content{CID: "cid7", Size: 7, CreatedAt: now.Unix()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	patch, err := b.Patch(a)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"/docs/sub/big.bin", "/docs/sub", "/docs/link"}
+	if !reflect.DeepEqual(patch.Removes, expected) {
+		t.Errorf("got %v, want %v", patch.Removes, expected)
+	}
+	if len(patch.Changes) != 1 || patch.Changes[0].Path != "/docs/readme.txt" {
+		t.Errorf("got %v, want a change of %v", patch.Changes, "/docs/readme.txt")
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded triefs.TriePatch
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res := cloneTrie(t, a)
+	if err := res.ApplyPatch(&decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !triefs.Equal(res, b) {
+		t.Errorf("got %v, want %v", res.Paths(), b.Paths())
+	}
+	if err := res.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// an equal trie needs no changes
+	empty, err := b.Patch(res)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(empty, &triefs.TriePatch{}) {
+		t.Errorf("got %v, want an empty patch", empty)
+	}
+
+	// applying twice fails leaving the trie untouched
+	if err := res.ApplyPatch(patch); err != triefs.ErrConflict {
+		t.Errorf("got %v, want %v", err, triefs.ErrConflict)
+	}
+	if !triefs.Equal(res, b) {
+		t.Errorf("got %v, want %v", res.Paths(), b.Paths())
+	}
+}
@@ -0,0 +1,35 @@
+package triefs
+
+// SetQuota limits the total Size of files, the DiskUsage of root, to
+// maxBytes. AddFile, Replace, Upsert and the other insert methods fail with
+// ErrQuotaExceeded when a change would grow the total over it, changes which
+// don't grow it are always allowed. Zero or negative means unlimited. The
+// total is kept up to date by the trie methods, so Root must not be changed
+// directly
+func (mt *Trie) SetQuota(maxBytes int64) {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	mt.quota = maxBytes
+}
+
+// checkQuota returns ErrQuotaExceeded if growing the total size by delta
+// goes over the quota.
+// Callers must hold at least a read lock.
+func (mt *Trie) checkQuota(delta int64) error {
+	if mt.quota == 0 || delta <= 0 || mt.used+delta <= mt.quota {
+		return nil
+	}
+	return ErrQuotaExceeded
+}
+
+// usage is the size c takes, references and directories take none
+func usage(c *Content) int64 {
+	if c.IsDirectory() || c.Type == MIMEDriveEntry || c.Type == MIMEReference {
+		return 0
+	}
+	return c.Size
+}
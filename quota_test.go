@@ -0,0 +1,220 @@
+package triefs_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	trie.SetQuota(100)
+
+	for i, size := range []int64{40, 50, 10} {
+		e := triefs.NewEntry(fmt.Sprintf("/dir/file%d", i), "cid", size, triefs.MIMEOctetStream, now)
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("%v: unexpected error: %v", size, err)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		change func() error
+		err    error
+	}{
+		{
+			name: "add over the cap",
+			change: func() error {
+				_, err := trie.AddFile(triefs.NewEntry("/dir/over", "cid", 1, triefs.MIMEOctetStream, now))
+				return err
+			},
+			err: triefs.ErrQuotaExceeded,
+		},
+		{
+			name: "empty file at the cap",
+			change: func() error {
+				_, err := trie.AddFile(triefs.NewEntry("/dir/empty", "cid", 0, triefs.MIMEOctetStream, now))
+				return err
+			},
+		},
+		{
+			name: "larger replace",
+			change: func() error {
+				_, _, err := trie.Replace("/dir/file0", &triefs.Content{CID: "cid", Size: 41})
+				return err
+			},
+			err: triefs.ErrQuotaExceeded,
+		},
+		{
+			name: "smaller replace",
+			change: func() error {
+				_, _, err := trie.Replace("/dir/file0", &triefs.Content{CID: "cid", Size: 30})
+				return err
+			},
+		},
+		{
+			name: "larger upsert that fits",
+			change: func() error {
+				_, err := trie.Upsert("/dir/file2", &triefs.Content{CID: "cid", Size: 20})
+				return err
+			},
+		},
+		{
+			name: "upsert over the cap",
+			change: func() error {
+				_, err := trie.Upsert("/dir/file2", &triefs.Content{CID: "cid", Size: 21})
+				return err
+			},
+			err: triefs.ErrQuotaExceeded,
+		},
+		{
+			name: "add after delete",
+			change: func() error {
+				if err := trie.Delete("/dir/file1"); err != nil {
+					return err
+				}
+				_, err := trie.AddFile(triefs.NewEntry("/other/file", "cid", 50, triefs.MIMEOctetStream, now))
+				return err
+			},
+		},
+		{
+			name:   "move at the cap",
+			change: func() error { return trie.Move("/other/file", "/dir/moved") },
+		},
+		{
+			name: "rename directory at the cap",
+			change: func() error {
+				_, err := trie.Rename("/dir", "renamed")
+				return err
+			},
+		},
+		{
+			name: "move back at the cap",
+			change: func() error {
+				_, err := trie.MoveInto("/renamed/moved", "/other")
+				return err
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		if err := tc.change(); err != tc.err {
+			t.Fatalf("%v: got %v, want %v", tc.name, err, tc.err)
+		}
+	}
+	if size, _ := trie.DiskUsage("/"); size != 100 {
+		t.Errorf("got %v, want %v", size, 100)
+	}
+
+	// decoded tries count their files too
+	data, err := trie.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded := triefs.NewTrie()
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded.SetQuota(100)
+	if _, err := loaded.AddFile(triefs.NewEntry("/over", "cid", 1, triefs.MIMEOctetStream, now)); err != triefs.ErrQuotaExceeded {
+		t.Errorf("got %v, want %v", err, triefs.ErrQuotaExceeded)
+	}
+
+	trie.SetQuota(0)
+	if _, err := trie.AddFile(triefs.NewEntry("/big", "cid", 1<<40, triefs.MIMEOctetStream, now)); err != nil {
+		t.Errorf("got %v, want %v", err, nil)
+	}
+}
+
+func TestQuotaTracksDiskUsage(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	names := []string{"a", "b", "ab"}
+	rnd := rand.New(rand.NewSource(885))
+	randomPath := func() string {
+		p := ""
+		for i := 0; i <= rnd.Intn(3); i++ {
+			p += "/" + names[rnd.Intn(len(names))]
+		}
+		return p
+	}
+
+	trie := triefs.NewTrie()
+	for i := 0; i < 300; i++ {
+		p := randomPath()
+		switch rnd.Intn(7) {
+		case 0:
+			_ = trie.Delete(p)
+		case 1:
+			_ = trie.DeleteAll(p)
+		case 2:
+			_, _, _ = trie.Replace(p, &triefs.Content{CID: "cid", Size: rnd.Int63n(100)})
+		case 3:
+			_ = trie.Move(p, randomPath())
+		case 4:
+			_, _ = trie.CreateRef(p, "bucket", now)
+		case 5:
+			types := []string{triefs.MIMEOctetStream, triefs.MIMEReference, ""}
+			_, _ = trie.Upsert(p, &triefs.Content{CID: "cid", Size: rnd.Int63n(100), Type: types[rnd.Intn(len(types))]})
+		default:
+			_, _ = trie.AddFile(triefs.NewEntry(p, "cid", rnd.Int63n(100), triefs.MIMEOctetStream, now))
+		}
+
+		// one more byte fits the quota, the next one doesn't
+		size, _ := trie.DiskUsage("/")
+		trie.SetQuota(size + 1)
+		if _, err := trie.AddFile(triefs.NewEntry("/probe1", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+			t.Fatalf("step %v: got %v, want %v at usage %v", i, err, nil, size)
+		}
+		if _, err := trie.AddFile(triefs.NewEntry("/probe2", "cid", 1, triefs.MIMEOctetStream, now)); err != triefs.ErrQuotaExceeded {
+			t.Fatalf("step %v: got %v, want %v at usage %v", i, err, triefs.ErrQuotaExceeded, size)
+		}
+		_ = trie.Delete("/probe1")
+		trie.SetQuota(0)
+		if err := trie.Validate(); err != nil {
+			t.Fatalf("step %v: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestQuotaUpsertChangesType(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	if _, err := trie.AddFile(triefs.NewEntry("/ref/file", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.CreateRef("/ref", "bucket", now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	trie.SetQuota(10)
+
+	// a reference takes no space, the file replacing it does
+	big := &triefs.Content{CID: "cid", Size: 1000, Type: triefs.MIMEOctetStream}
+	if _, err := trie.Upsert("/ref", big); err != triefs.ErrQuotaExceeded {
+		t.Errorf("got %v, want %v", err, triefs.ErrQuotaExceeded)
+	}
+	if size, _ := trie.DiskUsage("/"); size != 0 {
+		t.Errorf("got %v, want %v", size, 0)
+	}
+
+	if _, err := trie.AddFile(triefs.NewEntry("/file", "cid", 8, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the file turned into a reference frees its space
+	ref := &triefs.Content{CID: "bucket", Size: 8, Type: triefs.MIMEReference}
+	if _, err := trie.Upsert("/file", ref); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/other", "cid", 10, triefs.MIMEOctetStream, now)); err != nil {
+		t.Errorf("got %v, want %v", err, nil)
+	}
+	if err := trie.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package triefs
+
+import (
+	"sort"
+	"strings"
+)
+
+// Render draws the directory at path like the tree command does, the first
+// line is the cleaned path followed by a line per entry below it. Children
+// are ordered by Name with directories first. References are annotated with
+// their bucket ID, e.g. "└── photos → bucket"
+func (mt *Trie) Render(path string) string {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	p := CleanPath(mt.swap(path))
+	if len(p) == 0 {
+		p = Separator
+	}
+	var b strings.Builder
+	b.WriteString(mt.swap(p))
+	b.WriteString("\n")
+	if mt.Root != nil {
+		mt.render(&b, p, "")
+	}
+	return b.String()
+}
+
+// render writes children of path prefixing every line with indent.
+// Callers must hold at least a read lock.
+func (mt *Trie) render(b *strings.Builder, path string, indent string) {
+	contents := list(path, mt.Root)
+	sort.SliceStable(contents, func(i, j int) bool {
+		if contents[i].IsDirectory() != contents[j].IsDirectory() {
+			return contents[i].IsDirectory()
+		}
+		return contents[i].Name < contents[j].Name
+	})
+
+	for i, c := range contents {
+		branch, next := "├── ", "│   "
+		if i == len(contents)-1 {
+			branch, next = "└── ", "    "
+		}
+		b.WriteString(indent + branch + c.Name)
+		if c.Type == MIMEReference {
+			b.WriteString(" → " + c.CID)
+		}
+		b.WriteString("\n")
+		if c.IsDirectory() {
+			mt.render(b, JoinPath(path, c.Name), indent+next)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package triefs_test
+
+import (
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/aaa", "test_cid", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/aaa/bbb/file1.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/bba/file2.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/bbb/aaa/file1.txt", "test_cid", 0, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := `/
+├── aaa
+│   ├── bba
+│   │   └── file2.txt
+│   └── bbb
+│       └── file1.txt
+└── bbb
+    └── aaa
+        └── file1.txt
+`
+	if got := triefs.NewTrie().Render("/"); got != "/\n" {
+		t.Errorf("got %q, want %q", got, "/\n")
+	}
+	if got := trie.Render("/"); got != expected {
+		t.Errorf("got\n%v\nwant\n%v", got, expected)
+	}
+
+	if _, err := trie.CreateRef("/bbb/aaa", "bucket", now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/bbb/ccc", "test_cid", 0, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/bbb/zzz/file", "test_cid", 0, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = `/bbb
+├── zzz
+│   └── file
+├── aaa → bucket
+└── ccc
+`
+	if got := trie.Render("/bbb/"); got != expected {
+		t.Errorf("got\n%v\nwant\n%v", got, expected)
+	}
+}
@@ -0,0 +1,207 @@
+package triefs
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// SearchResult describes a single entry matched by Search
+type SearchResult struct {
+	Path    string   `json:"path"`
+	Content *Content `json:"content"`
+}
+
+// Search looks up all entries whose name contains substr anywhere in the trie.
+// Results are returned in sorted path order, empty substr matches everything.
+// In case-insensitive mode names are compared using Unicode simple folding
+func (mt *Trie) Search(substr string, caseInsensitive bool) []*SearchResult {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	res := make([]*SearchResult, 0)
+	for _, e := range mt.swapEntries(mt.lsRecursive(Separator)) {
+		if !matchName(e.Name, substr, caseInsensitive) {
+			continue
+		}
+		res = append(res, &SearchResult{Path: e.Path, Content: &e.Content})
+	}
+	return res
+}
+
+// Glob returns sorted absolute paths of entries matching pattern, see
+// filepath.Match for the syntax. Pattern without separator is matched
+// against names, so "*.txt" finds text files in every directory, otherwise
+// against the whole path where "*" doesn't cross separators, e.g. "/a/*/b"
+func (mt *Trie) Glob(pattern string) ([]string, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	paths, err := mt.glob(mt.swap(pattern))
+	if err != nil {
+		return nil, err
+	}
+	return mt.swapPaths(paths), nil
+}
+
+// glob is the lock-free core of Glob.
+// Callers must hold at least a read lock.
+func (mt *Trie) glob(pattern string) ([]string, error) {
+	_, err := filepath.Match(pattern, "")
+	if err != nil {
+		return nil, err
+	}
+
+	byName := !strings.Contains(pattern, Separator)
+	if !byName {
+		pattern = CleanPath(pattern)
+	}
+	res := make([]string, 0)
+	if mt.Root == nil {
+		return res, nil
+	}
+	for _, e := range mt.lsRecursive(Separator) {
+		name := e.Path
+		if byName {
+			name = filepath.Base(e.Path)
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			res = append(res, e.Path)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func matchName(name, substr string, caseInsensitive bool) bool {
+	if !caseInsensitive {
+		return strings.Contains(name, substr)
+	}
+	return containsFold(name, substr)
+}
+
+// containsFold reports whether substr is within s under Unicode simple folding.
+// Every rune-aligned window of s is compared, since folded forms may
+// differ in byte length.
+func containsFold(s, substr string) bool {
+	n := utf8.RuneCountInString(substr)
+	if n == 0 {
+		return true
+	}
+	for i := 0; i < len(s); {
+		j, cnt := i, 0
+		for j < len(s) && cnt < n {
+			_, size := utf8.DecodeRuneInString(s[j:])
+			j += size
+			cnt++
+		}
+		if cnt < n {
+			return false
+		}
+		if strings.EqualFold(s[i:j], substr) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return false
+}
+
+// Complete returns sorted names of the children of dir that start with prefix.
+// Only branches of the trie that can match the prefix are visited
+func (mt *Trie) Complete(dir, prefix string) []string {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	names := make([]string, 0)
+	prefix = mt.swap(prefix)
+	if mt.Root == nil || strings.Contains(prefix, Separator) {
+		return names
+	}
+
+	p := CleanPath(mt.swap(dir))
+	if len(p) == 0 {
+		p = Separator
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range complete(p, prefix, mt.Root) {
+		if seen[c.Name] {
+			continue
+		}
+		seen[c.Name] = true
+		names = append(names, mt.swap(c.Name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// complete mirrors list but descends only into children which may hold
+// names starting with want
+func complete(path string, want string, subtrie *Entry) []*Content {
+	res := make([]*Content, 0)
+
+	if path == Separator && subtrie.Path == Separator {
+		for _, me := range subtrie.Entries {
+			if me.Path == SpecialPathSymbol {
+				continue
+			}
+			res = append(res, completeCollect(want, "", "", me)...)
+		}
+		return res
+	}
+
+	if strings.HasPrefix(subtrie.Path, path) && subtrie.Path != path {
+		suffix := strings.TrimPrefix(subtrie.Path, path)
+		if len(suffix) != 0 && (suffix[0] == SeparatorRune || path == Separator) {
+			return completeCollect(want, path, "", subtrie)
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(path, subtrie.Path) {
+		suffix := strings.TrimPrefix(path, subtrie.Path)
+		for _, me := range subtrie.Entries {
+			res = append(res, complete(suffix, want, me)...)
+		}
+	}
+
+	return res
+}
+
+// completeCollect mirrors collect but prunes subtries whose
+// names can't start with want
+func completeCollect(want string, prefix string, fullname string, subtrie *Entry) []*Content {
+	if len(want) == 0 {
+		return collect(prefix, fullname, subtrie)
+	}
+	if subtrie.Path == SpecialPathSymbol {
+		// Marker names the parent itself which is already shorter than want
+		return nil
+	}
+
+	suffix := subtrie.Path
+	if len(prefix) != 0 {
+		suffix = strings.TrimPrefix(suffix, prefix)
+	}
+	if len(suffix) != 0 && suffix[0] == SeparatorRune {
+		suffix = suffix[1:]
+	}
+
+	if strings.HasPrefix(suffix, want) {
+		return collect(prefix, fullname, subtrie)
+	}
+	if !strings.HasPrefix(want, suffix) || strings.Contains(suffix, Separator) {
+		return nil
+	}
+
+	res := make([]*Content, 0)
+	for _, me := range subtrie.Entries {
+		if len(me.Path) != 0 && me.Path[0] == SeparatorRune {
+			continue
+		}
+		res = append(res, completeCollect(want[len(suffix):], "", fullname+suffix, me)...)
+	}
+	return res
+}
@@ -0,0 +1,307 @@
+package triefs_test
+
+import (
+	"math/rand"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	trie := triefs.NewTrie()
+	paths := []string{
+		"/docs/café-résumé.pdf",
+		"/docs/日本-report-🚀.txt",
+		"/data/München/Ölpreis.csv",
+		"/data/CAFÉ/menu.txt",
+	}
+	for _, p := range paths {
+		_, err := trie.AddFile(triefs.NewEntry(p, "cid-"+p, 200, triefs.MIMEOctetStream, now))
+		if err != nil {
+			t.Fatalf("AddFile(%q) failed: %v", p, err)
+		}
+	}
+
+	cases := []struct {
+		name            string
+		query           string
+		caseInsensitive bool
+		expected        []string
+	}{
+		{
+			name:     "case sensitive",
+			query:    "café",
+			expected: []string{"/docs/café-résumé.pdf"},
+		},
+		{
+			name:            "case insensitive unicode",
+			query:           "café",
+			caseInsensitive: true,
+			expected:        []string{"/data/CAFÉ", "/docs/café-résumé.pdf"},
+		},
+		{
+			name:            "case insensitive umlaut",
+			query:           "ölpreis",
+			caseInsensitive: true,
+			expected:        []string{"/data/München/Ölpreis.csv"},
+		},
+		{
+			name:     "no match",
+			query:    "missing",
+			expected: []string{},
+		},
+		{
+			name:  "empty query",
+			query: "",
+			expected: []string{
+				"/data",
+				"/data/CAFÉ",
+				"/data/CAFÉ/menu.txt",
+				"/data/München",
+				"/data/München/Ölpreis.csv",
+				"/docs",
+				"/docs/café-résumé.pdf",
+				"/docs/日本-report-🚀.txt",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := trie.Search(tc.query, tc.caseInsensitive)
+			if len(res) != len(tc.expected) {
+				t.Fatalf("got %v, want %v", len(res), len(tc.expected))
+			}
+			for i, r := range res {
+				if r.Path != tc.expected[i] {
+					t.Errorf("got %v, want %v", r.Path, tc.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	cases := []struct {
+		name     string
+		dirs     []*triefs.Entry
+		dir      string
+		prefix   string
+		expected []string
+	}{
+		{
+			name: "appending same last char",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/folder1/folder2/myfile1", "test_cid", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/folder1/folder2/myfile11", "test_cid", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/folder1/folder2/myfile111", "test_cid", 512, triefs.MIMEOctetStream, now),
+			},
+			dir:      "/folder1/folder2",
+			prefix:   "myfile1",
+			expected: []string{"myfile1", "myfile11", "myfile111"},
+		},
+		{
+			name: "narrowing prefix",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/folder1/folder2/myfile1", "test_cid", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/folder1/folder2/myfile11", "test_cid", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/folder1/folder2/myfile111", "test_cid", 512, triefs.MIMEOctetStream, now),
+			},
+			dir:      "/folder1/folder2",
+			prefix:   "myfile11",
+			expected: []string{"myfile11", "myfile111"},
+		},
+		{
+			name: "directories and files in root",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/docs/readme", "cid", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/download", "", 0, triefs.MIMEDriveEntry, now),
+				triefs.NewEntry("/dot", "cid", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/music", "", 0, triefs.MIMEDriveEntry, now),
+			},
+			dir:      "/",
+			prefix:   "do",
+			expected: []string{"docs", "dot", "download"},
+		},
+		{
+			name: "empty prefix lists all children",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/a/b", "cid", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/a/c/d", "cid", 1, triefs.MIMEOctetStream, now),
+			},
+			dir:      "/a",
+			prefix:   "",
+			expected: []string{"b", "c"},
+		},
+		{
+			name: "missing directory",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/a/b", "cid", 1, triefs.MIMEOctetStream, now),
+			},
+			dir:      "/missing",
+			prefix:   "b",
+			expected: []string{},
+		},
+		{
+			name: "no match",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/a/b", "cid", 1, triefs.MIMEOctetStream, now),
+			},
+			dir:      "/a",
+			prefix:   "z",
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			trie := triefs.NewTrie()
+			for _, d := range tc.dirs {
+				_, err := trie.AddFile(d)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			names := trie.Complete(tc.dir, tc.prefix)
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("got %v, want %v", names, tc.expected)
+			}
+		})
+	}
+}
+
+func TestFuzzyComplete(t *testing.T) {
+	trie := triefs.NewTrie()
+	paths := createRandomFiles(trie, 200)
+	for _, p := range paths {
+		for l := 0; l <= len(p)-1; l++ {
+			prefix := p[1 : 1+l]
+			expected := make([]string, 0)
+			seen := make(map[string]bool)
+			for _, c := range trie.Ls("/") {
+				if strings.HasPrefix(c.Name, prefix) && !seen[c.Name] {
+					seen[c.Name] = true
+					expected = append(expected, c.Name)
+				}
+			}
+			sort.Strings(expected)
+			names := trie.Complete("/", prefix)
+			if !reflect.DeepEqual(names, expected) {
+				t.Fatalf("prefix %q: got %v, want %v", prefix, names, expected)
+			}
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, p := range []string{"/a.txt", "/docs/b.txt", "/docs/c.md", "/docs/deep/d.txt", "/txt/e.bin"} {
+		if _, err := trie.AddFile(triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		pattern  string
+		expected []string
+		err      error
+	}{
+		{pattern: "*.txt", expected: []string{"/a.txt", "/docs/b.txt", "/docs/deep/d.txt"}},
+		{pattern: "/docs/*.txt", expected: []string{"/docs/b.txt"}},
+		{pattern: "/*/*", expected: []string{"/docs/b.txt", "/docs/c.md", "/docs/deep", "/txt/e.bin"}},
+		{pattern: "d*", expected: []string{"/docs", "/docs/deep", "/docs/deep/d.txt"}},
+		{pattern: "*.go", expected: []string{}},
+		{pattern: "[", err: filepath.ErrBadPattern},
+	}
+	for _, tc := range cases {
+		got, err := trie.Glob(tc.pattern)
+		if err != tc.err {
+			t.Errorf("%v: got %v, want %v", tc.pattern, err, tc.err)
+			continue
+		}
+		if tc.err == nil && !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%v: got %v, want %v", tc.pattern, got, tc.expected)
+		}
+	}
+}
+
+func TestSearchIndexed(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	names := []string{"a", "A", "b", "Ab", "aB", "ǅ", "ǆ"}
+	rnd := rand.New(rand.NewSource(1))
+	randomPath := func() string {
+		segments := make([]string, 1+rnd.Intn(3))
+		for i := range segments {
+			segments[i] = names[rnd.Intn(len(names))]
+		}
+		return "/" + strings.Join(segments, "/")
+	}
+	// bruteForce mirrors SearchIndexed on top of Search
+	bruteForce := func(trie *triefs.Trie, query string) []string {
+		res := make([]string, 0)
+		for _, r := range trie.Search("", false) {
+			if strings.EqualFold(path.Base(r.Path), query) {
+				res = append(res, r.Path)
+			}
+		}
+		return res
+	}
+
+	trie := triefs.NewTrie()
+	plain := triefs.NewTrie()
+	trie.BuildSearchIndex()
+	for i := 0; i < 500; i++ {
+		p, q, op := randomPath(), randomPath(), rnd.Intn(7)
+		for _, tr := range []*triefs.Trie{trie, plain} {
+			switch op {
+			case 0, 1:
+				_, _ = tr.AddFile(triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now))
+			case 2:
+				_, _ = tr.AddFile(triefs.NewEntry(p, "", 0, triefs.MIMEDriveEntry, now))
+			case 3:
+				_ = tr.Delete(p)
+			case 4:
+				_ = tr.Move(p, q)
+			case 5:
+				_, _ = tr.CreateRef(p, "bucket", now)
+			case 6:
+				_, _ = tr.MoveManyInto([]string{p, q}, path.Dir(q))
+			}
+		}
+		if i%50 == 0 {
+			data, err := trie.MarshalBinary()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := trie.UnmarshalBinary(data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		for _, name := range names {
+			want := bruteForce(trie, name)
+			if got := trie.SearchIndexed(name); !reflect.DeepEqual(got, want) {
+				t.Fatalf("step %v, %v: got %v, want %v", i, name, got, want)
+			}
+			if got := plain.SearchIndexed(name); !reflect.DeepEqual(got, bruteForce(plain, name)) {
+				t.Fatalf("step %v, %v without index: got %v, want %v", i, name, got, bruteForce(plain, name))
+			}
+		}
+	}
+}
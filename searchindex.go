@@ -0,0 +1,132 @@
+package triefs
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// searchIndex maps folded names to internal paths of entries having them,
+// see BuildSearchIndex. A nil index is valid and ignores all updates
+type searchIndex struct {
+	names map[string]map[string]struct{}
+}
+
+func newSearchIndex(paths []string) *searchIndex {
+	si := &searchIndex{names: make(map[string]map[string]struct{}, len(paths))}
+	for _, p := range paths {
+		si.add(p)
+	}
+	return si
+}
+
+func (si *searchIndex) add(path string) {
+	if si == nil {
+		return
+	}
+	key := foldName(filepath.Base(path))
+	set, ok := si.names[key]
+	if !ok {
+		set = make(map[string]struct{})
+		si.names[key] = set
+	}
+	set[path] = struct{}{}
+}
+
+func (si *searchIndex) drop(path string) {
+	if si == nil {
+		return
+	}
+	key := foldName(filepath.Base(path))
+	delete(si.names[key], path)
+	if len(si.names[key]) == 0 {
+		delete(si.names, key)
+	}
+}
+
+// foldName maps every rune to the smallest one of its Unicode simple folding
+// orbit, so names equal under strings.EqualFold get the same key
+func foldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		min := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < min {
+				min = f
+			}
+		}
+		return min
+	}, name)
+}
+
+// BuildSearchIndex builds an index of entry names used by SearchIndexed and
+// keeps it up to date on every change of the trie from now on. Calling it
+// again rebuilds the index
+func (mt *Trie) BuildSearchIndex() {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	mt.search = newSearchIndex(mt.indexPaths())
+}
+
+// SearchIndexed returns sorted absolute paths of entries named query under
+// Unicode simple folding, like Search in case-insensitive mode but matching
+// whole names. Without BuildSearchIndex the whole trie is traversed
+func (mt *Trie) SearchIndexed(query string) []string {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	res := make([]string, 0)
+	if mt.search == nil {
+		for _, p := range mt.indexPaths() {
+			if strings.EqualFold(filepath.Base(p), query) {
+				res = append(res, p)
+			}
+		}
+		return mt.swapPaths(res)
+	}
+
+	for p := range mt.search.names[foldName(query)] {
+		res = append(res, p)
+	}
+	sort.Strings(res)
+	return mt.swapPaths(res)
+}
+
+// indexPaths returns sorted internal paths of all entries.
+// Callers must hold at least a read lock.
+func (mt *Trie) indexPaths() []string {
+	paths := make([]string, 0)
+	if mt.Root == nil {
+		return paths
+	}
+	for _, e := range mt.lsRecursive(Separator) {
+		paths = append(paths, e.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// reindexSearch rebuilds the search index, if there is one, after the whole
+// Root was replaced.
+// Callers must hold the write lock.
+func (mt *Trie) reindexSearch() {
+	if mt.search != nil {
+		mt.search = newSearchIndex(mt.indexPaths())
+	}
+}
+
+// unindexRemoved drops path and its parents which no longer exist from the
+// search index.
+// Callers must hold the write lock.
+func (mt *Trie) unindexRemoved(path string) {
+	if mt.search == nil {
+		return
+	}
+	for p := path; p != Separator; p = filepath.Dir(p) {
+		if mt.Root != nil && stat(p, mt.Root) != nil {
+			return
+		}
+		mt.search.drop(p)
+	}
+}
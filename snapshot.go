@@ -0,0 +1,89 @@
+package triefs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// SnapshotVersion is the snapshot format version written by Snapshot
+const SnapshotVersion byte = 6
+
+var snapshotMagic = []byte("TRFS")
+
+// Snapshot writes the trie as a snapshot stream: magic header, format version,
+// length-prefixed MarshalBinary payload and the trie Hash as a footer
+func (mt *Trie) Snapshot(w io.Writer) error {
+	mt.lock.RLock()
+	payload := mt.marshalBinary()
+	hash, err := mt.hash()
+	mt.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, len(snapshotMagic)+1+8+len(payload)+1+len(hash))
+	buf = append(buf, snapshotMagic...)
+	buf = append(buf, SnapshotVersion)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(len(payload)))
+	buf = append(buf, payload...)
+	buf = append(buf, byte(len(hash)))
+	buf = append(buf, hash...)
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// RestoreSnapshot reads a stream written by Snapshot. It fails with
+// ErrSnapshotVersion for snapshots of other format versions and with
+// ErrSnapshotChecksum when the restored trie doesn't match the stored Hash
+func RestoreSnapshot(r io.Reader) (*Trie, error) {
+	header := make([]byte, len(snapshotMagic)+1)
+	_, err := io.ReadFull(r, header)
+	if err != nil || !bytes.Equal(header[:len(snapshotMagic)], snapshotMagic) {
+		return nil, ErrInvalidSnapshot
+	}
+	if v := header[len(snapshotMagic)]; v != SnapshotVersion {
+		return nil, fmt.Errorf("%w: got version %d, supported %d", ErrSnapshotVersion, v, SnapshotVersion)
+	}
+
+	var size uint64
+	err = binary.Read(r, binary.BigEndian, &size)
+	if err != nil || size > math.MaxInt64 {
+		return nil, ErrInvalidSnapshot
+	}
+	// copy instead of allocating size bytes upfront, size may be corrupted
+	payload := new(bytes.Buffer)
+	_, err = io.CopyN(payload, r, int64(size))
+	if err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+
+	hashLen := make([]byte, 1)
+	_, err = io.ReadFull(r, hashLen)
+	if err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	hash := make([]byte, hashLen[0])
+	_, err = io.ReadFull(r, hash)
+	if err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+
+	mt := NewTrie()
+	err = mt.UnmarshalBinary(payload.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotChecksum, err)
+	}
+
+	actual, err := mt.Hash()
+	if err != nil {
+		return nil, err
+	}
+	if actual != string(hash) {
+		return nil, fmt.Errorf("%w: got %s, want %s", ErrSnapshotChecksum, actual, hash)
+	}
+	return mt, nil
+}
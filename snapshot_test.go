@@ -0,0 +1,91 @@
+package triefs_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestSnapshotRoundtrip(t *testing.T) {
+	t.Parallel()
+	for _, trie := range []*triefs.Trie{triefs.NewTrie(), tarTrie(t)} {
+		buf := new(bytes.Buffer)
+		if err := trie.Snapshot(buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		restored, err := triefs.RestoreSnapshot(buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, _ := restored.Hash()
+		want, _ := trie.Hash()
+		if got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRestoreSnapshotErrors(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	if err := tarTrie(t).Snapshot(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snapshot := buf.Bytes()
+
+	modified := func(fn func(b []byte)) []byte {
+		b := append([]byte{}, snapshot...)
+		fn(b)
+		return b
+	}
+	// offset of the first payload byte after magic, version and length
+	const payload = 4 + 1 + 8
+
+	cases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{
+			name: "not a snapshot",
+			data: []byte("hello world, this is not a snapshot"),
+			err:  triefs.ErrInvalidSnapshot,
+		},
+		{
+			name: "truncated",
+			data: snapshot[:len(snapshot)-10],
+			err:  triefs.ErrInvalidSnapshot,
+		},
+		{
+			name: "future version",
+			data: modified(func(b []byte) { b[4] = triefs.SnapshotVersion + 1 }),
+			err:  triefs.ErrSnapshotVersion,
+		},
+		{
+			name: "corrupted payload",
+			// flips a byte inside the root path label
+			data: modified(func(b []byte) { b[payload+2] ^= 0x01 }),
+			err:  triefs.ErrSnapshotChecksum,
+		},
+		{
+			name: "corrupted hash",
+			data: modified(func(b []byte) { b[len(b)-1] ^= 0x01 }),
+			err:  triefs.ErrSnapshotChecksum,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := triefs.RestoreSnapshot(bytes.NewReader(tc.data))
+			if !errors.Is(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
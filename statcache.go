@@ -0,0 +1,105 @@
+package triefs
+
+import (
+	clist "container/list"
+	"sync"
+)
+
+// statCache is a LRU of File and Stat results keyed by cleaned path. It has
+// its own lock since lookups happen under the trie read lock. A nil cache
+// is valid and caches nothing
+type statCache struct {
+	lock  sync.Mutex
+	size  int
+	order *clist.List
+	items map[statKey]*clist.Element
+}
+
+type statKey struct {
+	path string
+	stat bool
+}
+
+type statItem struct {
+	key statKey
+	c   *Content
+}
+
+func newStatCache(size int) *statCache {
+	if size <= 0 {
+		return nil
+	}
+	return &statCache{
+		size:  size,
+		order: clist.New(),
+		items: make(map[statKey]*clist.Element, size),
+	}
+}
+
+// get returns a copy of cached content, nil if missing
+func (sc *statCache) get(path string, stat bool) *Content {
+	if sc == nil {
+		return nil
+	}
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	el, ok := sc.items[statKey{path: path, stat: stat}]
+	if !ok {
+		return nil
+	}
+	sc.order.MoveToFront(el)
+	return el.Value.(*statItem).c.copy()
+}
+
+// put caches a copy of c evicting the least recently used item when full
+func (sc *statCache) put(path string, stat bool, c *Content) {
+	if sc == nil {
+		return
+	}
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	key := statKey{path: path, stat: stat}
+	if el, ok := sc.items[key]; ok {
+		el.Value.(*statItem).c = c.copy()
+		sc.order.MoveToFront(el)
+		return
+	}
+	sc.items[key] = sc.order.PushFront(&statItem{key: key, c: c.copy()})
+	if sc.order.Len() > sc.size {
+		el := sc.order.Back()
+		sc.order.Remove(el)
+		delete(sc.items, el.Value.(*statItem).key)
+	}
+}
+
+// invalidate drops cached paths equal to, above or below the cleaned path.
+// Changing an entry may add or remove its parents and always affects its
+// subtree, so both directions are dropped
+func (sc *statCache) invalidate(path string) {
+	if sc == nil {
+		return
+	}
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	for key, el := range sc.items {
+		if isSubpath(key.path, path) || isSubpath(path, key.path) {
+			sc.order.Remove(el)
+			delete(sc.items, key)
+		}
+	}
+}
+
+// clear drops everything cached
+func (sc *statCache) clear() {
+	if sc == nil {
+		return
+	}
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	sc.order.Init()
+	sc.items = make(map[statKey]*clist.Element, sc.size)
+}
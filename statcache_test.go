@@ -0,0 +1,144 @@
+package triefs_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func cachedTrie(t *testing.T, size int) *triefs.Trie {
+	t.Helper()
+	trie, err := triefs.NewTrieWithOptions(triefs.Options{StatCacheSize: size})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return trie
+}
+
+func TestStatCacheReplace(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := cachedTrie(t, 8)
+	if _, err := trie.AddFile(triefs.NewEntry("/a/file", "cid1", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		c, err := trie.Stat("/a/file")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if c.CID != "cid1" {
+			t.Errorf("got %v, want %v", c.CID, "cid1")
+		}
+		// results are copies, changing them doesn't affect the cache
+		c.CID = "changed"
+	}
+
+	_, _, err := trie.Replace("/a/file", &triefs.Content{CID: "cid2", Size: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c, err := trie.Stat("/a/file")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.CID != "cid2" || c.Size != 2 {
+		t.Errorf("got %v, want cid2 of size 2", c)
+	}
+	f, err := trie.File("/a//file/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.CID != "cid2" {
+		t.Errorf("got %v, want %v", f.CID, "cid2")
+	}
+}
+
+func TestStatCacheMutations(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	paths := []string{"/a", "/a/b", "/a/b/file", "/a/empty", "/c", "/c/b", "/c/b/file"}
+	cases := []struct {
+		name   string
+		change func(trie *triefs.Trie) error
+	}{
+		{
+			name:   "delete file",
+			change: func(trie *triefs.Trie) error { return trie.Delete("/a/b/file") },
+		},
+		{
+			name:   "delete directory",
+			change: func(trie *triefs.Trie) error { return trie.Delete("/a") },
+		},
+		{
+			name:   "move directory",
+			change: func(trie *triefs.Trie) error { return trie.Move("/a/b", "/c") },
+		},
+		{
+			name: "rename",
+			change: func(trie *triefs.Trie) error {
+				_, err := trie.Rename("/a", "c")
+				return err
+			},
+		},
+		{
+			name: "add into empty folder",
+			change: func(trie *triefs.Trie) error {
+				_, err := trie.AddFile(triefs.NewEntry("/a/empty/file", "cid3", 3, triefs.MIMEOctetStream, now))
+				return err
+			},
+		},
+		{
+			name: "create ref",
+			change: func(trie *triefs.Trie) error {
+				_, err := trie.CreateRef("/a/b", "bucket", now)
+				return err
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cached, plain := cachedTrie(t, 2), triefs.NewTrie()
+			for _, trie := range []*triefs.Trie{cached, plain} {
+				for _, e := range []*triefs.Entry{
+					triefs.NewEntry("/a/b/file", "cid1", 1, triefs.MIMEOctetStream, now),
+					triefs.NewEntry("/a/empty", "", 0, triefs.MIMEDriveEntry, now),
+				} {
+					if _, err := trie.AddFile(e); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+				}
+			}
+			for _, p := range paths {
+				_, _ = cached.Stat(p)
+				_, _ = cached.File(p)
+			}
+
+			if err := tc.change(cached); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := tc.change(plain); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, p := range paths {
+				got, gotErr := cached.Stat(p)
+				want, wantErr := plain.Stat(p)
+				if gotErr != wantErr || !reflect.DeepEqual(got, want) {
+					t.Errorf("stat %v: got %v %v, want %v %v", p, got, gotErr, want, wantErr)
+				}
+				got, gotErr = cached.File(p)
+				want, wantErr = plain.File(p)
+				if gotErr != wantErr || !reflect.DeepEqual(got, want) {
+					t.Errorf("file %v: got %v %v, want %v %v", p, got, gotErr, want, wantErr)
+				}
+			}
+		})
+	}
+}
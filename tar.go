@@ -0,0 +1,178 @@
+package triefs
+
+import (
+	"archive/tar"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PAX records keeping entry fields which don't fit into a tar header
+const (
+	paxCID      = "TRIEFS.cid"
+	paxSize     = "TRIEFS.size"
+	paxType     = "TRIEFS.type"
+	paxVersion  = "TRIEFS.version"
+	paxSum      = "TRIEFS.checksum"
+	paxRefs     = "TRIEFS.ref_entries"
+	paxACL      = "TRIEFS.acl"
+	paxID       = "TRIEFS.id"
+	paxMod      = "TRIEFS.modified_at"
+	paxDetected = "TRIEFS.detected_type"
+	paxMeta     = "TRIEFS.meta."
+)
+
+// WriteTar writes the trie structure as a tar stream in path order. Directories
+// become dir entries, files and references become empty regular entries with
+// CID, declared size and type kept in PAX records, symlinks point to their target
+func (mt *Trie) WriteTar(w io.Writer) error {
+	mt.lock.RLock()
+	manifest := mt.manifest()
+	mt.lock.RUnlock()
+
+	tw := tar.NewWriter(w)
+	for _, me := range manifest {
+		hdr := &tar.Header{
+			Name:    strings.TrimPrefix(me.Path, Separator),
+			ModTime: time.Unix(me.CreatedAt, 0),
+			Format:  tar.FormatPAX,
+		}
+
+		switch me.Type {
+		case MIMEDriveDirectory:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += Separator
+			hdr.Mode = 0755
+		case MIMESymlink:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = me.CID
+			hdr.Mode = 0777
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Mode = 0644
+			hdr.PAXRecords = map[string]string{
+				paxCID:     me.CID,
+				paxSize:    strconv.FormatInt(me.Size, 10),
+				paxType:    me.Type,
+				paxVersion: strconv.Itoa(int(me.Version)),
+			}
+			if len(me.Checksum) != 0 {
+				hdr.PAXRecords[paxSum] = me.Checksum
+			}
+			if me.RefEntries != 0 {
+				hdr.PAXRecords[paxRefs] = strconv.FormatInt(me.RefEntries, 10)
+			}
+			if len(me.ACL) != 0 {
+				hdr.PAXRecords[paxACL] = strings.Join(me.ACL, "\n")
+			}
+			if len(me.ID) != 0 {
+				hdr.PAXRecords[paxID] = me.ID
+			}
+			if me.ModifiedAt != 0 {
+				hdr.PAXRecords[paxMod] = strconv.FormatInt(me.ModifiedAt, 10)
+			}
+			if len(me.DetectedType) != 0 {
+				hdr.PAXRecords[paxDetected] = me.DetectedType
+			}
+			for k, v := range me.Metadata {
+				hdr.PAXRecords[paxMeta+k] = v
+			}
+		}
+
+		err := tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ReadTar builds new trie from the tar stream produced by WriteTar
+func ReadTar(r io.Reader) (*Trie, error) {
+	manifest := make([]ManifestEntry, 0)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		me := ManifestEntry{
+			Path:      JoinPath(Separator, strings.TrimSuffix(hdr.Name, Separator)),
+			CreatedAt: hdr.ModTime.Unix(),
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			me.Type = MIMEDriveDirectory
+		case tar.TypeSymlink:
+			me.Type = MIMESymlink
+			me.CID = hdr.Linkname
+			me.Version = 1
+		case tar.TypeReg:
+			me.Type = MIMEOctetStream
+			me.Size = hdr.Size
+			me.Version = 1
+			err = fromPAXRecords(&me, hdr.PAXRecords)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			continue
+		}
+		manifest = append(manifest, me)
+	}
+	return fromManifest(manifest)
+}
+
+func fromPAXRecords(me *ManifestEntry, records map[string]string) error {
+	for k, v := range records {
+		switch {
+		case k == paxCID:
+			me.CID = v
+		case k == paxType:
+			me.Type = v
+		case k == paxSum:
+			me.Checksum = v
+		case k == paxSize:
+			size, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return err
+			}
+			me.Size = size
+		case k == paxRefs:
+			refs, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return err
+			}
+			me.RefEntries = refs
+		case k == paxACL:
+			me.ACL = strings.Split(v, "\n")
+		case k == paxID:
+			me.ID = v
+		case k == paxMod:
+			modified, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return err
+			}
+			me.ModifiedAt = modified
+		case k == paxDetected:
+			me.DetectedType = v
+		case k == paxVersion:
+			version, err := strconv.ParseUint(v, 10, 8)
+			if err != nil {
+				return err
+			}
+			me.Version = byte(version)
+		case strings.HasPrefix(k, paxMeta):
+			if me.Metadata == nil {
+				me.Metadata = make(map[string]string)
+			}
+			me.Metadata[strings.TrimPrefix(k, paxMeta)] = v
+		}
+	}
+	return nil
+}
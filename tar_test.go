@@ -0,0 +1,128 @@
+package triefs_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func tarTrie(t *testing.T) *triefs.Trie {
+	t.Helper()
+	now := time.Now()
+	withMeta := triefs.NewEntry("/docs/readme.txt", "cid1", 128, "text/plain", now)
+	withMeta.SetMetadata("owner", "alice")
+	withMeta.Checksum = "crc32:5d4a0bda"
+
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		withMeta,
+		triefs.NewEntry("/docs/sub/big.bin", "cid2", 1<<40, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/empty", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/docs/link", "readme.txt", 0, triefs.MIMESymlink, now),
+		triefs.NewEntry("/shared/photos/a.png", "cid3", 64, "image/png", now),
+		triefs.NewEntry("/中文/文件.txt", "cid4", 1, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := trie.CreateRefWithSize("/shared", "bucket", now, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	trie.InferTypes()
+	return trie
+}
+
+func TestTarRoundtrip(t *testing.T) {
+	t.Parallel()
+	trie := tarTrie(t)
+
+	buf := new(bytes.Buffer)
+	if err := trie.WriteTar(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded, err := triefs.ReadTar(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := loaded.MarshalManifest()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, err := trie.MarshalManifest()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	gotHash, _ := loaded.Hash()
+	wantHash, _ := trie.Hash()
+	if gotHash != wantHash {
+		t.Errorf("got %v, want %v", gotHash, wantHash)
+	}
+
+	ref, err := loaded.File("/shared")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ref.Type != triefs.MIMEReference || ref.CID != "bucket" {
+		t.Errorf("got %v, want reference to bucket", ref)
+	}
+}
+
+func TestWriteTarHeaders(t *testing.T) {
+	t.Parallel()
+	trie := tarTrie(t)
+
+	buf := new(bytes.Buffer)
+	if err := trie.WriteTar(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type header struct {
+		name     string
+		typeflag byte
+	}
+	got := make([]header, 0)
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, header{name: hdr.Name, typeflag: hdr.Typeflag})
+	}
+
+	expected := []header{
+		{name: "docs/", typeflag: tar.TypeDir},
+		{name: "docs/empty/", typeflag: tar.TypeDir},
+		{name: "docs/link", typeflag: tar.TypeSymlink},
+		{name: "docs/readme.txt", typeflag: tar.TypeReg},
+		{name: "docs/sub/", typeflag: tar.TypeDir},
+		{name: "docs/sub/big.bin", typeflag: tar.TypeReg},
+		{name: "shared", typeflag: tar.TypeReg},
+		{name: "中文/", typeflag: tar.TypeDir},
+		{name: "中文/文件.txt", typeflag: tar.TypeReg},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+}
+
+func TestReadTarInvalid(t *testing.T) {
+	t.Parallel()
+	if _, err := triefs.ReadTar(bytes.NewBufferString("not a tar")); err == nil {
+		t.Errorf("expected error")
+	}
+}
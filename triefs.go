@@ -2,11 +2,11 @@
 package triefs
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -24,6 +24,9 @@ const (
 	MIMEOctetStream = "application/octet-stream"
 	// MIMEReference is mime type from that is referenced to another filesystem
 	MIMEReference = "application/triefs-reference"
+	// MIMESymlink is mime type of a link to another path of the same filesystem,
+	// the target path is stored in CID
+	MIMESymlink = "application/triefs-symlink"
 
 	// SpecialPathSymbol the only symbol you can't use in paths or names
 	SpecialPathSymbol = ":"
@@ -34,6 +37,9 @@ const (
 	// DoubleSeparator is a constant that represents filesystem
 	// separator double symbol and used to clean up the paths
 	DoubleSeparator = "//"
+
+	// MaxLinkDepth is the number of symlinks ResolveLink follows before giving up
+	MaxLinkDepth = 40
 )
 
 var (
@@ -43,10 +49,15 @@ var (
 	ErrEmptyPath = errors.New("paths can't be empty")
 	// ErrEmptyName mean the passed name is empty
 	ErrEmptyName = errors.New("names can't be empty")
+	// ErrInvalidName returned when a name is left empty by SanitizeName or
+	// is rejected by Options.NamePolicy
+	ErrInvalidName = errors.New("invalid name: trailing spaces or dots")
 	// ErrIllegalPathChars means that passed path has illegal characters
 	ErrIllegalPathChars = errors.New("semicolon and multiple consequent slashes in path are not allowed")
 	// ErrIllegalNameChars means that passed name has illegal characters
 	ErrIllegalNameChars = errors.New("semicolon or slashes in name are not allowed")
+	// ErrInvalidUTF8 means that passed path or name isn't a valid UTF-8 string
+	ErrInvalidUTF8 = errors.New("paths and names must be valid UTF-8")
 	// ErrCantAddDirectory means that someone tried to add directory directly which is not possible
 	// use MIMEDriveEntry placeholder instead
 	ErrCantAddDirectory = errors.New("directories are ephemeral for placeholder use Entity content type")
@@ -54,6 +65,44 @@ var (
 	ErrFileNotExist = errors.New("file doesn't exist")
 	// ErrCantCreateRef returned if provided path for createRef is root
 	ErrCantCreateRef = errors.New("cannot create reference on root")
+	// ErrNotADirectory returned when a directory operation is called on a file path
+	ErrNotADirectory = errors.New("entry is not a directory")
+	// ErrLinkLoop returned when symlink resolution exceeds MaxLinkDepth
+	ErrLinkLoop = errors.New("too many levels of symbolic links")
+	// ErrInvalidMove returned when a directory is moved into itself or its descendant
+	ErrInvalidMove = errors.New("directory can't be moved into itself")
+	// ErrInvalidSeparator returned when Options.Separator can't be used to split paths
+	ErrInvalidSeparator = errors.New("separator can't be used in paths")
+	// ErrEmptyDirNotAllowed returned when an empty directory is added to a trie
+	// created with Options.NoEmptyDirs
+	ErrEmptyDirNotAllowed = errors.New("empty directories are not allowed")
+	// ErrDisallowedType returned when type of an added entry isn't allowed by SetAllowedTypes
+	ErrDisallowedType = errors.New("content type isn't allowed")
+	// ErrDirNotEmpty returned by Delete of a directory with entries in a trie
+	// created with Options.DeleteNonEmptyErrors, use DeleteAll instead
+	ErrDirNotEmpty = errors.New("directory is not empty")
+	// ErrEpochMismatch returned by CompareAndSwapEpoch when the trie has
+	// changed since the expected epoch
+	ErrEpochMismatch = errors.New("trie epoch mismatch")
+	// ErrInvalidTrie returned by Validate when the trie structure is broken
+	ErrInvalidTrie = errors.New("invalid trie")
+	// ErrInvalidBinary returned when binary encoded trie can't be decoded
+	ErrInvalidBinary = errors.New("invalid binary trie encoding")
+	// ErrInvalidSnapshot returned when the stream isn't a trie snapshot or is truncated
+	ErrInvalidSnapshot = errors.New("invalid trie snapshot")
+	// ErrSnapshotVersion returned when the snapshot format version isn't supported
+	ErrSnapshotVersion = errors.New("unsupported trie snapshot version")
+	// ErrSnapshotChecksum returned when the restored trie hash doesn't match the snapshot one
+	ErrSnapshotChecksum = errors.New("trie snapshot hash mismatch")
+	// ErrQuotaExceeded returned when a change would grow the total size of
+	// files over the quota set by SetQuota
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrTooManyChildren returned when adding an entry to a directory having
+	// Options.MaxChildren entries already
+	ErrTooManyChildren = errors.New("directory has too many entries")
+	// ErrCIDMismatch returned by ReplaceIfCID when the stored CID isn't the
+	// expected one
+	ErrCIDMismatch = errors.New("file CID doesn't match")
 )
 
 // Entry describes the trie node structure, if Entries length slice is zero - it's a leaf
@@ -62,6 +111,9 @@ type Entry struct {
 	Path    string   `json:"path"`
 	Entries []*Entry `json:"entries"`
 	Meta    *Meta    `json:"meta,omitempty"`
+
+	// index of Entries by first rune of their path, see indexThreshold
+	index map[rune]*Entry
 }
 
 // Meta holds some extra fields for entry
@@ -119,11 +171,11 @@ func (entry *Entry) Validate() error {
 	if entry.Name == "." && len(entry.Path) == 0 {
 		return ErrEmptyPath
 	}
-	if !utf8.ValidString(entry.Path) {
-		return ErrIllegalPathChars
-	}
-	if strings.Contains(entry.Path, SpecialPathSymbol) {
-		return ErrIllegalPathChars
+	if len(entry.Path) != 0 {
+		err := ValidatePath(entry.Path)
+		if err != nil {
+			return err
+		}
 	}
 	if entry.IsEmptyFolder() && (len(CleanPath(entry.Path)) == 0 || CleanPath(entry.Path) == Separator) {
 		return ErrEmptyName
@@ -131,26 +183,108 @@ func (entry *Entry) Validate() error {
 	return entry.Content.Validate()
 }
 
+// Normalize makes a hand-built entry look like one made by NewEntry. Path is
+// cleaned. Files get Name from the last path segment, the default type and
+// Version 1 when it's zero. MIMEDriveEntry placeholders get the marker
+// child and lose Name, CID, Size and Version. Directories have CID and Size
+// cleared but keep MIMEDriveDirectory, which AddFile rejects. AddFile
+// normalizes added entries in place
+func (entry *Entry) Normalize() {
+	if len(entry.Path) != 0 {
+		entry.Path = CleanPath(entry.Path)
+	}
+
+	switch entry.Type {
+	case MIMEDriveDirectory:
+		entry.CID, entry.Size = "", 0
+	case MIMEDriveEntry:
+		entry.Name, entry.CID, entry.Size, entry.Version = "", "", 0, 0
+		if len(entry.Entries) == 0 {
+			entry.Entries = []*Entry{{
+				Content: NewContent("", "", 0, MIMEDriveEntry, time.Unix(entry.CreatedAt, 0)),
+				Path:    SpecialPathSymbol,
+			}}
+		}
+	default:
+		if len(entry.Type) == 0 {
+			entry.Type = MIMEOctetStream
+		}
+		if len(entry.Path) != 0 && entry.Path != Separator {
+			entry.Name = filepath.Base(entry.Path)
+		}
+		if entry.Version == 0 {
+			entry.Version = 1
+		}
+	}
+}
+
+// ReservedChars returns characters which can't be used anywhere in paths,
+// ValidatePath rejects them with ErrIllegalPathChars. Separator is reserved
+// in names only, ValidateName rejects it along with these with
+// ErrIllegalNameChars
+func ReservedChars() []rune {
+	return []rune{rune(SpecialPathSymbol[0])}
+}
+
+// ValidatePath checks if path can be used to add an entry,
+// AddFile returns the same errors
+func ValidatePath(path string) error {
+	if len(path) == 0 {
+		return ErrEmptyPath
+	}
+	if !utf8.ValidString(path) {
+		return ErrInvalidUTF8
+	}
+	if strings.Contains(path, SpecialPathSymbol) {
+		return ErrIllegalPathChars
+	}
+	return nil
+}
+
+// ValidateName checks if name can be used as a single path segment
+func ValidateName(name string) error {
+	if len(name) == 0 {
+		return ErrEmptyName
+	}
+	if !utf8.ValidString(name) {
+		return ErrInvalidUTF8
+	}
+	if strings.Contains(name, Separator) || strings.Contains(name, SpecialPathSymbol) {
+		return ErrIllegalNameChars
+	}
+	return nil
+}
+
 // IsEmptyFolder checks if provided Entry is a placeholder for empty folder
 func (entry *Entry) IsEmptyFolder() bool {
-	return entry.Type == MIMEDriveEntry && len(entry.Entries) == 1 && entry.Entries[0].Path == SpecialPathSymbol
+	return entry.Type == MIMEDriveEntry && len(entry.Entries) == 1 && entry.Entries[0].IsMarker()
+}
+
+// IsMarker checks if provided Entry is an internal trie node marking the path
+// of its parent as a file or an empty folder. Markers are never returned by
+// listing methods
+func (entry *Entry) IsMarker() bool {
+	return entry.Path == SpecialPathSymbol
 }
 
 // Copy creates a deep copy of m into entry
 func (entry *Entry) Copy(m *Entry) {
-	entry.Content = m.Content
+	entry.Content = *m.Content.copy()
 	entry.Path = m.Path
 	entry.Meta = m.Meta.copy()
 	entry.Entries = copyEntries(m.Entries)
+	entry.reindex()
 }
 
 func (entry *Entry) copy() *Entry {
-	return &Entry{
-		Content: entry.Content,
+	cp := &Entry{
+		Content: *entry.Content.copy(),
 		Path:    entry.Path,
 		Meta:    entry.Meta.copy(),
 		Entries: copyEntries(entry.Entries),
 	}
+	cp.reindex()
+	return cp
 }
 
 func copyEntries(entries []*Entry) []*Entry {
@@ -180,16 +314,39 @@ func (entry *Entry) trimPrefix(prefix string) string {
 	return entry.Path
 }
 
-// Content describes metadata content that going to be associated with the user's file
+// Content describes metadata content that going to be associated with the user's file.
+// JSON keys are part of the wire format and of Hash, they never change: name,
+// cid, content_type, size, version and created_at as Unix seconds are always
+// present, metadata, detected_type, checksum, ref_entries, acl, id and
+// modified_at only when set
 type Content struct {
 	Name    string `json:"name"`
 	CID     string `json:"cid"`
 	Type    string `json:"content_type"`
 	Size    int64  `json:"size"`
 	Version byte   `json:"version"`
-	// Behaves like "ModifiedAt" for now but the name
-	// was preserved for backward compatibility
+	// CreatedAt is the time of the content as Unix seconds, Replace takes it
+	// from the new content, while ModifiedAt stamps the replace itself
 	CreatedAt int64 `json:"created_at"`
+	// Metadata holds arbitrary application-specific key/value pairs
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// DetectedType is a MIME type guessed from the Name extension
+	// by InferTypes, Type stays untouched
+	DetectedType string `json:"detected_type,omitempty"`
+	// Checksum of the blob independent of CID, see VerifyChecksums
+	Checksum string `json:"checksum,omitempty"`
+	// RefEntries is the number of entries a reference replaced, set by
+	// CreateRefWithSize only
+	RefEntries int64 `json:"ref_entries,omitempty"`
+	// ACL lists principals allowed to see the entry, empty means public,
+	// see LsAs
+	ACL []string `json:"acl,omitempty"`
+	// ID identifies a file independently of its path, it's kept by Move,
+	// Rename and Replace, see FileByID and Options.AssignIDs
+	ID string `json:"id,omitempty"`
+	// ModifiedAt is the time of the last Replace or overwriting Upsert as
+	// Unix seconds, zero if the file was never replaced, see ReplaceCoalesced
+	ModifiedAt int64 `json:"modified_at,omitempty"`
 }
 
 // NewContent creates new instance of a content, in case of Directory
@@ -219,8 +376,11 @@ func NewContent(name string, cid string, size int64, t string, createdAt time.Ti
 
 // Validate checks if existing Content eligible to be part of trie node
 func (c *Content) Validate() error {
-	if strings.Contains(c.Name, Separator) || strings.Contains(c.Name, SpecialPathSymbol) {
-		return ErrIllegalNameChars
+	if len(c.Name) != 0 {
+		err := ValidateName(c.Name)
+		if err != nil {
+			return err
+		}
 	}
 
 	if len(c.Type) == 0 {
@@ -230,11 +390,32 @@ func (c *Content) Validate() error {
 	return nil
 }
 
+// SetMetadata sets a single metadata key/value pair on the content
+func (c *Content) SetMetadata(key, value string) {
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]string)
+	}
+	c.Metadata[key] = value
+}
+
 // IsDirectory returns whether a Content is directory or not
 func (c *Content) IsDirectory() bool {
 	return c.Type == MIMEDriveDirectory || c.Type == MIMEDriveEntry
 }
 
+// MarshalJSONRFC3339 encodes c with the same keys as json.Marshal but
+// created_at is a RFC 3339 string in UTC instead of Unix seconds
+func (c *Content) MarshalJSONRFC3339() ([]byte, error) {
+	type content Content
+	return json.Marshal(struct {
+		*content
+		CreatedAt string `json:"created_at"`
+	}{
+		content:   (*content)(c),
+		CreatedAt: time.Unix(c.CreatedAt, 0).UTC().Format(time.RFC3339),
+	})
+}
+
 func directoriesFromContents(path string, contents []*Content) []*Entry {
 	dirs := make([]*Entry, 0)
 	for _, content := range contents {
@@ -247,68 +428,298 @@ func directoriesFromContents(path string, contents []*Content) []*Entry {
 
 func (c *Content) copy() *Content {
 	return &Content{
-		Name:      c.Name,
-		CID:       c.CID,
-		Type:      c.Type,
-		Size:      c.Size,
-		Version:   c.Version,
-		CreatedAt: c.CreatedAt,
+		Name:         c.Name,
+		CID:          c.CID,
+		Type:         c.Type,
+		Size:         c.Size,
+		Version:      c.Version,
+		CreatedAt:    c.CreatedAt,
+		Metadata:     copyMetadata(c.Metadata),
+		DetectedType: c.DetectedType,
+		Checksum:     c.Checksum,
+		RefEntries:   c.RefEntries,
+		ACL:          copyACL(c.ACL),
+		ID:           c.ID,
+		ModifiedAt:   c.ModifiedAt,
+	}
+}
+
+func copyACL(acl []string) []string {
+	if acl == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(acl)), acl...)
+}
+
+func copyMetadata(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
 	}
+	cp := make(map[string]string, len(m))
+	for k, v := range m {
+		cp[k] = v
+	}
+	return cp
 }
 
 // Trie is the structure behind
 type Trie struct {
-	Root *Entry `json:"root"`
-	lock sync.RWMutex
+	Root      *Entry `json:"root"`
+	lock      sync.RWMutex
+	createdAt int64
+	sep       rune
+	listeners []func(op Op, path string, c *Content)
+	pending   []event
+	cache     *statCache
+	// noEmptyDirs see Options.NoEmptyDirs
+	noEmptyDirs bool
+	// keepSorted see Options.KeepSorted
+	keepSorted bool
+	// strictFileAsDir see Options.StrictFileAsDir
+	strictFileAsDir bool
+	// deleteNonEmptyErrors see Options.DeleteNonEmptyErrors
+	deleteNonEmptyErrors bool
+	// idempotentReAdd see Options.IdempotentReAdd
+	idempotentReAdd bool
+	// preserveDestTime see Options.PreserveDestTime
+	preserveDestTime bool
+	// allowedTypes see SetAllowedTypes, nil allows everything
+	allowedTypes map[string]bool
+	// epoch see Epoch
+	epoch uint64
+	// search see BuildSearchIndex, nil until built
+	search *searchIndex
+	// clock see Options.Clock, nil means time.Now
+	clock func() time.Time
+	// namePolicy see Options.NamePolicy
+	namePolicy NamePolicy
+	// maxChildren see Options.MaxChildren
+	maxChildren int
+	// assignIDs see Options.AssignIDs
+	assignIDs bool
+	// pool see Options.InternStrings, nil when disabled
+	pool *stringPool
+	// quota see SetQuota, zero means unlimited
+	quota int64
+	// used is the DiskUsage of root maintained on every change
+	used int64
+	// files and dirs are numbers of entries, see Len
+	files int
+	dirs  int
 }
 
 // NewTrie creates new instance of user's file system trie
 func NewTrie() *Trie {
 	return &Trie{
-		lock: sync.RWMutex{},
+		lock:      sync.RWMutex{},
+		createdAt: time.Now().Unix(),
 	}
 }
 
-// Hash return the hash for the filesystem
+// Hash return the hash for the filesystem. It's a sha256 of the JSON encoded
+// trie, so every node contributes its path label, Name, CID, Type, Size,
+// Version, CreatedAt, Metadata, DetectedType, Checksum, RefEntries, ACL, ID,
+// ModifiedAt and Meta.
+// Any change of those, including CreateRef, Replace, Delete and Move, changes
+// the hash. Siblings are encoded sorted, see MarshalJSON, so tries built in
+// different order hash the same
 func (mt *Trie) Hash() (string, error) {
 	mt.lock.RLock()
 	defer mt.lock.RUnlock()
 
-	buf := new(bytes.Buffer)
-	err := json.NewEncoder(buf).Encode(mt)
+	return mt.hash()
+}
+
+// hash is the lock-free core of Hash.
+// Callers must hold at least a read lock.
+func (mt *Trie) hash() (string, error) {
+	data, err := mt.marshal()
 	if err != nil {
 		return "", err
 	}
 
 	hashFunc := sha256.New()
-	hashFunc.Write(buf.Bytes())
+	hashFunc.Write(data)
 	return fmt.Sprintf("%x", hashFunc.Sum(nil)), nil
 }
 
-// AddFile add new node to the tire
+// AddFile add new node to the tire. With Options.IdempotentReAdd adding a
+// file equal to the existing one changes nothing and returns no entries
 func (mt *Trie) AddFile(m *Entry) ([]*Entry, error) {
 	mt.lock.Lock()
-	defer mt.lock.Unlock()
+	defer mt.unlock()
+
+	if mt.noEmptyDirs && m != nil && m.Type == MIMEDriveEntry {
+		return nil, ErrEmptyDirNotAllowed
+	}
+	if m != nil {
+		err := mt.checkType(m.Type)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if mt.custom() {
+		m = mt.internalEntry(m)
+	}
+	entries, err := mt.addFile(m)
+	if err == nil && len(entries) != 0 {
+		mt.notify(OpCreate, m.Path, nil)
+	}
+	return mt.swapEntries(entries), err
+}
+
+// addFile is the lock-free core of AddFile.
+// Callers must hold the write lock.
+func (mt *Trie) addFile(m *Entry) ([]*Entry, error) {
+	err := mt.prepare(m)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := mt.admit(m)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return make([]*Entry, 0), nil
+	}
+	return mt.insertAt(m.copy(), nil, "")
+}
 
+// prepare stamps, normalizes, validates and sanitizes m before adding it.
+// Callers must hold the write lock.
+func (mt *Trie) prepare(m *Entry) error {
 	if m == nil {
-		return nil, ErrConflict
+		return ErrConflict
 	}
 
+	if zeroTime(m.CreatedAt) {
+		m.CreatedAt = mt.now().Unix()
+	}
+	if mt.assignIDs && len(m.ID) == 0 && !m.IsDirectory() {
+		m.ID = newID()
+	}
+	m.Normalize()
 	err := m.Validate()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if p, err := mt.sanitizePath(m.Path); err != nil {
+		return err
+	} else if p != m.Path {
+		m.Path = p
+		if m.Type != MIMEDriveEntry {
+			m.Name = filepath.Base(p)
+		}
+	}
+	return nil
+}
+
+// admit checks the trie can take m prepared before. Returns false if an
+// equal file is already there and idempotent re-adds are on.
+// Callers must hold the write lock.
+func (mt *Trie) admit(m *Entry) (bool, error) {
+	if mt.idempotentReAdd && mt.Root != nil && m.Type != MIMEDriveEntry {
+		if f := find(m.Path, mt.Root); f != nil && f.Equal(&m.Content) {
+			return false, nil
+		}
+	}
+	err := mt.checkQuota(usage(&m.Content))
+	if err != nil {
+		return false, err
+	}
+	err = mt.checkChildren(m.Path)
+	if err != nil {
+		return false, err
 	}
+	if mt.strictFileAsDir && mt.Root != nil {
+		for dir := filepath.Dir(m.Path); dir != Separator; dir = filepath.Dir(dir) {
+			if f := stat(dir, mt.Root); f != nil && !f.IsDirectory() {
+				return false, ErrNotADirectory
+			}
+		}
+	}
+	return true, nil
+}
 
-	m.Path = CleanPath(m.Path)
+// insertAt adds m admitted before, the trie keeps m itself. The descent
+// starts at the node n found by dirNode for a directory above m, prefix is
+// made of the labels above n. A nil n starts at root.
+// Callers must hold the write lock.
+func (mt *Trie) insertAt(m *Entry, n *Entry, prefix string) ([]*Entry, error) {
+	p, used := m.Path, usage(&m.Content)
+	mt.cache.invalidate(p)
 	if mt.Root == nil {
 		mt.Root = m
-		return mt.lsRecursive("/"), nil
+		mt.pool.path(p, mt.Root)
+		mt.epoch++
+		mt.used += used
+		entries := mt.lsRecursive("/")
+		for _, e := range entries {
+			mt.search.add(e.Path)
+		}
+		mt.counted(entries, 1)
+		return entries, nil
+	}
+	if n == nil {
+		n, prefix = mt.Root, ""
+	}
+	m.Path = p[len(prefix):]
+	entries, err := addTo(n, m)
+	if err != nil {
+		return entries, err
+	}
+	fixEntries(entries, prefix)
+	mt.epoch++
+	mt.used += used
+	for _, e := range entries {
+		mt.search.add(e.Path)
+	}
+	mt.counted(entries, 1)
+	mt.pool.path(p[len(prefix):], n)
+	if mt.keepSorted {
+		sortPath(p[len(prefix):], n)
+	}
+	return entries, nil
+}
+
+// dirNode returns the deepest node reached by the directory path d followed
+// by separator along with the labels above it. Inserts beneath d change such
+// node only in place and leave everything above it intact, so a batch can
+// start every insert there. Returns nil for an empty trie.
+// Callers must hold at least a read lock.
+func (mt *Trie) dirNode(d string) (*Entry, string) {
+	target := d
+	if d != Separator {
+		target += Separator
+	}
+
+	n, prefix := mt.Root, ""
+	for n != nil && strings.HasPrefix(target[len(prefix):], n.Path) && len(prefix)+len(n.Path) < len(target) {
+		next := n.child(firstRune(target[len(prefix)+len(n.Path):]))
+		if next == nil {
+			break
+		}
+		n, prefix = next, prefix+n.Path
+	}
+	return n, prefix
+}
+
+// statAt is stat of the absolute path p starting at the node n found by
+// dirNode, from root when n is nil.
+// Callers must hold at least a read lock.
+func (mt *Trie) statAt(p string, n *Entry, prefix string) *Content {
+	if n == nil {
+		if mt.Root == nil {
+			return nil
+		}
+		return stat(p, mt.Root)
 	}
-	return addTo(mt.Root, m.copy())
+	return stat(p[len(prefix):], n)
 }
 
 // Ls lists passed directory paths. All returned directories are ephemeral
-// they are not part of the trie
+// they are not part of the trie. Entries are ordered by Name using Unicode
+// code point order, a directory goes before a file sharing its name
 func (mt *Trie) Ls(path string) []*Content {
 	mt.lock.RLock()
 	defer mt.lock.RUnlock()
@@ -317,8 +728,82 @@ func (mt *Trie) Ls(path string) []*Content {
 		return []*Content{}
 	}
 
-	p := CleanPath(path)
-	return list(p, mt.Root)
+	p := CleanPath(mt.swap(path))
+	res := list(p, mt.Root)
+	sortContents(res)
+	return mt.swapContents(res)
+}
+
+// LsGrouped is similar to Ls but partitions the children of path into
+// directories, references and other entries like files and symlinks.
+// Each group keeps the Ls order
+func (mt *Trie) LsGrouped(path string) (dirs []*Content, files []*Content, refs []*Content) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	dirs, files, refs = make([]*Content, 0), make([]*Content, 0), make([]*Content, 0)
+	if mt.Root == nil {
+		return dirs, files, refs
+	}
+
+	res := list(CleanPath(mt.swap(path)), mt.Root)
+	sortContents(res)
+	for _, c := range res {
+		switch {
+		case c.IsDirectory():
+			dirs = append(dirs, c)
+		case c.Type == MIMEReference:
+			refs = append(refs, c)
+		default:
+			files = append(files, c)
+		}
+	}
+	return mt.swapContents(dirs), mt.swapContents(files), mt.swapContents(refs)
+}
+
+// AmbiguousNames returns sorted names of children of path which are both a
+// directory and a file or reference. AddFile never creates such names, they
+// can come only with tries decoded from outside
+func (mt *Trie) AmbiguousNames(path string) []string {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	res := make([]string, 0)
+	if mt.Root == nil {
+		return res
+	}
+
+	dirs := make(map[string]bool)
+	files := make(map[string]bool)
+	for _, c := range list(CleanPath(mt.swap(path)), mt.Root) {
+		if c.IsDirectory() {
+			dirs[c.Name] = true
+		} else {
+			files[c.Name] = true
+		}
+	}
+	for name := range files {
+		if dirs[name] {
+			res = append(res, mt.swap(name))
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// sortContents orders contents by Name, then directories first, then by Type.
+// Byte-wise comparison of valid UTF-8 strings matches code point order
+func sortContents(contents []*Content) {
+	sort.SliceStable(contents, func(i, j int) bool {
+		a, b := contents[i], contents[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.IsDirectory() != b.IsDirectory() {
+			return a.IsDirectory()
+		}
+		return a.Type < b.Type
+	})
 }
 
 // Tree returns the complete directory structure of trie.
@@ -326,166 +811,1213 @@ func (mt *Trie) Tree(path string) *Entry {
 	mt.lock.RLock()
 	defer mt.lock.RUnlock()
 
-	p := CleanPath(path)
-	var t *Entry
-	if p == "" {
-		t = NewEntry("/", "", 0, MIMEDriveDirectory, time.Now())
-	} else {
-		t = NewEntry(p, "", 0, MIMEDriveDirectory, time.Now())
+	p := CleanPath(mt.swap(path))
+	t := newTreeRoot(p)
+	if mt.Root == nil {
+		return mt.swapEntry(t)
 	}
 
+	return mt.swapEntry(tree(t, p, mt.Root))
+}
+
+// TreeFiltered is similar to Tree but descends at most maxDepth levels below path
+// and omits directories, along with their subtrees, for which include returns false.
+// Negative maxDepth means unlimited depth, nil include keeps every directory
+func (mt *Trie) TreeFiltered(path string, maxDepth int, include func(*Content) bool) *Entry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	p := CleanPath(mt.swap(path))
+	t := newTreeRoot(p)
 	if mt.Root == nil {
-		return t
+		return mt.swapEntry(t)
 	}
 
-	return tree(t, p, mt.Root)
+	if include != nil && mt.custom() {
+		filter := include
+		include = func(c *Content) bool {
+			return filter(mt.swapContent(c))
+		}
+	}
+	return mt.swapEntry(treeFiltered(t, p, mt.Root, 1, maxDepth, include))
 }
 
-// LsRecursive lists passed directory and sub directory paths.
-// Returned lists contains directories first and then their sub-dir/files.
-// For adding entry from this list traverse it from first to last and for
-// deletion traverse from last to first
-func (mt *Trie) LsRecursive(path string) []*Entry {
+// TreeBudget is similar to Tree but includes at most maxNodes directories,
+// path included, filling the tree level by level, so upper levels are
+// complete before anything deeper is added. Reports whether directories
+// were left out. Included directories always have non-nil Entries
+func (mt *Trie) TreeBudget(path string, maxNodes int) (*Entry, bool) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	p := CleanPath(mt.swap(path))
+	t := newTreeRoot(p)
+	t.Entries = make([]*Entry, 0)
+	if mt.Root == nil {
+		return mt.swapEntry(t), false
+	}
+
+	type item struct {
+		dir  *Entry
+		path string
+	}
+	nodes := 1
+	queue := []item{{dir: t, path: p}}
+	for len(queue) != 0 {
+		it := queue[0]
+		queue = queue[1:]
+		for _, d := range directoriesFromContents(it.path, list(it.path, mt.Root)) {
+			if nodes >= maxNodes {
+				return mt.swapEntry(t), true
+			}
+			nodes++
+			d.Entries = make([]*Entry, 0)
+			it.dir.Entries = append(it.dir.Entries, d)
+			queue = append(queue, item{dir: d, path: JoinPath(it.path, d.Name)})
+		}
+	}
+	return mt.swapEntry(t), false
+}
+
+// TreeAll is similar to Tree but also includes files, references and other
+// non directory entries as leaves. Leaves carry their original Content and
+// nil Entries, while directories always have non-nil Entries
+func (mt *Trie) TreeAll(path string) *Entry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	p := CleanPath(mt.swap(path))
+	t := newTreeRoot(p)
+	if mt.Root == nil {
+		t.Entries = make([]*Entry, 0)
+		return mt.swapEntry(t)
+	}
+
+	return mt.swapEntry(treeAll(t, p, mt.Root))
+}
+
+// ToMap returns the directory at path as nested maps for templates and
+// clients which can't use the package types. Directories map child names to
+// their maps, files become maps with name, cid, size and type keys. When a
+// file and a directory share a name only the directory is kept
+func (mt *Trie) ToMap(path string) map[string]any {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	res := make(map[string]any)
+	if mt.Root == nil {
+		return res
+	}
+
+	p := CleanPath(mt.swap(path))
+	if len(p) == 0 {
+		p = Separator
+	}
+	mt.toMap(p, res)
+	return res
+}
+
+// toMap fills res with children of path.
+// Callers must hold at least a read lock.
+func (mt *Trie) toMap(path string, res map[string]any) {
+	for _, c := range list(path, mt.Root) {
+		cnt := mt.swapContent(c)
+		if c.IsDirectory() {
+			sub := make(map[string]any)
+			mt.toMap(JoinPath(path, c.Name), sub)
+			res[cnt.Name] = sub
+			continue
+		}
+		if _, ok := res[cnt.Name]; ok {
+			continue
+		}
+		res[cnt.Name] = map[string]any{
+			"name": cnt.Name,
+			"cid":  cnt.CID,
+			"size": cnt.Size,
+			"type": cnt.Type,
+		}
+	}
+}
+
+// Node returns a deep copy of the stored node whose full path, the labels
+// from root down to it joined, equals path. Its Path is the internal label,
+// e.g. "b" under "/a/", and Entries hold children as stored, markers
+// included. Returns false when no node ends at path, like for directories
+// implied by a longer label
+func (mt *Trie) Node(path string) (*Entry, bool) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if len(path) == 0 || mt.Root == nil {
+		return nil, false
+	}
+	e := node(CleanPath(mt.swap(path)), mt.Root)
+	if e == nil {
+		return nil, false
+	}
+	return mt.swapEntry(e.copy()), true
+}
+
+// node finds the node ending at the rest of path below subtrie
+func node(path string, subtrie *Entry) *Entry {
+	for {
+		if !strings.HasPrefix(path, subtrie.Path) {
+			return nil
+		}
+		path = path[len(subtrie.Path):]
+		if len(path) == 0 {
+			return subtrie
+		}
+		subtrie = subtrie.child(firstRune(path))
+		if subtrie == nil {
+			return nil
+		}
+	}
+}
+
+// Subtree returns the directory at path as a new trie independent of this
+// one, paths are re-based so the directory becomes its root. The new trie
+// has the same separator and options. Returns ErrFileNotExist for a missing
+// path and ErrNotADirectory when path points to a file
+func (mt *Trie) Subtree(path string) (*Trie, error) {
 	mt.lock.RLock()
 	defer mt.lock.RUnlock()
 
-	return mt.lsRecursive(path)
+	if len(path) == 0 {
+		return nil, ErrEmptyPath
+	}
+	p := CleanPath(mt.swap(path))
+	err := mt.checkDir(p)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &Trie{
+		lock:                 sync.RWMutex{},
+		createdAt:            mt.createdAt,
+		sep:                  mt.sep,
+		noEmptyDirs:          mt.noEmptyDirs,
+		keepSorted:           mt.keepSorted,
+		strictFileAsDir:      mt.strictFileAsDir,
+		deleteNonEmptyErrors: mt.deleteNonEmptyErrors,
+		idempotentReAdd:      mt.idempotentReAdd,
+		preserveDestTime:     mt.preserveDestTime,
+		clock:                mt.clock,
+		namePolicy:           mt.namePolicy,
+		maxChildren:          mt.maxChildren,
+		assignIDs:            mt.assignIDs,
+		pool:                 newStringPool(mt.pool != nil),
+		allowedTypes:         mt.allowedTypes,
+		quota:                mt.quota,
+	}
+	if mt.cache != nil {
+		sub.cache = newStatCache(mt.cache.size)
+	}
+	if p == Separator {
+		if mt.Root != nil {
+			sub.Root = mt.Root.copy()
+		}
+		sub.used, sub.files, sub.dirs = mt.used, mt.files, mt.dirs
+		return sub, nil
+	}
+
+	// the first entry is the directory itself
+	entries := mt.subtree(p)
+	sub.createdAt = entries[0].CreatedAt
+	for _, e := range entries[1:] {
+		var m *Entry
+		if e.IsDirectory() {
+			m = NewEntry(JoinPath(Separator, e.Path), "", 0, MIMEDriveEntry, time.Unix(e.CreatedAt, 0))
+		} else {
+			m = &Entry{Content: *e.Content.copy(), Path: JoinPath(Separator, e.Path)}
+		}
+		_, err := sub.addFile(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
 }
 
-// lsRecursive is the lock-free core of LsRecursive.
-// Callers must hold at least a read lock.
-func (mt *Trie) lsRecursive(path string) []*Entry {
+// Mount adds all entries of sub under path, re-basing them so the root of
+// sub becomes path, which is the counterpart of Subtree. Missing parents of
+// path are created. Directories of both tries are merged, while any other
+// entry of sub meeting an existing one at the same path returns ErrConflict
+// and nothing is added. Empty directories of sub are skipped with
+// Options.NoEmptyDirs
+func (mt *Trie) Mount(path string, sub *Trie) error {
+	if len(path) == 0 {
+		return ErrEmptyPath
+	}
+	if sub == nil {
+		return ErrFileNotExist
+	}
+
+	// sub is read before locking the trie, so mounting a trie into itself
+	// doesn't deadlock
+	sub.lock.RLock()
+	entries := sub.lsRecursive(Separator)
+	sub.lock.RUnlock()
+
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	p := CleanPath(mt.swap(path))
+	batch := make([]*Entry, 0, len(entries)+1)
+	if len(entries) == 0 && p != Separator && !mt.noEmptyDirs {
+		batch = append(batch, NewEntry(p, "", 0, MIMEDriveEntry, mt.now()))
+	}
+	for _, e := range entries {
+		if e.IsDirectory() && mt.noEmptyDirs {
+			continue
+		}
+		if e.IsDirectory() {
+			batch = append(batch, NewEntry(JoinPath(p, e.Path), "", 0, MIMEDriveEntry, time.Unix(e.CreatedAt, 0)))
+			continue
+		}
+		batch = append(batch, &Entry{Content: *e.Content.copy(), Path: JoinPath(p, e.Path)})
+	}
+
+	for dir := p; dir != Separator; dir = filepath.Dir(dir) {
+		if mt.Root == nil {
+			break
+		}
+		if c := stat(dir, mt.Root); c != nil && !c.IsDirectory() {
+			return ErrConflict
+		}
+	}
+	added := make([]*Entry, 0, len(batch))
+	for _, m := range batch {
+		if mt.Root != nil {
+			if c := stat(m.Path, mt.Root); c != nil {
+				if !c.IsDirectory() || m.Type != MIMEDriveEntry {
+					return ErrConflict
+				}
+				continue
+			}
+		}
+		added = append(added, m)
+	}
+
+	rollback := mt.savepoint()
+	for _, m := range added {
+		_, err := mt.addFile(m)
+		if err != nil {
+			rollback()
+			return err
+		}
+	}
+	for _, m := range added {
+		mt.notify(OpCreate, m.Path, nil)
+	}
+	return nil
+}
+
+func newTreeRoot(path string) *Entry {
+	if path == "" {
+		return NewEntry(Separator, "", 0, MIMEDriveDirectory, time.Now())
+	}
+	return NewEntry(path, "", 0, MIMEDriveDirectory, time.Now())
+}
+
+// ReadDir is similar to Ls but returns children as entries carrying absolute paths.
+// Unlike Ls it returns ErrFileNotExist for a missing path and ErrNotADirectory
+// when path points to a file, so an empty directory yields an empty slice and nil error
+func (mt *Trie) ReadDir(path string) ([]*Entry, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if len(path) == 0 {
+		return nil, ErrEmptyPath
+	}
+
+	p := CleanPath(mt.swap(path))
+	contents := make([]*Content, 0)
+	if mt.Root != nil {
+		contents = list(p, mt.Root)
+	}
+
+	if p != Separator && len(contents) == 0 {
+		if mt.Root == nil {
+			return nil, ErrFileNotExist
+		}
+		f := stat(p, mt.Root)
+		if f == nil {
+			return nil, ErrFileNotExist
+		}
+		if !f.IsDirectory() {
+			return nil, ErrNotADirectory
+		}
+	}
+
+	sortContents(contents)
+	entries := make([]*Entry, len(contents))
+	for i, c := range contents {
+		entries[i] = &Entry{Content: *c.copy(), Path: JoinPath(p, c.Name)}
+	}
+	return mt.swapEntries(entries), nil
+}
+
+// LsAtDepth returns entries exactly depth levels below path carrying absolute
+// paths, depth 1 returns the children like ReadDir does. Children of every
+// directory are ordered like in Ls. Missing path or depth below 1 yield an
+// empty slice
+func (mt *Trie) LsAtDepth(path string, depth int) []*Entry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	res := make([]*Entry, 0)
+	if mt.Root == nil || depth < 1 {
+		return res
+	}
+	return mt.swapEntries(lsAtDepth(CleanPath(mt.swap(path)), depth, mt.Root, res))
+}
+
+func lsAtDepth(path string, depth int, subtrie *Entry, res []*Entry) []*Entry {
+	contents := list(path, subtrie)
+	sortContents(contents)
+	for _, c := range contents {
+		p := JoinPath(path, c.Name)
+		if depth == 1 {
+			res = append(res, &Entry{Content: *c.copy(), Path: p})
+			continue
+		}
+		if c.IsDirectory() {
+			res = lsAtDepth(p, depth-1, subtrie, res)
+		}
+	}
+	return res
+}
+
+// LsRecursive lists passed directory and sub directory paths.
+// Returned lists contains directories first and then their sub-dir/files.
+// For adding entry from this list traverse it from first to last and for
+// deletion traverse from last to first
+func (mt *Trie) LsRecursive(path string) []*Entry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	return mt.swapEntries(mt.lsRecursive(mt.swap(path)))
+}
+
+// LsRecursiveSorted is similar to LsRecursive but compares paths segment by
+// segment, so every directory is directly followed by its subtree, e.g.
+// "/a", "/a/b", "/a b" instead of "/a", "/a b", "/a/b". The order depends
+// only on the paths, not on how the trie was built
+func (mt *Trie) LsRecursiveSorted(path string) []*Entry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	entries := mt.swapEntries(mt.lsRecursive(mt.swap(path)))
+	sep := string(mt.Separator())
+	sort.SliceStable(entries, func(i, j int) bool {
+		return segmentsLess(entries[i].Path, entries[j].Path, sep)
+	})
+	return entries
+}
+
+// segmentsLess compares paths split by sep segment by segment
+func segmentsLess(a, b string, sep string) bool {
+	as, bs := strings.Split(a, sep), strings.Split(b, sep)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// LsFiles is similar to LsRecursive but returns only non directory entries
+// (files and references) preserving LsRecursive ordering and relative paths
+func (mt *Trie) LsFiles(path string) []*Entry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	return mt.swapEntries(filterEntries(mt.lsRecursive(mt.swap(path)), func(e *Entry) bool {
+		return !e.IsDirectory()
+	}))
+}
+
+// LsDirs is similar to LsRecursive but returns only directories
+// preserving LsRecursive ordering and relative paths
+func (mt *Trie) LsDirs(path string) []*Entry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	return mt.swapEntries(filterEntries(mt.lsRecursive(mt.swap(path)), func(e *Entry) bool {
+		return e.IsDirectory()
+	}))
+}
+
+// ListSince returns entries created at or after since with absolute paths
+// sorted by path. CreatedAt is stored in Unix seconds, so since is compared
+// at second granularity
+func (mt *Trie) ListSince(since time.Time) []*Entry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if mt.Root == nil {
+		return []*Entry{}
+	}
+
+	ts := since.Unix()
+	return mt.swapEntries(filterEntries(mt.lsRecursive(Separator), func(e *Entry) bool {
+		return e.CreatedAt >= ts
+	}))
+}
+
+// Filter returns entries of the whole trie for which pred returns true with
+// absolute paths sorted by path. Every entry is visited, only matching ones
+// are copied, so c passed to pred must not be modified or retained
+func (mt *Trie) Filter(pred func(path string, c *Content) bool) []*Entry {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if mt.Root == nil {
+		return []*Entry{}
+	}
+
+	res := mt.filter(Separator, pred, make([]*Entry, 0))
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Path < res[j].Path
+	})
+	return mt.swapEntries(res)
+}
+
+// filter appends matching entries below dir to res.
+// Callers must hold at least a read lock.
+func (mt *Trie) filter(dir string, pred func(string, *Content) bool, res []*Entry) []*Entry {
+	for _, c := range list(dir, mt.Root) {
+		p := JoinPath(dir, c.Name)
+		if pred(mt.swap(p), mt.swapContent(c)) {
+			res = append(res, &Entry{Content: *c.copy(), Path: p})
+		}
+		if c.Type == MIMEDriveDirectory {
+			res = mt.filter(p, pred, res)
+		}
+	}
+	return res
+}
+
+func filterEntries(entries []*Entry, keep func(*Entry) bool) []*Entry {
+	res := make([]*Entry, 0, len(entries))
+	for _, e := range entries {
+		if keep(e) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Paths returns absolute paths of all files and directories
+// sorted lexicographically
+func (mt *Trie) Paths() []string {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	set := make(map[string]struct{})
+	if mt.Root != nil {
+		collectPaths("", mt.Root, set)
+	}
+
+	res := make([]string, 0, len(set))
+	for p := range set {
+		res = append(res, p)
+	}
+	sort.Strings(res)
+	return mt.swapPaths(res)
+}
+
+// collectPaths adds every entry under subtrie to set together
+// with all its parent directories
+func collectPaths(prefix string, subtrie *Entry, set map[string]struct{}) {
+	if subtrie.Path == SpecialPathSymbol {
+		addWithParents(prefix, set)
+		return
+	}
+
+	prefix += subtrie.Path
+	if len(subtrie.Entries) == 0 {
+		addWithParents(prefix, set)
+		return
+	}
+
+	for _, me := range subtrie.Entries {
+		collectPaths(prefix, me, set)
+	}
+}
+
+func addWithParents(p string, set map[string]struct{}) {
+	for len(p) > 1 {
+		if _, ok := set[p]; ok {
+			return
+		}
+		set[p] = struct{}{}
+		p = p[:strings.LastIndex(p, Separator)]
+	}
+}
+
+// lsRecursive is the lock-free core of LsRecursive.
+// Callers must hold at least a read lock.
+func (mt *Trie) lsRecursive(path string) []*Entry {
+	if mt.Root == nil {
+		return []*Entry{}
+	}
+
+	p := CleanPath(path)
+	entries := listRecursive(p, p, mt.Root)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
+
+// File gets associated metadata by path
+// returns data for existing file and empty folder
+func (mt *Trie) File(path string) (*Content, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	return mt.file(path)
+}
+
+// FileMany is File of every path under a single lock. Returns contents and
+// errors aligned with paths, a missing path gets nil content and
+// ErrFileNotExist, an empty one ErrEmptyPath
+func (mt *Trie) FileMany(paths []string) ([]*Content, []error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	contents, errs := make([]*Content, len(paths)), make([]error, len(paths))
+	for i, p := range paths {
+		contents[i], errs[i] = mt.file(p)
+	}
+	return contents, errs
+}
+
+// file is the lock-free core of File.
+// Callers must hold at least a read lock.
+func (mt *Trie) file(path string) (*Content, error) {
+	if len(path) == 0 {
+		return nil, ErrEmptyPath
+	}
+
+	if mt.Root == nil {
+		return nil, ErrFileNotExist
+	}
+
+	p := CleanPath(mt.swap(path))
+	if c := mt.cache.get(p, false); c != nil {
+		return mt.swapContent(c), nil
+	}
+	f := find(p, mt.Root)
+	if f == nil {
+		return nil, ErrFileNotExist
+	}
+
+	mt.cache.put(p, false, f)
+	return mt.swapContent(f.copy()), nil
+}
+
+// Stat is similar to File. In addition, it also  returns non-empty directory.
+// For root it returns a synthetic directory named "/" created at the time
+// of the earliest entry, or of the trie itself when it is empty
+func (mt *Trie) Stat(path string) (*Content, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if len(path) == 0 {
+		return nil, ErrEmptyPath
+	}
+
+	c, err := mt.statPath(CleanPath(mt.swap(path)))
+	if err != nil {
+		return nil, err
+	}
+	return mt.swapContent(c), nil
+}
+
+// StatDeep is similar to Stat but for directories, root included, sets Size
+// to the DiskUsage of their subtree. Files are returned exactly like Stat
+func (mt *Trie) StatDeep(path string) (*Content, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if len(path) == 0 {
+		return nil, ErrEmptyPath
+	}
+
+	p := CleanPath(mt.swap(path))
+	c, err := mt.statPath(p)
+	if err != nil {
+		return nil, err
+	}
+	if c.IsDirectory() {
+		c.Size = mt.diskUsage(p)
+	}
+	return mt.swapContent(c), nil
+}
+
+// DiskUsage returns total Size of files under the directory at path, shared
+// CIDs are counted every time unlike UniqueSize. References have no size of
+// their own. Returns ErrFileNotExist for a missing path and the file size
+// when path points to a file
+func (mt *Trie) DiskUsage(path string) (int64, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if len(path) == 0 {
+		return 0, ErrEmptyPath
+	}
+
+	p := CleanPath(mt.swap(path))
+	c, err := mt.statPath(p)
+	if err != nil {
+		return 0, err
+	}
+	if !c.IsDirectory() {
+		if c.Type == MIMEReference {
+			return 0, nil
+		}
+		return c.Size, nil
+	}
+	return mt.diskUsage(p), nil
+}
+
+// statPath returns a copy of content at the cleaned internal path p named
+// after its base.
+// Callers must hold at least a read lock.
+func (mt *Trie) statPath(p string) (*Content, error) {
+	if p == Separator {
+		return mt.rootContent(), nil
+	}
+
+	if mt.Root == nil {
+		return nil, ErrFileNotExist
+	}
+
+	if c := mt.cache.get(p, true); c != nil {
+		return c, nil
+	}
+	f := stat(p, mt.Root)
+	if f == nil {
+		return nil, ErrFileNotExist
+	}
+
+	name := filepath.Base(p)
+	cnt := f.copy()
+	cnt.Name = name
+	mt.cache.put(p, true, cnt)
+	return cnt, nil
+}
+
+// diskUsage sums sizes of files under the directory at internal path p.
+// Callers must hold at least a read lock.
+func (mt *Trie) diskUsage(p string) int64 {
+	var size int64
+	for _, e := range mt.lsRecursive(p) {
+		if !e.IsDirectory() && e.Type != MIMEReference {
+			size += e.Size
+		}
+	}
+	return size
+}
+
+// Ancestors returns directories above path ordered from the top level down,
+// root isn't included. Returns ErrFileNotExist if path or any of its
+// ancestors is missing
+func (mt *Trie) Ancestors(path string) ([]*Content, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if len(path) == 0 {
+		return nil, ErrEmptyPath
+	}
+
+	p := CleanPath(mt.swap(path))
+	if p == Separator {
+		return []*Content{}, nil
+	}
+	if mt.content(p) == nil {
+		return nil, ErrFileNotExist
+	}
+
+	dirs := make([]string, 0)
+	for dir := filepath.Dir(p); dir != Separator; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	res := make([]*Content, 0, len(dirs))
+	for i := len(dirs) - 1; i >= 0; i-- {
+		c := mt.content(dirs[i])
+		if c == nil || !c.IsDirectory() {
+			return nil, ErrFileNotExist
+		}
+		res = append(res, mt.swapContent(c))
+	}
+	return res, nil
+}
+
+// NearestAncestor returns the deepest existing entry along path with its
+// content and whether path itself exists. If nothing along path exists it
+// returns root and its synthetic directory, see Stat
+func (mt *Trie) NearestAncestor(path string) (string, *Content, bool) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	p := CleanPath(mt.swap(path))
+	if len(p) == 0 {
+		return mt.swap(Separator), mt.swapContent(mt.rootContent()), false
+	}
+	for dir := p; dir != Separator; dir = filepath.Dir(dir) {
+		c := mt.content(dir)
+		if c != nil {
+			return mt.swap(dir), mt.swapContent(c), dir == p
+		}
+	}
+	return mt.swap(Separator), mt.swapContent(mt.rootContent()), p == Separator
+}
+
+// rootContent returns synthetic directory content describing the root.
+// Callers must hold at least a read lock.
+func (mt *Trie) rootContent() *Content {
+	createdAt := mt.createdAt
+	if mt.Root != nil {
+		createdAt = earliest(mt.Root)
+	}
+	cnt := NewContent(Separator, "", 0, MIMEDriveDirectory, time.Unix(createdAt, 0))
+	return &cnt
+}
+
+func earliest(subtrie *Entry) int64 {
+	res := subtrie.CreatedAt
+	for _, me := range subtrie.Entries {
+		if t := earliest(me); t < res {
+			res = t
+		}
+	}
+	return res
+}
+
+// Replace replaces contents of a path. Metadata and ACL of the stored content
+// are replaced only when cnt carries non-nil ones.
+func (mt *Trie) Replace(path string, cnt *Content) (*Content, *Content, error) {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if len(path) == 0 {
+		return nil, nil, ErrEmptyPath
+	}
+
+	if mt.Root == nil {
+		return nil, nil, ErrFileNotExist
+	}
+
+	p := CleanPath(mt.swap(path))
+	f := find(p, mt.Root)
+	if f == nil {
+		return nil, nil, ErrFileNotExist
+	}
+	old, err := mt.replace(p, f, cnt, f.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+	mt.notify(OpUpdate, p, nil)
+	return cnt.copy(), old, nil
+}
+
+// ReplaceIfCID replaces contents of the file at path like Replace, but only
+// while its CID is expectedCID, otherwise ErrCIDMismatch is returned and
+// nothing changes. Version is bumped on success like by Upsert
+func (mt *Trie) ReplaceIfCID(path, expectedCID string, c *Content) error {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if len(path) == 0 {
+		return ErrEmptyPath
+	}
 	if mt.Root == nil {
-		return []*Entry{}
+		return ErrFileNotExist
 	}
 
-	p := CleanPath(path)
-	entries := listRecursive(p, p, mt.Root)
-	sort.SliceStable(entries, func(i, j int) bool {
-		return entries[i].Path < entries[j].Path
-	})
-	return entries
+	p := CleanPath(mt.swap(path))
+	f := find(p, mt.Root)
+	if f == nil || f.IsDirectory() {
+		return ErrFileNotExist
+	}
+	if f.CID != expectedCID {
+		return ErrCIDMismatch
+	}
+	_, err := mt.replace(p, f, c, f.Type)
+	if err != nil {
+		return err
+	}
+	if f.Version < math.MaxUint8 {
+		f.Version++
+	}
+	mt.notify(OpUpdate, p, nil)
+	return nil
 }
 
-// File gets associated metadata by path
-// returns data for existing file and empty folder
-func (mt *Trie) File(path string) (*Content, error) {
-	mt.lock.RLock()
-	defer mt.lock.RUnlock()
+// ReplaceCoalesced replaces contents of the file at path like Replace and
+// returns the stored content. When the previous Replace of the file happened
+// within the given duration, the change is taken as part of the same edit
+// and Version stays, otherwise Version is bumped like by Upsert. Reports
+// whether the change was coalesced. Times are compared to the second, see
+// Content.ModifiedAt
+func (mt *Trie) ReplaceCoalesced(path string, c *Content, within time.Duration) (*Content, bool, error) {
+	mt.lock.Lock()
+	defer mt.unlock()
 
 	if len(path) == 0 {
-		return nil, ErrEmptyPath
+		return nil, false, ErrEmptyPath
 	}
-
 	if mt.Root == nil {
-		return nil, ErrFileNotExist
+		return nil, false, ErrFileNotExist
 	}
 
-	p := CleanPath(path)
+	p := CleanPath(mt.swap(path))
 	f := find(p, mt.Root)
-	if f == nil {
-		return nil, ErrFileNotExist
+	if f == nil || f.IsDirectory() {
+		return nil, false, ErrFileNotExist
 	}
+	coalesced := f.ModifiedAt != 0 && mt.now().Sub(time.Unix(f.ModifiedAt, 0)) <= within
+	_, err := mt.replace(p, f, c, f.Type)
+	if err != nil {
+		return nil, false, err
+	}
+	if !coalesced && f.Version < math.MaxUint8 {
+		f.Version++
+	}
+	mt.notify(OpUpdate, p, nil)
+	return mt.swapContent(f.copy()), coalesced, nil
+}
 
-	return f.copy(), nil
+// replace copies cnt over f stored at p, f gets type typ, and returns a copy
+// of the previous content, see Replace.
+// Callers must hold the write lock.
+func (mt *Trie) replace(p string, f *Content, cnt *Content, typ string) (*Content, error) {
+	delta := usage(&Content{Type: typ, Size: cnt.Size}) - usage(f)
+	err := mt.checkQuota(delta)
+	if err != nil {
+		return nil, err
+	}
+	mt.cache.invalidate(p)
+	mt.epoch++
+	mt.used += delta
+	old := mt.swapContent(f.copy())
+	f.CID = cnt.CID
+	f.Size = cnt.Size
+	f.Type = typ
+	f.CreatedAt = cnt.CreatedAt
+	if zeroTime(f.CreatedAt) {
+		f.CreatedAt = mt.now().Unix()
+	}
+	f.ModifiedAt = mt.now().Unix()
+	f.Checksum = cnt.Checksum
+	if cnt.Metadata != nil {
+		f.Metadata = copyMetadata(cnt.Metadata)
+	}
+	if cnt.ACL != nil {
+		f.ACL = copyACL(cnt.ACL)
+	}
+	return old.copy(), nil
 }
 
-// Stat is similar to File. In addition, it also  returns non-empty directory
-func (mt *Trie) Stat(path string) (*Content, error) {
-	mt.lock.RLock()
-	defer mt.lock.RUnlock()
+// Upsert replaces content of the file at path bumping its Version, or creates
+// the file with missing parent directories. Returns whether the file was
+// created. Directories can't be overwritten and return ErrConflict. The
+// overwritten file takes CreatedAt of c, see Options.PreserveDestTime
+func (mt *Trie) Upsert(path string, c *Content) (bool, error) {
+	mt.lock.Lock()
+	defer mt.unlock()
 
 	if len(path) == 0 {
-		return nil, ErrEmptyPath
+		return false, ErrEmptyPath
+	}
+	if c == nil || c.IsDirectory() {
+		return false, ErrCantAddDirectory
 	}
 
-	if mt.Root == nil || path == Separator {
-		return nil, ErrFileNotExist
+	p := CleanPath(mt.swap(path))
+	if p == Separator {
+		return false, ErrConflict
+	}
+	cnt := mt.swapContent(c)
+
+	var f *Content
+	if mt.Root != nil {
+		f = find(p, mt.Root)
+		if f == nil && stat(p, mt.Root) != nil || f != nil && f.IsDirectory() {
+			return false, ErrConflict
+		}
+	}
+
+	// overwrite without type keeps the current one
+	if f == nil || len(cnt.Type) != 0 {
+		err := mt.checkType(cnt.Type)
+		if err != nil {
+			return false, err
+		}
 	}
 
-	p := CleanPath(path)
-	f := stat(p, mt.Root)
 	if f == nil {
-		return nil, ErrFileNotExist
+		m := NewEntry(p, cnt.CID, cnt.Size, cnt.Type, time.Unix(cnt.CreatedAt, 0))
+		m.Metadata = copyMetadata(cnt.Metadata)
+		m.Checksum = cnt.Checksum
+		m.ACL = copyACL(cnt.ACL)
+		_, err := mt.addFile(m)
+		if err != nil {
+			return false, err
+		}
+		mt.notify(OpCreate, p, nil)
+		return true, nil
 	}
 
-	name := filepath.Base(path)
-	cnt := f.copy()
-	cnt.Name = name
-	return cnt, nil
+	// overwrite without type keeps the current one
+	typ := f.Type
+	if len(cnt.Type) != 0 {
+		typ = cnt.Type
+	}
+	delta := usage(&Content{Type: typ, Size: cnt.Size}) - usage(f)
+	err := mt.checkQuota(delta)
+	if err != nil {
+		return false, err
+	}
+	mt.cache.invalidate(p)
+	mt.epoch++
+	mt.used += delta
+	f.CID = cnt.CID
+	f.Size = cnt.Size
+	f.Type = typ
+	if !mt.preserveDestTime {
+		f.CreatedAt = cnt.CreatedAt
+		if zeroTime(f.CreatedAt) {
+			f.CreatedAt = mt.now().Unix()
+		}
+	}
+	f.ModifiedAt = mt.now().Unix()
+	f.Metadata = copyMetadata(cnt.Metadata)
+	f.Checksum = cnt.Checksum
+	f.ACL = copyACL(cnt.ACL)
+	if f.Version < math.MaxUint8 {
+		f.Version++
+	}
+	mt.notify(OpUpdate, p, nil)
+	return false, nil
 }
 
-// Replace replaces contents of a path.
-func (mt *Trie) Replace(path string, cnt *Content) (*Content, *Content, error) {
+// Delete deletes associated file system entry by path. Directories having
+// entries are left untouched, see DeleteAll
+func (mt *Trie) Delete(path string) error {
 	mt.lock.Lock()
-	defer mt.lock.Unlock()
+	defer mt.unlock()
 
 	if len(path) == 0 {
-		return nil, nil, ErrEmptyPath
+		return ErrEmptyPath
 	}
 
-	if mt.Root == nil {
-		return nil, nil, ErrFileNotExist
+	p := CleanPath(mt.swap(path))
+	if mt.deleteNonEmptyErrors && mt.nonEmptyDir(p) {
+		return ErrDirNotEmpty
+	}
+	if !mt.observed() {
+		mt.remove(p)
+		return nil
 	}
 
-	p := CleanPath(path)
-	f := find(p, mt.Root)
-	if f == nil {
-		return nil, nil, ErrFileNotExist
+	old := mt.content(p)
+	mt.remove(p)
+	if old != nil && mt.content(p) == nil {
+		mt.notify(OpRemove, p, old)
 	}
-	old := f.copy()
-	f.CID = cnt.CID
-	f.Size = cnt.Size
-	f.CreatedAt = cnt.CreatedAt
-	return cnt.copy(), old.copy(), nil
+	return nil
 }
 
-// Delete deletes associated file system entry by path
-func (mt *Trie) Delete(path string) error {
+// DeleteAll deletes the entry at path along with everything under it,
+// deleting root empties the trie. Missing paths are ignored like by Delete
+func (mt *Trie) DeleteAll(path string) error {
 	mt.lock.Lock()
-	defer mt.lock.Unlock()
+	defer mt.unlock()
 
 	if len(path) == 0 {
 		return ErrEmptyPath
 	}
 
-	if mt.Root == nil {
+	p := CleanPath(mt.swap(path))
+	old := mt.content(p)
+	entries := mt.lsRecursive(p)
+	for i := len(entries) - 1; i >= 0; i-- {
+		mt.remove(JoinPath(p, entries[i].Path))
+	}
+	if p != Separator {
+		mt.remove(p)
+	}
+	if old != nil && mt.content(p) == nil {
+		mt.notify(OpRemove, p, old)
+	}
+	return nil
+}
+
+// checkChildren returns ErrTooManyChildren if adding cleaned path gives a
+// directory more than Options.MaxChildren entries. Only the deepest existing
+// ancestor gains an entry, directories below it are new.
+// Callers must hold at least a read lock.
+func (mt *Trie) checkChildren(path string) error {
+	if mt.maxChildren <= 0 || mt.Root == nil || stat(path, mt.Root) != nil {
 		return nil
 	}
+	dir := filepath.Dir(path)
+	for dir != Separator && stat(dir, mt.Root) == nil {
+		dir = filepath.Dir(dir)
+	}
+	if len(list(dir, mt.Root)) >= mt.maxChildren {
+		return ErrTooManyChildren
+	}
+	return nil
+}
+
+// nonEmptyDir checks if there is a directory with entries at cleaned path
+// and no file Delete would remove instead.
+// Callers must hold at least a read lock.
+func (mt *Trie) nonEmptyDir(path string) bool {
+	if mt.Root == nil {
+		return false
+	}
+	if path != Separator && find(path, mt.Root) != nil {
+		return false
+	}
+	return len(list(path, mt.Root)) != 0
+}
 
-	p := CleanPath(path)
-	item := rm(p, mt.Root)
+// remove deletes entry by cleaned path.
+// Callers must hold the write lock.
+func (mt *Trie) remove(path string) {
+	if mt.Root == nil || !strings.HasPrefix(path, mt.Root.Path) {
+		return
+	}
+
+	mt.cache.invalidate(path)
+	var old *Content
+	if f := find(path, mt.Root); f != nil {
+		mt.epoch++
+		old = f.copy()
+	}
+	item := rm(path, mt.Root)
 	if item != nil {
 		mt.Root = nil
 	}
+	if old != nil && (mt.Root == nil || find(path, mt.Root) == nil) {
+		mt.used -= usage(old)
+		mt.counted([]*Entry{{Content: *old}}, -1)
+	}
+	if mt.keepSorted && mt.Root != nil {
+		sortPath(path, mt.Root)
+	}
+	mt.unindexRemoved(path)
+	if mt.noEmptyDirs {
+		mt.prune(filepath.Dir(path))
+	}
+}
 
-	return nil
+// prune removes dir if it's left empty, which in turn prunes its parent.
+// Callers must hold the write lock.
+func (mt *Trie) prune(dir string) {
+	if mt.Root == nil || dir == Separator {
+		return
+	}
+	f := find(dir, mt.Root)
+	if f != nil && f.IsDirectory() {
+		mt.remove(dir)
+	}
 }
 
 // CreateRef creates ref for file
 func (mt *Trie) CreateRef(path string, bucketID string, createdAt time.Time) ([]*Entry, error) {
+	return mt.CreateRefWithSize(path, bucketID, createdAt, false)
+}
+
+// CreateRefWithSize is similar to CreateRef but when keepSize is set the
+// reference keeps the total Size of replaced files, nested references
+// included, and the number of replaced entries in RefEntries. Otherwise
+// the reference has zero Size like with CreateRef
+func (mt *Trie) CreateRefWithSize(path string, bucketID string, createdAt time.Time, keepSize bool) ([]*Entry, error) {
 	mt.lock.Lock()
 	defer mt.lock.Unlock()
 
 	if len(path) == 0 {
 		return nil, ErrEmptyPath
 	}
-	if path == Separator {
+	if mt.swap(path) == Separator {
 		return nil, ErrCantCreateRef
 	}
 	if mt.Root == nil {
 		return nil, ErrFileNotExist
 	}
 
-	p := CleanPath(path)
-	entries, err := createRef(p, bucketID, mt, createdAt)
+	if createdAt.IsZero() {
+		createdAt = mt.now()
+	}
+	p := CleanPath(mt.swap(path))
+	mt.cache.invalidate(p)
+	used, files, dirs := mt.count(p)
+	entries, err := createRef(p, bucketID, mt, createdAt, keepSize)
 	if err != nil {
 		return nil, err
 	}
-	return entries, nil
+	mt.epoch++
+	u, f, d := mt.count(p)
+	mt.used, mt.files, mt.dirs = mt.used+u-used, mt.files+f-files, mt.dirs+d-dirs
+	for _, e := range entries {
+		mt.search.drop(e.Path)
+	}
+	mt.search.add(p)
+	if mt.keepSorted {
+		sortPath(p, mt.Root)
+	}
+	return mt.swapEntries(entries), nil
+}
+
+// ResolveLink follows the symlink at path until a non link entry is reached
+// and returns its path and content. Relative targets are resolved against the
+// directory holding the link. Paths that aren't links resolve to themselves.
+// A missing target yields ErrFileNotExist and cycles yield ErrLinkLoop
+func (mt *Trie) ResolveLink(path string) (string, *Content, error) {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if len(path) == 0 {
+		return "", nil, ErrEmptyPath
+	}
+	if mt.Root == nil {
+		return "", nil, ErrFileNotExist
+	}
+
+	p := CleanPath(mt.swap(path))
+	for i := 0; i <= MaxLinkDepth; i++ {
+		f := stat(p, mt.Root)
+		if f == nil {
+			return "", nil, ErrFileNotExist
+		}
+		if f.Type != MIMESymlink {
+			cnt := f.copy()
+			cnt.Name = filepath.Base(p)
+			return mt.swap(p), mt.swapContent(cnt), nil
+		}
+
+		target := f.CID
+		if len(target) == 0 {
+			return "", nil, ErrFileNotExist
+		}
+		if target[0] != SeparatorRune {
+			target = filepath.Join(filepath.Dir(p), target)
+		}
+		p = CleanPath(target)
+	}
+	return "", nil, ErrLinkLoop
 }
 
-func createRef(path string, bucketID string, trie *Trie, createdAt time.Time) ([]*Entry, error) {
+func createRef(path string, bucketID string, trie *Trie, createdAt time.Time, keepSize bool) ([]*Entry, error) {
 	entries := make([]*Entry, 0)
 	// check the path if it is file
 	f := find(path, trie.Root)
@@ -520,6 +2052,14 @@ func createRef(path string, bucketID string, trie *Trie, createdAt time.Time) ([
 		}
 	}
 	refEntry := NewEntry(path, bucketID, 0, MIMEReference, createdAt)
+	if keepSize {
+		for _, e := range entries {
+			if !e.IsDirectory() {
+				refEntry.Size += e.Size
+			}
+		}
+		refEntry.RefEntries = int64(len(entries))
+	}
 	err := refEntry.Validate()
 	if err != nil {
 		return nil, err
@@ -566,28 +2106,61 @@ func JoinPath(paths ...string) string {
 	return CleanPath(strings.Join(paths, Separator))
 }
 
-// commonPrefix returns the longest common prefix of a and b,
-// always cutting at a valid UTF-8 rune boundary so multi-byte
-// characters (e.g. emoji) are never split.
-func commonPrefix(a, b string) string {
-	i := 0
-	for i < len(a) && i < len(b) && a[i] == b[i] {
-		i++
+// IsAncestor checks if descendant lies below ancestor. Paths are cleaned
+// and compared by whole segments, so "/a/b" is an ancestor of "/a/b/c" but
+// not of "/a/bc". A path isn't its own ancestor
+func IsAncestor(ancestor, descendant string) bool {
+	a, d := CleanPath(ancestor), CleanPath(descendant)
+	if len(a) == 0 || len(d) == 0 || a == d {
+		return false
 	}
-	// If we stopped in the middle of a multi-byte rune,
-	// back up to its leading byte. Since a[:i] excludes a[i],
-	// this drops the entire partially-matched rune.
-	for i > 0 && i < len(a) && !utf8.RuneStart(a[i]) {
-		i--
-	}
-	// Handle case where i == len(a) and a has trailing incomplete rune
-	if i > 0 && i == len(a) && !utf8.ValidString(a[i-1:]) {
-		for i > 0 && !utf8.RuneStart(a[i-1]) {
-			i--
+	return isSubpath(d, a)
+}
+
+// CommonAncestor returns the deepest directory containing all paths, compared
+// by whole segments, so it's "/a" for "/a/bc" and "/a/bd". A single path
+// gives its parent. Paths are cleaned first, empty ones are ignored and
+// without any path the result is empty
+func CommonAncestor(paths []string) string {
+	res := ""
+	for _, p := range paths {
+		if len(p) == 0 {
+			continue
+		}
+		dir := filepath.Dir(CleanPath(p))
+		if len(res) == 0 {
+			res = dir
+			continue
 		}
-		if i > 0 {
-			i-- // drop the leading byte of the incomplete rune
+		for !isSubpath(dir, res) {
+			res = filepath.Dir(res)
+		}
+	}
+	return res
+}
+
+// IsSibling checks if different paths a and b share the parent directory.
+// Paths are cleaned first, the root has no siblings
+func IsSibling(a, b string) bool {
+	ca, cb := CleanPath(a), CleanPath(b)
+	if len(ca) == 0 || len(cb) == 0 || ca == cb || ca == Separator || cb == Separator {
+		return false
+	}
+	return filepath.Dir(ca) == filepath.Dir(cb)
+}
+
+// commonPrefix returns the longest common prefix of a and b made of whole
+// runes, so multi-byte characters (e.g. emoji) sharing leading bytes are
+// never split. Invalid bytes count as single runes
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) {
+		_, n := utf8.DecodeRuneInString(a[i:])
+		_, m := utf8.DecodeRuneInString(b[i:])
+		if n != m || a[i:i+n] != b[i:i+m] {
+			break
 		}
+		i += n
 	}
 	return a[:i]
 }
@@ -622,12 +2195,7 @@ func addTo(subtrie *Entry, what *Entry) ([]*Entry, error) {
 			return fixEntries(splitEntry(what), subtrie.Path), split(subtrie.Path, subtrie, what, false)
 		}
 
-		whatRune, _ := utf8.DecodeRuneInString(what.Path)
-		for _, me := range subtrie.Entries {
-			meRune, _ := utf8.DecodeRuneInString(me.Path)
-			if meRune != whatRune {
-				continue
-			}
+		if me := subtrie.child(firstRune(what.Path)); me != nil {
 			entries, err := addTo(me, what)
 			return fixEntries(entries, subtrie.Path), err
 		}
@@ -642,6 +2210,28 @@ func addTo(subtrie *Entry, what *Entry) ([]*Entry, error) {
 	return fixEntries(entries, subprefix), split(subprefix, subtrie, what, true)
 }
 
+// sortPath keeps children of every node along path sorted by their labels
+// after a change at path. Only the child on the way to path and the marker
+// of a directory can be out of place, siblings never share the first rune
+// and keep their order otherwise
+func sortPath(path string, subtrie *Entry) {
+	for subtrie != nil && strings.HasPrefix(path, subtrie.Path) {
+		path = strings.TrimPrefix(path, subtrie.Path)
+		var next *Entry
+		if len(path) != 0 {
+			next = subtrie.child(firstRune(path))
+		}
+		if next != nil {
+			subtrie.placeChild(next)
+		}
+		// the marker goes second, against children already in place
+		if marker := subtrie.child(rune(SpecialPathSymbol[0])); marker != nil {
+			subtrie.placeChild(marker)
+		}
+		subtrie = next
+	}
+}
+
 // nolint:unparam
 // to keep consistency with extend, add and others function return error though it always returns nil
 func split(subprefix string, me *Entry, what *Entry, trimPath bool) error {
@@ -649,11 +2239,14 @@ func split(subprefix string, me *Entry, what *Entry, trimPath bool) error {
 		Entries: me.Entries,
 		Path:    me.trimPrefix(subprefix),
 		Content: me.Content,
+		index:   me.index,
 	}
 
-	if what.IsEmptyFolder() && what.Path == subprefix {
+	// Only a full path can be compared with the prefix, when trimPath is false
+	// what's path is already relative to it
+	if trimPath && what.IsEmptyFolder() && what.Path == subprefix {
 		me.Copy(what)
-		me.Entries = append(me.Entries, &newEntry)
+		me.appendChild(&newEntry)
 		return nil
 	}
 
@@ -666,6 +2259,7 @@ func split(subprefix string, me *Entry, what *Entry, trimPath bool) error {
 		&newEntry,
 		what,
 	}
+	me.index = nil
 
 	return nil
 }
@@ -681,8 +2275,13 @@ func extend(subtrie *Entry, what *Entry) error {
 		}
 	}
 
+	if what.IsEmptyFolder() {
+		// the folder already is its own marker, nesting it would put
+		// a marker under a marker
+		what = what.Entries[0]
+	}
 	what.Path = SpecialPathSymbol
-	subtrie.Entries = append(subtrie.Entries, what)
+	subtrie.appendChild(what)
 	return nil
 }
 
@@ -698,22 +2297,23 @@ func add(subtrie *Entry, what *Entry) error {
 			subtrie.Content = what.Content
 			if what.Type != MIMEDriveEntry {
 				subtrie.Entries = nil
+				subtrie.index = nil
 			}
 			return nil
 		}
 
-		for _, me := range subtrie.Entries {
-			if me.Path == SpecialPathSymbol {
-				if me.Content.Type != MIMEDriveEntry {
-					return ErrConflict
-				}
-				me.Copy(what)
-				return nil
+		if me := subtrie.child(rune(SpecialPathSymbol[0])); me != nil {
+			if me.Content.Type != MIMEDriveEntry {
+				return ErrConflict
 			}
+			me.Copy(what)
+			// the marker turned into a child starting with separator
+			subtrie.reindex()
+			return nil
 		}
 	}
 
-	subtrie.Entries = append(subtrie.Entries, what)
+	subtrie.appendChild(what)
 	return nil
 }
 
@@ -766,6 +2366,40 @@ func tree(dir *Entry, _path string, subtrie *Entry) *Entry {
 	return dir
 }
 
+func treeFiltered(dir *Entry, _path string, subtrie *Entry, depth int, maxDepth int, include func(*Content) bool) *Entry {
+	if len(dir.Entries) == 0 {
+		dir.Entries = make([]*Entry, 0)
+	}
+	if maxDepth >= 0 && depth > maxDepth {
+		return dir
+	}
+
+	for _, d := range directoriesFromContents(_path, list(_path, subtrie)) {
+		if include != nil && !include(&d.Content) {
+			continue
+		}
+		dir.Entries = append(dir.Entries, treeFiltered(d, JoinPath(_path, d.Name), subtrie, depth+1, maxDepth, include))
+	}
+	return dir
+}
+
+func treeAll(dir *Entry, _path string, subtrie *Entry) *Entry {
+	if len(dir.Entries) == 0 {
+		dir.Entries = make([]*Entry, 0)
+	}
+
+	for _, c := range list(_path, subtrie) {
+		p := JoinPath(_path, c.Name)
+		if c.IsDirectory() {
+			d := NewEntry(p, "", c.Size, c.Type, time.Unix(c.CreatedAt, 0))
+			dir.Entries = append(dir.Entries, treeAll(d, p, subtrie))
+			continue
+		}
+		dir.Entries = append(dir.Entries, &Entry{Content: *c.copy(), Path: p})
+	}
+	return dir
+}
+
 func listRecursive(_path string, fixedPath string, subtrie *Entry) []*Entry {
 	entries := list(_path, subtrie)
 	res := make([]*Entry, 0)
@@ -774,7 +2408,7 @@ func listRecursive(_path string, fixedPath string, subtrie *Entry) []*Entry {
 		trimPrefix = ""
 	}
 	for _, c := range entries {
-		res = append(res, &Entry{Content: *c, Path: strings.TrimPrefix(JoinPath(_path, c.Name), trimPrefix)})
+		res = append(res, &Entry{Content: *c.copy(), Path: strings.TrimPrefix(JoinPath(_path, c.Name), trimPrefix)})
 		if c.Type == MIMEDriveDirectory {
 			chldrn := listRecursive(JoinPath(_path, c.Name), fixedPath, subtrie)
 			res = append(res, chldrn...)
@@ -858,10 +2492,20 @@ func rm(subprefix string, subtrie *Entry) *Entry {
 		}
 		if strings.HasPrefix(subprefix, me.Path) {
 			found := rm(subprefix, me)
-			if found != nil {
-				return removeAndMerge(subtrie, i)
+			if found == nil {
+				return nil
 			}
-			return nil
+			if me.Path[0] == SeparatorRune && !hasDirChild(subtrie, i) {
+				// The last child of the subtrie directory is gone,
+				// the directory itself stays as an empty folder
+				subtrie.Entries[i] = &Entry{
+					Content: NewContent("", "", 0, MIMEDriveEntry, time.Unix(me.CreatedAt, 0)),
+					Path:    SpecialPathSymbol,
+				}
+				subtrie.reindex()
+				return nil
+			}
+			return removeAndMerge(subtrie, i)
 		}
 		if strings.HasPrefix(me.Path, subprefix) {
 			return nil
@@ -870,24 +2514,47 @@ func rm(subprefix string, subtrie *Entry) *Entry {
 	return nil
 }
 
+// hasDirChild checks if subtrie keeps being a directory without its idx child
+func hasDirChild(subtrie *Entry, idx int) bool {
+	for i, me := range subtrie.Entries {
+		if i == idx {
+			continue
+		}
+		if me.Path == SpecialPathSymbol || me.Path[0] == SeparatorRune {
+			return true
+		}
+	}
+	return false
+}
+
 func removeAndMerge(subtrie *Entry, idx int) *Entry {
 	if len(subtrie.Entries) <= 1 {
 		return subtrie
 	}
+	if subtrie.index != nil {
+		delete(subtrie.index, firstRune(subtrie.Entries[idx].Path))
+	}
 	if idx == (len(subtrie.Entries) - 1) {
 		subtrie.Entries = subtrie.Entries[:idx]
 	} else {
 		subtrie.Entries = append(subtrie.Entries[:idx], subtrie.Entries[idx+1:]...)
 	}
+	if len(subtrie.Entries) < indexThreshold {
+		subtrie.index = nil
+	}
 
 	if len(subtrie.Entries) == 1 {
 		// We need to merge
 		subtrie.Content = subtrie.Entries[0].Content
 		if subtrie.Entries[0].Path != SpecialPathSymbol {
 			subtrie.Path += subtrie.Entries[0].Path
+			subtrie.index = subtrie.Entries[0].index
 			subtrie.Entries = subtrie.Entries[0].Entries
-		} else if subtrie.Type != MIMEDriveEntry {
-			subtrie.Entries = nil
+		} else {
+			subtrie.index = nil
+			if subtrie.Type != MIMEDriveEntry {
+				subtrie.Entries = nil
+			}
 		}
 	}
 	return nil
@@ -916,30 +2583,28 @@ func find(subprefix string, subtrie *Entry) *Content {
 		}
 	}
 
-	for _, me := range subtrie.Entries {
-		if len(subprefix) == 0 {
-			if me.Path == SpecialPathSymbol {
-				if me.Type != MIMEDriveEntry {
-					return &me.Content
-				}
-
-				cnt := NewContent("", "", 0, MIMEDriveDirectory, time.Unix(subtrie.CreatedAt, 0))
-				return updateFolderEntry(&cnt, subtrie)
-			}
-			continue
-		}
-		if strings.HasPrefix(subprefix, me.Path) {
-			item := find(subprefix, me)
-			if item != nil {
-				return updateFolderEntry(item, subtrie)
-			}
+	if len(subprefix) == 0 {
+		me := subtrie.child(rune(SpecialPathSymbol[0]))
+		if me == nil {
 			return nil
 		}
-		if strings.HasPrefix(me.Path, subprefix) {
-			return nil
+		if me.Type != MIMEDriveEntry {
+			return &me.Content
 		}
+
+		cnt := NewContent("", "", 0, MIMEDriveDirectory, time.Unix(subtrie.CreatedAt, 0))
+		return updateFolderEntry(&cnt, subtrie)
 	}
 
+	// only the child sharing the first rune can hold subprefix
+	me := subtrie.child(firstRune(subprefix))
+	if me == nil || !strings.HasPrefix(subprefix, me.Path) {
+		return nil
+	}
+	item := find(subprefix, me)
+	if item != nil {
+		return updateFolderEntry(item, subtrie)
+	}
 	return nil
 }
 
@@ -955,24 +2620,24 @@ func stat(subprefix string, subtrie *Entry) *Content {
 				return &cnt
 			}
 		}
+		if len(subprefix) != 0 {
+			// only the child sharing the first rune can hold subprefix
+			if me := subtrie.child(firstRune(subprefix)); me != nil {
+				return stat(subprefix, me)
+			}
+			return nil
+		}
 		for _, me := range subtrie.Entries {
-			if len(subprefix) == 0 {
-				if me.Path == SpecialPathSymbol {
-					if me.Type != MIMEDriveEntry {
-						return &me.Content
-					}
-					cnt := NewContent("", "", 0, MIMEDriveDirectory, time.Unix(subtrie.CreatedAt, 0))
-					return &cnt
-				}
-				if me.Path[0] == SeparatorRune {
-					cnt := NewContent("", "", 0, MIMEDriveDirectory, time.Unix(subtrie.CreatedAt, 0))
-					return &cnt
+			if me.Path == SpecialPathSymbol {
+				if me.Type != MIMEDriveEntry {
+					return &me.Content
 				}
-				continue
+				cnt := NewContent("", "", 0, MIMEDriveDirectory, time.Unix(subtrie.CreatedAt, 0))
+				return &cnt
 			}
-			item := stat(subprefix, me)
-			if item != nil {
-				return item
+			if me.Path[0] == SeparatorRune {
+				cnt := NewContent("", "", 0, MIMEDriveDirectory, time.Unix(subtrie.CreatedAt, 0))
+				return &cnt
 			}
 		}
 	} else if strings.HasPrefix(subtrie.Path, subprefix) {
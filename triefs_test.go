@@ -5,6 +5,8 @@ import (
 	"math"
 	"math/rand"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -1215,8 +1217,8 @@ func TestLs(t *testing.T) {
 				triefs.NewEntry("/aaa/file", "test_cid", 512, triefs.MIMEOctetStream, now),
 			},
 			content: []triefs.Content{
-				triefs.NewContent("file1", "test_cid", 512, triefs.MIMEOctetStream, now),
 				triefs.NewContent("file", "test_cid", 512, triefs.MIMEOctetStream, now),
+				triefs.NewContent("file1", "test_cid", 512, triefs.MIMEOctetStream, now),
 			},
 		},
 		{
@@ -1271,8 +1273,8 @@ func TestLs(t *testing.T) {
 			},
 			content: []triefs.Content{
 				triefs.NewContent("fbb", "", 0, triefs.MIMEDriveDirectory, now),
-				triefs.NewContent("file", "", 0, triefs.MIMEDriveDirectory, now),
 				triefs.NewContent("fiee", "", 0, triefs.MIMEDriveDirectory, now),
+				triefs.NewContent("file", "", 0, triefs.MIMEDriveDirectory, now),
 			},
 		},
 		{
@@ -1287,9 +1289,9 @@ func TestLs(t *testing.T) {
 			},
 			content: []triefs.Content{
 				triefs.NewContent("fbb", "", 0, triefs.MIMEDriveDirectory, now),
-				triefs.NewContent("file", "test_cid", 512, triefs.MIMEOctetStream, now),
 				triefs.NewContent("fiee", "", 0, triefs.MIMEDriveDirectory, now),
 				triefs.NewContent("fieeolder_emtpty", "", 0, triefs.MIMEDriveDirectory, now),
+				triefs.NewContent("file", "test_cid", 512, triefs.MIMEOctetStream, now),
 			},
 		},
 		{
@@ -1318,8 +1320,8 @@ func TestLs(t *testing.T) {
 			},
 			content: []triefs.Content{
 				triefs.NewContent("fbb", "", 0, triefs.MIMEDriveDirectory, now),
-				triefs.NewContent("file", "", 0, triefs.MIMEDriveDirectory, now),
 				triefs.NewContent("fiee", "", 0, triefs.MIMEDriveDirectory, now),
+				triefs.NewContent("file", "", 0, triefs.MIMEDriveDirectory, now),
 			},
 		},
 		{
@@ -1334,9 +1336,9 @@ func TestLs(t *testing.T) {
 			},
 			content: []triefs.Content{
 				triefs.NewContent("fbb", "", 0, triefs.MIMEDriveDirectory, now),
-				triefs.NewContent("file", "test_cid", 512, triefs.MIMEOctetStream, now),
 				triefs.NewContent("fiee", "", 0, triefs.MIMEDriveDirectory, now),
 				triefs.NewContent("fieeolder_emtpty", "", 0, triefs.MIMEDriveDirectory, now),
+				triefs.NewContent("file", "test_cid", 512, triefs.MIMEOctetStream, now),
 			},
 		},
 		{
@@ -1367,9 +1369,9 @@ func TestLs(t *testing.T) {
 			},
 			content: []triefs.Content{
 				triefs.NewContent("fbb", "", 0, triefs.MIMEDriveDirectory, now),
-				triefs.NewContent("file", "test_cid", 512, triefs.MIMEOctetStream, now),
 				triefs.NewContent("fiee", "", 0, triefs.MIMEDriveDirectory, now),
 				triefs.NewContent("fieeolder_emtpty", "", 0, triefs.MIMEDriveDirectory, now),
+				triefs.NewContent("file", "test_cid", 512, triefs.MIMEOctetStream, now),
 			},
 		},
 		{
@@ -1385,9 +1387,9 @@ func TestLs(t *testing.T) {
 			},
 			content: []triefs.Content{
 				triefs.NewContent("fbb", "", 0, triefs.MIMEDriveDirectory, now),
-				triefs.NewContent("file", "test_cid", 512, triefs.MIMEOctetStream, now),
 				triefs.NewContent("fiee", "", 0, triefs.MIMEDriveDirectory, now),
 				triefs.NewContent("fieeolder_emtpty", "", 0, triefs.MIMEDriveDirectory, now),
+				triefs.NewContent("file", "test_cid", 512, triefs.MIMEOctetStream, now),
 			},
 		},
 		{
@@ -1472,6 +1474,126 @@ func TestLs(t *testing.T) {
 	}
 }
 
+func TestLsOrder(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	paths := []string{
+		"/aaa/fbb/f",
+		"/aaa/file",
+		"/aaa/fiee/file",
+		"/aaa/fieeolder_emtpty",
+		"/aaa/Ölpreis",
+		"/aaa/😀",
+		"/aaa/file1",
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var expected []*triefs.Content
+	for i := 0; i < 20; i++ {
+		trie := triefs.NewTrie()
+		for _, idx := range r.Perm(len(paths)) {
+			_, err := trie.AddFile(triefs.NewEntry(paths[idx], "test_cid", 512, triefs.MIMEOctetStream, now))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cnt := trie.Ls("/aaa")
+		if expected == nil {
+			expected = cnt
+			continue
+		}
+		if !reflect.DeepEqual(cnt, expected) {
+			t.Fatalf("got %v, want %v", cnt, expected)
+		}
+	}
+
+	names := make([]string, len(expected))
+	for i, c := range expected {
+		names[i] = c.Name
+	}
+	expectedNames := []string{"fbb", "fiee", "fieeolder_emtpty", "file", "file1", "Ölpreis", "😀"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("got %v, want %v", names, expectedNames)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	dirs := []*triefs.Entry{
+		triefs.NewEntry("/aaa/fbb/f", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/file", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/empty", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/bbb", "test_cid", 512, triefs.MIMEOctetStream, now),
+	}
+	for _, d := range dirs {
+		_, err := trie.AddFile(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name  string
+		path  string
+		err   error
+		paths []string
+	}{
+		{
+			name:  "root",
+			path:  "/",
+			paths: []string{"/aaa", "/bbb"},
+		},
+		{
+			name:  "directory",
+			path:  "/aaa",
+			paths: []string{"/aaa/empty", "/aaa/fbb", "/aaa/file"},
+		},
+		{
+			name:  "empty directory",
+			path:  "/aaa/empty",
+			paths: []string{},
+		},
+		{
+			name: "file",
+			path: "/aaa/file",
+			err:  triefs.ErrNotADirectory,
+		},
+		{
+			name: "missing",
+			path: "/aaa/missing",
+			err:  triefs.ErrFileNotExist,
+		},
+		{
+			name: "empty path",
+			path: "",
+			err:  triefs.ErrEmptyPath,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := trie.ReadDir(tc.path)
+			if err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if tc.err != nil {
+				return
+			}
+			if len(entries) != len(tc.paths) {
+				t.Fatalf("got %v, want %v", len(entries), len(tc.paths))
+			}
+			for i, e := range entries {
+				if e.Path != tc.paths[i] {
+					t.Errorf("got %v, want %v", e.Path, tc.paths[i])
+				}
+			}
+		})
+	}
+}
+
 func TestFuzzyLs(t *testing.T) {
 	if testing.Short() {
 		t.Skip()
@@ -1899,6 +2021,109 @@ func TestLsRecursive(t *testing.T) {
 	}
 }
 
+func TestPaths(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	cases := []struct {
+		name     string
+		dirs     []*triefs.Entry
+		expected []string
+	}{
+		{
+			name:     "empty trie",
+			expected: []string{},
+		},
+		{
+			name: "files and directories",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/aaa/bbb/file", "cid1", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/aaa/file", "cid2", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/aaa/empty", "", 0, triefs.MIMEDriveEntry, now),
+				triefs.NewEntry("/file", "cid3", 512, triefs.MIMEOctetStream, now),
+			},
+			expected: []string{"/aaa", "/aaa/bbb", "/aaa/bbb/file", "/aaa/empty", "/aaa/file", "/file"},
+		},
+		{
+			name: "name prefix siblings",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/f", "", 0, triefs.MIMEDriveEntry, now),
+				triefs.NewEntry("/f/x", "cid1", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/f1", "cid2", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/f11", "", 0, triefs.MIMEDriveEntry, now),
+			},
+			expected: []string{"/f", "/f/x", "/f1", "/f11"},
+		},
+		{
+			name: "utf-8 paths in byte order",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/😀/file", "cid1", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/中文/文件.txt", "cid2", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/zzz", "cid3", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/é", "cid4", 1, triefs.MIMEOctetStream, now),
+			},
+			expected: []string{"/zzz", "/é", "/中文", "/中文/文件.txt", "/😀", "/😀/file"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := triefs.NewTrie()
+			for _, d := range tc.dirs {
+				if _, err := trie.AddFile(d); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			got := trie.Paths()
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestPathsInsertOrder(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	paths := []string{
+		"/aaa/bbb/file",
+		"/aaa/b",
+		"/aaa/bbbb",
+		"/😀/😀😀",
+		"/😀/😁",
+		"/abc",
+	}
+
+	forward := triefs.NewTrie()
+	backward := triefs.NewTrie()
+	for i := range paths {
+		_, _ = forward.AddFile(triefs.NewEntry(paths[i], "cid", 1, triefs.MIMEOctetStream, now))
+		_, _ = backward.AddFile(triefs.NewEntry(paths[len(paths)-1-i], "cid", 1, triefs.MIMEOctetStream, now))
+	}
+
+	if !reflect.DeepEqual(forward.Paths(), backward.Paths()) {
+		t.Errorf("got %v, want %v", backward.Paths(), forward.Paths())
+	}
+}
+
+func TestFuzzyPaths(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	t.Parallel()
+
+	for i := 0; i < 5000; i++ {
+		trie := triefs.NewTrie()
+		_ = createRandomFiles(trie, 10)
+
+		expected := recursivePaths(trie, "/")
+		if got := trie.Paths(); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("got %v, want %v", got, expected)
+		}
+	}
+}
+
 func TestFuzzyLsRecursive(t *testing.T) {
 	if testing.Short() {
 		t.Skip()
@@ -1925,6 +2150,57 @@ func TestFuzzyLsRecursive(t *testing.T) {
 	}
 }
 
+func TestLsFilesAndDirs(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	dirs := []*triefs.Entry{
+		triefs.NewEntry("/aaa/bbb/f", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aba/file", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/aca/file/file", "test_cid", 512, triefs.MIMEOctetStream, now),
+	}
+	for _, d := range dirs {
+		_, err := trie.AddFile(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := trie.LsFiles("/")
+	fpaths := []string{"/aaa/bbb/f", "/aca/file/file"}
+	if len(files) != len(fpaths) {
+		t.Fatalf("got %v, want %v", len(files), len(fpaths))
+	}
+	for i, f := range files {
+		if f.Path != fpaths[i] {
+			t.Errorf("got %v, want %v", f.Path, fpaths[i])
+		}
+		if f.Type != triefs.MIMEOctetStream {
+			t.Errorf("got %v, want %v", f.Type, triefs.MIMEOctetStream)
+		}
+	}
+
+	ds := trie.LsDirs("/")
+	dpaths := []string{"/aaa", "/aaa/bbb", "/aba", "/aba/file", "/aca", "/aca/file"}
+	if len(ds) != len(dpaths) {
+		t.Fatalf("got %v, want %v", len(ds), len(dpaths))
+	}
+	for i, d := range ds {
+		if d.Path != dpaths[i] {
+			t.Errorf("got %v, want %v", d.Path, dpaths[i])
+		}
+		if d.Type != triefs.MIMEDriveDirectory {
+			t.Errorf("got %v, want %v", d.Type, triefs.MIMEDriveDirectory)
+		}
+	}
+
+	// Relative paths are preserved for sub directories
+	files = trie.LsFiles("/aca")
+	if len(files) != 1 || files[0].Path != "/file/file" {
+		t.Errorf("got %v, want %v", files, "/file/file")
+	}
+}
+
 func TestDelete(t *testing.T) {
 	t.Parallel()
 	now := time.Now()
@@ -2338,6 +2614,42 @@ func TestFile(t *testing.T) {
 	}
 }
 
+func TestFileMany(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/docs/a.txt", "cid1", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/b.txt", "cid2", 2, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/empty", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	paths := []string{"/docs/b.txt", "/missing", "", "/docs/a.txt", "/docs//a.txt/", "/empty", "/docs"}
+	contents, errs := trie.FileMany(paths)
+	if len(contents) != len(paths) || len(errs) != len(paths) {
+		t.Fatalf("got %v contents and %v errors, want %v", len(contents), len(errs), len(paths))
+	}
+	for i, p := range paths {
+		want, wantErr := trie.File(p)
+		if errs[i] != wantErr || !reflect.DeepEqual(contents[i], want) {
+			t.Errorf("%q: got %v %v, want %v %v", p, contents[i], errs[i], want, wantErr)
+		}
+	}
+	if errs[1] != triefs.ErrFileNotExist || contents[1] != nil {
+		t.Errorf("got %v %v, want %v", contents[1], errs[1], triefs.ErrFileNotExist)
+	}
+	if errs[2] != triefs.ErrEmptyPath {
+		t.Errorf("got %v, want %v", errs[2], triefs.ErrEmptyPath)
+	}
+	if contents[3] == nil || contents[3].CID != "cid1" {
+		t.Errorf("got %v, want %v", contents[3], "cid1")
+	}
+}
+
 func TestFuzzyFile(t *testing.T) {
 	if testing.Short() {
 		t.Skip()
@@ -2407,13 +2719,17 @@ func TestStat(t *testing.T) {
 		{
 			name: "get root",
 			path: "/",
-			err:  triefs.ErrFileNotExist,
 			dirs: []*triefs.Entry{
 				triefs.NewEntry("/aaa/fbb/f", "test_cid", 512, triefs.MIMEOctetStream, now),
 				triefs.NewEntry("/aaa/file", "test_cid", 512, triefs.MIMEOctetStream, now),
 				triefs.NewEntry("/aaa/fiee/file", "test_cid", 512, triefs.MIMEOctetStream, now),
 			},
-			file: triefs.Content{},
+			file: triefs.NewContent("/", "", 0, triefs.MIMEDriveDirectory, now),
+		},
+		{
+			name: "get root of empty fs",
+			path: "//",
+			file: triefs.NewContent("/", "", 0, triefs.MIMEDriveDirectory, now),
 		},
 		{
 			name: "get top level file",
@@ -3216,25 +3532,335 @@ func TestTreeRecursive(t *testing.T) {
 	}
 }
 
-func TestRecursiveDelete(t *testing.T) {
-	// Issues 735, PR 746
-	trie := triefs.NewTrie()
+func TestTreeFiltered(t *testing.T) {
 	now := time.Now()
 	dirs := []*triefs.Entry{
-		triefs.NewEntry("/folder/f1/f2/f3/f4", "", 0, triefs.MIMEDriveEntry, now),
-		triefs.NewEntry("/folder/f/f2/f3/f4", "", 0, triefs.MIMEDriveEntry, now),
-		triefs.NewEntry("/folder/f/f/f3/f4", "", 0, triefs.MIMEDriveEntry, now),
-		triefs.NewEntry("/folder/f/f/f/f4", "", 0, triefs.MIMEDriveEntry, now),
-		triefs.NewEntry("/folder/f/f/f/f", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/aaa", "test_cid", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/aaa/bbb/file1.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/bba/file2.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/bbb/aaa/file1.txt", "test_cid", 0, triefs.MIMEOctetStream, now),
+	}
+	cases := []struct {
+		name           string
+		maxDepth       int
+		include        func(*triefs.Content) bool
+		expectedResult *triefs.Entry
+	}{
+		{
+			name:     "first level only",
+			maxDepth: 1,
+			expectedResult: &triefs.Entry{
+				Content: triefs.NewContent("/", "", 0, triefs.MIMEDriveDirectory, now),
+				Path:    "/",
+				Entries: []*triefs.Entry{
+					{
+						Content: triefs.NewContent("aaa", "", 0, triefs.MIMEDriveDirectory, now),
+						Path:    "/aaa",
+						Entries: []*triefs.Entry{},
+					},
+					{
+						Content: triefs.NewContent("bbb", "", 0, triefs.MIMEDriveDirectory, now),
+						Path:    "/bbb",
+						Entries: []*triefs.Entry{},
+					},
+				},
+			},
+		},
+		{
+			name:     "exclude named folder",
+			maxDepth: -1,
+			include: func(c *triefs.Content) bool {
+				return c.Name != "bbb"
+			},
+			expectedResult: &triefs.Entry{
+				Content: triefs.NewContent("/", "", 0, triefs.MIMEDriveDirectory, now),
+				Path:    "/",
+				Entries: []*triefs.Entry{
+					{
+						Content: triefs.NewContent("aaa", "", 0, triefs.MIMEDriveDirectory, now),
+						Path:    "/aaa",
+						Entries: []*triefs.Entry{
+							{
+								Content: triefs.NewContent("bba", "", 0, triefs.MIMEDriveDirectory, now),
+								Path:    "/aaa/bba",
+								Entries: []*triefs.Entry{},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:     "zero depth",
+			maxDepth: 0,
+			expectedResult: &triefs.Entry{
+				Content: triefs.NewContent("/", "", 0, triefs.MIMEDriveDirectory, now),
+				Path:    "/",
+				Entries: []*triefs.Entry{},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			trie := triefs.NewTrie()
+			for _, d := range dirs {
+				_, err := trie.AddFile(d)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			ds := trie.TreeFiltered("/", tc.maxDepth, tc.include)
+
+			if !reflect.DeepEqual(ds, tc.expectedResult) {
+				t.Errorf("got %v, want %v", ds, tc.expectedResult)
+			}
+		})
 	}
 
+	// Unlimited depth without predicate matches Tree
+	trie := triefs.NewTrie()
 	for _, d := range dirs {
 		_, err := trie.AddFile(d)
 		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
+			t.Fatal(err)
 		}
 	}
-
+	if !reflect.DeepEqual(trie.TreeFiltered("/", -1, nil), trie.Tree("/")) {
+		t.Errorf("got %v, want %v", trie.TreeFiltered("/", -1, nil), trie.Tree("/"))
+	}
+}
+
+func TestTreeBudget(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, top := range []string{"a", "b", "c", "d"} {
+		for _, sub := range []string{"1", "2", "3", "4"} {
+			e := triefs.NewEntry("/"+top+"/"+sub+"/file", "cid", 1, triefs.MIMEOctetStream, now)
+			if _, err := trie.AddFile(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// paths of the tree nodes, checking every node is under its parent
+	var walk func(e *triefs.Entry) []string
+	walk = func(e *triefs.Entry) []string {
+		paths := []string{e.Path}
+		if e.Entries == nil {
+			t.Errorf("%v: got nil entries", e.Path)
+		}
+		for _, me := range e.Entries {
+			if me.Path != triefs.JoinPath(e.Path, me.Name) || !me.IsDirectory() {
+				t.Errorf("got %v under %v", me.Path, e.Path)
+			}
+			paths = append(paths, walk(me)...)
+		}
+		return paths
+	}
+
+	cases := []struct {
+		name      string
+		path      string
+		maxNodes  int
+		truncated bool
+		nodes     int
+	}{
+		{name: "truncated", path: "/", maxNodes: 10, truncated: true, nodes: 10},
+		{name: "exact", path: "/", maxNodes: 21, nodes: 21},
+		{name: "enough", path: "/", maxNodes: 100, nodes: 21},
+		{name: "root only", path: "/", maxNodes: 0, truncated: true, nodes: 1},
+		{name: "subdirectory", path: "/b", maxNodes: 3, truncated: true, nodes: 3},
+		{name: "leaf directory", path: "/b/1", maxNodes: 1, nodes: 1},
+	}
+	for _, tc := range cases {
+		tree, truncated := trie.TreeBudget(tc.path, tc.maxNodes)
+		if truncated != tc.truncated {
+			t.Errorf("%v: got %v, want %v", tc.name, truncated, tc.truncated)
+		}
+		if got := walk(tree); len(got) != tc.nodes {
+			t.Errorf("%v: got %v, want %v nodes", tc.name, got, tc.nodes)
+		}
+	}
+
+	// upper levels are complete before the deeper ones
+	tree, _ := trie.TreeBudget("/", 10)
+	if len(tree.Entries) != 4 {
+		t.Errorf("got %v, want 4 top level directories", len(tree.Entries))
+	}
+	full, _ := trie.TreeBudget("/", 21)
+	if got, want := walk(full), walk(trie.Tree("/")); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTreeAll(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name           string
+		dirs           []*triefs.Entry
+		path           string
+		expectedResult *triefs.Entry
+	}{
+		{
+			name: "empty fs",
+			path: "/",
+			expectedResult: &triefs.Entry{
+				Content: triefs.NewContent("/", "", 0, triefs.MIMEDriveDirectory, now),
+				Path:    "/",
+				Entries: []*triefs.Entry{},
+			},
+		},
+		{
+			name: "one level directories with files",
+			path: "/",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/aaa", "test_cid", 0, triefs.MIMEDriveEntry, now),
+				triefs.NewEntry("/aaa/file1.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/aaa/file2.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/bbb/file1.txt", "test_cid", 0, triefs.MIMEOctetStream, now),
+			},
+			expectedResult: &triefs.Entry{
+				Content: triefs.NewContent("/", "", 0, triefs.MIMEDriveDirectory, now),
+				Path:    "/",
+				Entries: []*triefs.Entry{
+					{
+						Content: triefs.NewContent("aaa", "", 0, triefs.MIMEDriveDirectory, now),
+						Path:    "/aaa",
+						Entries: []*triefs.Entry{
+							{
+								Content: triefs.NewContent("file1.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+								Path:    "/aaa/file1.txt",
+							},
+							{
+								Content: triefs.NewContent("file2.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+								Path:    "/aaa/file2.txt",
+							},
+						},
+					},
+					{
+						Content: triefs.NewContent("bbb", "", 0, triefs.MIMEDriveDirectory, now),
+						Path:    "/bbb",
+						Entries: []*triefs.Entry{
+							{
+								Content: triefs.NewContent("file1.txt", "test_cid", 0, triefs.MIMEOctetStream, now),
+								Path:    "/bbb/file1.txt",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "two level directories with files",
+			path: "/",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/aaa", "test_cid", 0, triefs.MIMEDriveEntry, now),
+				triefs.NewEntry("/aaa/bbb/file1.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/aaa/bba/file2.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/bbb/aaa/file1.txt", "test_cid", 0, triefs.MIMEOctetStream, now),
+			},
+			expectedResult: &triefs.Entry{
+				Content: triefs.NewContent("/", "", 0, triefs.MIMEDriveDirectory, now),
+				Path:    "/",
+				Entries: []*triefs.Entry{
+					{
+						Content: triefs.NewContent("aaa", "", 0, triefs.MIMEDriveDirectory, now),
+						Path:    "/aaa",
+						Entries: []*triefs.Entry{
+							{
+								Content: triefs.NewContent("bbb", "", 0, triefs.MIMEDriveDirectory, now),
+								Path:    "/aaa/bbb",
+								Entries: []*triefs.Entry{
+									{
+										Content: triefs.NewContent("file1.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+										Path:    "/aaa/bbb/file1.txt",
+									},
+								},
+							},
+							{
+								Content: triefs.NewContent("bba", "", 0, triefs.MIMEDriveDirectory, now),
+								Path:    "/aaa/bba",
+								Entries: []*triefs.Entry{
+									{
+										Content: triefs.NewContent("file2.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+										Path:    "/aaa/bba/file2.txt",
+									},
+								},
+							},
+						},
+					},
+					{
+						Content: triefs.NewContent("bbb", "", 0, triefs.MIMEDriveDirectory, now),
+						Path:    "/bbb",
+						Entries: []*triefs.Entry{
+							{
+								Content: triefs.NewContent("aaa", "", 0, triefs.MIMEDriveDirectory, now),
+								Path:    "/bbb/aaa",
+								Entries: []*triefs.Entry{
+									{
+										Content: triefs.NewContent("file1.txt", "test_cid", 0, triefs.MIMEOctetStream, now),
+										Path:    "/bbb/aaa/file1.txt",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "nonexistent path",
+			path: "/missing",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/aaa/file1.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+			},
+			expectedResult: &triefs.Entry{
+				Content: triefs.NewContent("missing", "", 0, triefs.MIMEDriveDirectory, now),
+				Path:    "/missing",
+				Entries: []*triefs.Entry{},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			trie := triefs.NewTrie()
+			for _, d := range tc.dirs {
+				_, err := trie.AddFile(d)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			ds := trie.TreeAll(tc.path)
+
+			if !reflect.DeepEqual(ds, tc.expectedResult) {
+				t.Errorf("got %v, want %v", ds, tc.expectedResult)
+			}
+		})
+	}
+}
+
+func TestRecursiveDelete(t *testing.T) {
+	// Issues 735, PR 746
+	trie := triefs.NewTrie()
+	now := time.Now()
+	dirs := []*triefs.Entry{
+		triefs.NewEntry("/folder/f1/f2/f3/f4", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/folder/f/f2/f3/f4", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/folder/f/f/f3/f4", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/folder/f/f/f/f4", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/folder/f/f/f/f", "", 0, triefs.MIMEDriveEntry, now),
+	}
+
+	for _, d := range dirs {
+		_, err := trie.AddFile(d)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
 	entries := trie.LsRecursive("/folder")
 	if len(entries) != 14 {
 		t.Errorf("got %v, want %v", len(entries), 14)
@@ -3285,6 +3911,76 @@ func TestDeleteCornerCase(t *testing.T) {
 	}
 }
 
+func TestPrefixSiblings(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	cases := []struct {
+		name     string
+		dirs     []*triefs.Entry
+		delete   string
+		expected []string
+	}{
+		{
+			name: "empty folder with a file name prefix",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/f1", "cid1", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/f/x", "cid2", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/f11", "", 0, triefs.MIMEDriveEntry, now),
+			},
+			expected: []string{"/f", "/f/x", "/f1", "/f11"},
+		},
+		{
+			name: "delete last child of a prefix folder",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/f/f11", "cid1", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/f1", "cid2", 1, triefs.MIMEOctetStream, now),
+			},
+			delete:   "/f/f11",
+			expected: []string{"/f", "/f1"},
+		},
+		{
+			name: "delete last child of a nested prefix folder",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/x/f1/ab", "cid1", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/x/f11", "", 0, triefs.MIMEDriveEntry, now),
+			},
+			delete:   "/x/f1/ab",
+			expected: []string{"/x", "/x/f1", "/x/f11"},
+		},
+		{
+			name: "delete folder above the root label",
+			dirs: []*triefs.Entry{
+				triefs.NewEntry("/ab/f1/ab", "cid1", 1, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/ab/f11", "", 0, triefs.MIMEDriveEntry, now),
+			},
+			delete:   "/ab",
+			expected: []string{"/ab", "/ab/f1", "/ab/f1/ab", "/ab/f11"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := triefs.NewTrie()
+			for _, d := range tc.dirs {
+				if _, err := trie.AddFile(d); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if tc.delete != "" {
+				if err := trie.Delete(tc.delete); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			got := recursivePaths(trie, "/")
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
 func TestDotLsRecursive(t *testing.T) {
 	trie := triefs.NewTrie()
 	now := time.Now()
@@ -3390,14 +4086,14 @@ func TestMkDir(t *testing.T) {
 	if len(cnts) != 3 {
 		t.Errorf("got %v, want %v", len(cnts), 3)
 	}
-	if cnts[0].Name != "Șfolder1" {
-		t.Errorf("got %v, want %v", cnts[0].Name, "Șfolder1")
+	if cnts[0].Name != "folder3 😋" {
+		t.Errorf("got %v, want %v", cnts[0].Name, "folder3 😋")
 	}
-	if cnts[1].Name != "Țfolder2" {
-		t.Errorf("got %v, want %v", cnts[1].Name, "Țfolder2")
+	if cnts[1].Name != "Șfolder1" {
+		t.Errorf("got %v, want %v", cnts[1].Name, "Șfolder1")
 	}
-	if cnts[2].Name != "folder3 😋" {
-		t.Errorf("got %v, want %v", cnts[2].Name, "folder3 😋")
+	if cnts[2].Name != "Țfolder2" {
+		t.Errorf("got %v, want %v", cnts[2].Name, "Țfolder2")
 	}
 }
 
@@ -3872,46 +4568,360 @@ func TestCreateRef(t *testing.T) {
 	}
 }
 
-func TestReplace(t *testing.T) {
-	trie := triefs.NewTrie()
-	oldEntry := triefs.NewEntry("/home/test.txt", "cid1", 100, triefs.MIMEOctetStream, time.Now())
-	_, err := trie.AddFile(oldEntry)
-	if err != nil {
-		t.Fatal(err)
-	}
-	updatedContent := triefs.NewContent("test.txt", "cid2", 1000, triefs.MIMEOctetStream, time.Now())
-	_, _, err = trie.Replace("/home/test.txt", &updatedContent)
-	if err != nil {
-		t.Fatal(err)
-	}
-	cnt, err := trie.File("/home/test.txt")
-	if err != nil {
-		t.Fatal(err)
-	}
-	if cnt.Size != updatedContent.Size {
-		t.Errorf("got %v, want %v", cnt.Size, updatedContent.Size)
-	}
-	if cnt.CID != updatedContent.CID {
-		t.Errorf("got %v, want %v", cnt.CID, updatedContent.CID)
-	}
-}
-
-func TestFuzzyCreateRef(t *testing.T) {
-	if testing.Short() {
-		t.Skip()
-	}
+func TestCreateRefWithSize(t *testing.T) {
 	t.Parallel()
 	now := time.Now()
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	for i := 0; i < 50000; i++ {
-		trie := triefs.NewTrie()
+	cases := []struct {
+		name     string
+		path     string
+		keepSize bool
+		size     int64
+		refs     int64
+	}{
+		{name: "zero size", path: "/aaa", size: 0, refs: 0},
+		{name: "directory", path: "/aaa", keepSize: true, size: 1024, refs: 4},
+		{name: "nested directory", path: "/aaa/bbb", keepSize: true, size: 512, refs: 2},
+		{name: "file", path: "/aaa/f", keepSize: true, size: 512, refs: 1},
+	}
 
-		// paths that are stored in the trie
-		paths := createRandomFiles(trie, 10)
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := triefs.NewTrie()
+			for _, e := range []*triefs.Entry{
+				triefs.NewEntry("/aaa/f", "cid1", 512, triefs.MIMEOctetStream, now),
+				triefs.NewEntry("/aaa/bbb/file", "cid2", 512, triefs.MIMEOctetStream, now),
+			} {
+				if _, err := trie.AddFile(e); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
 
-		if i%2 == 0 {
-			// pick from the inserted data
-			dataIndex := r.Intn(len(paths))
+			entries, err := trie.CreateRefWithSize(tc.path, "bucket", now, tc.keepSize)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if int64(len(entries)) != tc.refs && tc.keepSize {
+				t.Errorf("got %v, want %v", len(entries), tc.refs)
+			}
+
+			ref, err := trie.Stat(tc.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ref.Type != triefs.MIMEReference {
+				t.Errorf("got %v, want %v", ref.Type, triefs.MIMEReference)
+			}
+			if ref.Size != tc.size {
+				t.Errorf("got %v, want %v", ref.Size, tc.size)
+			}
+			if ref.RefEntries != tc.refs {
+				t.Errorf("got %v, want %v", ref.RefEntries, tc.refs)
+			}
+		})
+	}
+}
+
+func TestResolveLink(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	dirs := []*triefs.Entry{
+		triefs.NewEntry("/docs/readme.txt", "cid1", 128, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/links/abs", "/docs/readme.txt", 0, triefs.MIMESymlink, now),
+		triefs.NewEntry("/links/rel", "../docs/readme.txt", 0, triefs.MIMESymlink, now),
+		triefs.NewEntry("/links/chain", "/links/abs", 0, triefs.MIMESymlink, now),
+		triefs.NewEntry("/links/dir", "/docs", 0, triefs.MIMESymlink, now),
+		triefs.NewEntry("/links/dangling", "/docs/missing.txt", 0, triefs.MIMESymlink, now),
+		triefs.NewEntry("/loop/a", "/loop/b", 0, triefs.MIMESymlink, now),
+		triefs.NewEntry("/loop/b", "/loop/a", 0, triefs.MIMESymlink, now),
+	}
+	for _, d := range dirs {
+		_, err := trie.AddFile(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		path   string
+		err    error
+		target string
+		typ    string
+	}{
+		{
+			name:   "absolute link",
+			path:   "/links/abs",
+			target: "/docs/readme.txt",
+			typ:    triefs.MIMEOctetStream,
+		},
+		{
+			name:   "relative link",
+			path:   "/links/rel",
+			target: "/docs/readme.txt",
+			typ:    triefs.MIMEOctetStream,
+		},
+		{
+			name:   "link to link",
+			path:   "/links/chain",
+			target: "/docs/readme.txt",
+			typ:    triefs.MIMEOctetStream,
+		},
+		{
+			name:   "link to directory",
+			path:   "/links/dir",
+			target: "/docs",
+			typ:    triefs.MIMEDriveDirectory,
+		},
+		{
+			name:   "not a link",
+			path:   "/docs/readme.txt",
+			target: "/docs/readme.txt",
+			typ:    triefs.MIMEOctetStream,
+		},
+		{
+			name: "dangling link",
+			path: "/links/dangling",
+			err:  triefs.ErrFileNotExist,
+		},
+		{
+			name: "cycle",
+			path: "/loop/a",
+			err:  triefs.ErrLinkLoop,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			target, cnt, err := trie.ResolveLink(tc.path)
+			if err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if tc.err != nil {
+				return
+			}
+			if target != tc.target {
+				t.Errorf("got %v, want %v", target, tc.target)
+			}
+			if cnt.Type != tc.typ {
+				t.Errorf("got %v, want %v", cnt.Type, tc.typ)
+			}
+		})
+	}
+
+	// Stat doesn't follow links
+	cnt, err := trie.Stat("/links/abs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cnt.Type != triefs.MIMESymlink || cnt.CID != "/docs/readme.txt" {
+		t.Errorf("got %v, want %v", cnt, triefs.MIMESymlink)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	trie := triefs.NewTrie()
+	oldEntry := triefs.NewEntry("/home/test.txt", "cid1", 100, triefs.MIMEOctetStream, time.Now())
+	_, err := trie.AddFile(oldEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updatedContent := triefs.NewContent("test.txt", "cid2", 1000, triefs.MIMEOctetStream, time.Now())
+	_, _, err = trie.Replace("/home/test.txt", &updatedContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnt, err := trie.File("/home/test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cnt.Size != updatedContent.Size {
+		t.Errorf("got %v, want %v", cnt.Size, updatedContent.Size)
+	}
+	if cnt.CID != updatedContent.CID {
+		t.Errorf("got %v, want %v", cnt.CID, updatedContent.CID)
+	}
+}
+
+func TestReplaceIfCID(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	if _, err := trie.AddFile(triefs.NewEntry("/home/test.txt", "cid1", 100, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatal(err)
+	}
+
+	c := triefs.NewContent("test.txt", "cid2", 200, triefs.MIMEOctetStream, now)
+	if err := trie.ReplaceIfCID("/home/test.txt", "cid1", &c); err != nil {
+		t.Fatal(err)
+	}
+	cnt, err := trie.File("/home/test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cnt.CID != "cid2" || cnt.Size != 200 || cnt.Version != 2 {
+		t.Errorf("got %v, want cid2 of size 200 and version 2", cnt)
+	}
+
+	// a stale CID changes nothing
+	stale := triefs.NewContent("test.txt", "cid3", 300, triefs.MIMEOctetStream, now)
+	if err := trie.ReplaceIfCID("/home/test.txt", "cid1", &stale); err != triefs.ErrCIDMismatch {
+		t.Errorf("got %v, want %v", err, triefs.ErrCIDMismatch)
+	}
+	if got, _ := trie.File("/home/test.txt"); !reflect.DeepEqual(got, cnt) {
+		t.Errorf("got %v, want %v", got, cnt)
+	}
+
+	// both writers read cid2, the second one loses
+	first := triefs.NewContent("test.txt", "cid4", 400, triefs.MIMEOctetStream, now)
+	second := triefs.NewContent("test.txt", "cid5", 500, triefs.MIMEOctetStream, now)
+	if err := trie.ReplaceIfCID("/home/test.txt", cnt.CID, &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := trie.ReplaceIfCID("/home/test.txt", cnt.CID, &second); err != triefs.ErrCIDMismatch {
+		t.Errorf("got %v, want %v", err, triefs.ErrCIDMismatch)
+	}
+	cnt, err = trie.File("/home/test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cnt.CID != "cid4" || cnt.Size != 400 || cnt.Version != 3 {
+		t.Errorf("got %v, want cid4 of size 400 and version 3", cnt)
+	}
+
+	for _, path := range []string{"/home/missing.txt", "/home", "/"} {
+		if err := trie.ReplaceIfCID(path, "", &c); err != triefs.ErrFileNotExist {
+			t.Errorf("%v: got %v, want %v", path, err, triefs.ErrFileNotExist)
+		}
+	}
+}
+
+func TestReplaceCoalesced(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(10000, 0)
+	trie, err := triefs.NewTrieWithOptions(triefs.Options{Clock: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/home/doc.txt", "cid0", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		after     time.Duration
+		coalesced bool
+		version   byte
+	}{
+		{name: "first replace", version: 2},
+		{name: "within the window", after: 2 * time.Second, coalesced: true, version: 2},
+		{name: "window starts at the last replace", after: 4 * time.Second, coalesced: true, version: 2},
+		{name: "after the window", after: 10 * time.Second, version: 3},
+		{name: "new window", after: time.Second, coalesced: true, version: 3},
+	}
+	for i, tc := range cases {
+		now = now.Add(tc.after)
+		cid := "cid" + strconv.Itoa(i+1)
+		c := triefs.NewContent("doc.txt", cid, int64(i+1), triefs.MIMEOctetStream, now)
+		got, coalesced, err := trie.ReplaceCoalesced("/home/doc.txt", &c, 5*time.Second)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tc.name, err)
+		}
+		if coalesced != tc.coalesced {
+			t.Errorf("%v: got %v, want %v", tc.name, coalesced, tc.coalesced)
+		}
+		if got.CID != cid || got.Version != tc.version || got.ModifiedAt != now.Unix() {
+			t.Errorf("%v: got %v, want %v of version %v modified at %v", tc.name, got, cid, tc.version, now.Unix())
+		}
+		if f, _ := trie.File("/home/doc.txt"); !reflect.DeepEqual(f, got) {
+			t.Errorf("%v: got %v, want %v", tc.name, f, got)
+		}
+	}
+
+	c := triefs.NewContent("doc.txt", "cid", 1, triefs.MIMEOctetStream, now)
+	for _, path := range []string{"/home/missing.txt", "/home"} {
+		if _, _, err := trie.ReplaceCoalesced(path, &c, time.Minute); err != triefs.ErrFileNotExist {
+			t.Errorf("%v: got %v, want %v", path, err, triefs.ErrFileNotExist)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	if _, err := trie.AddFile(triefs.NewEntry("/home/dir/file.txt", "cid1", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatal(err)
+	}
+
+	c := triefs.NewContent("new.txt", "cid2", 2, "text/plain", now)
+	created, err := trie.Upsert("/docs/new.txt", &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Errorf("got %v, want %v", created, true)
+	}
+	cnt, err := trie.File("/docs/new.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cnt.Name != "new.txt" || cnt.CID != "cid2" || cnt.Type != "text/plain" || cnt.Version != 1 || cnt.ModifiedAt != 0 {
+		t.Errorf("got %v, want new.txt with cid2 of version 1", cnt)
+	}
+
+	c = triefs.NewContent("file.txt", "cid3", 3, triefs.MIMEOctetStream, now)
+	c.SetMetadata("k", "v")
+	created, err = trie.Upsert("/home/dir/file.txt", &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Errorf("got %v, want %v", created, false)
+	}
+	cnt, err = trie.File("/home/dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cnt.CID != "cid3" || cnt.Size != 3 || cnt.Version != 2 || cnt.Metadata["k"] != "v" {
+		t.Errorf("got %v, want cid3 of size 3 and version 2", cnt)
+	}
+	if cnt.ModifiedAt < now.Unix() {
+		t.Errorf("got %v, want at least %v", cnt.ModifiedAt, now.Unix())
+	}
+
+	for _, path := range []string{"/home/dir", "/home", "/"} {
+		if _, err := trie.Upsert(path, &c); err != triefs.ErrConflict {
+			t.Errorf("%v: got %v, want %v", path, err, triefs.ErrConflict)
+		}
+	}
+	dir := triefs.NewContent("", "", 0, triefs.MIMEDriveEntry, now)
+	if _, err := trie.Upsert("/other", &dir); err != triefs.ErrCantAddDirectory {
+		t.Errorf("got %v, want %v", err, triefs.ErrCantAddDirectory)
+	}
+	if _, err := trie.Upsert("/home/dir/file.txt/nested", &c); err == nil {
+		t.Errorf("expected error for a path under a file")
+	}
+}
+
+func TestFuzzyCreateRef(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	t.Parallel()
+	now := time.Now()
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < 50000; i++ {
+		trie := triefs.NewTrie()
+
+		// paths that are stored in the trie
+		paths := createRandomFiles(trie, 10)
+
+		if i%2 == 0 {
+			// pick from the inserted data
+			dataIndex := r.Intn(len(paths))
 			path := paths[dataIndex]
 			_, _ = trie.CreateRef(path, "", now)
 		} else {
@@ -4151,69 +5161,258 @@ func TestDeepCopyOnAdd(t *testing.T) {
 	}
 }
 
-func TestDeepCopyOnCopy(t *testing.T) {
+func TestDeepCopyMetadataOnAdd(t *testing.T) {
 	t.Parallel()
 
 	now := time.Now()
+	trie := triefs.NewTrie()
 
-	original := &triefs.Entry{
-		Content: triefs.Content{
-			Name:      "file",
-			CID:       "cid1",
-			Type:      triefs.MIMEOctetStream,
-			Size:      100,
-			Version:   1,
-			CreatedAt: now.Unix(),
-		},
-		Path: "/dir/file",
-		Entries: []*triefs.Entry{
-			{
-				Content: triefs.Content{
-					Name:      "child",
-					CID:       "cid2",
-					Type:      triefs.MIMEOctetStream,
-					Size:      50,
-					Version:   1,
-					CreatedAt: now.Unix(),
-				},
-				Path: "/dir/file/child",
-			},
-		},
+	first := triefs.NewEntry("/docs/first", "cid0", 10, triefs.MIMEOctetStream, now)
+	first.SetMetadata("acl", "private")
+	_, err := trie.AddFile(first)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	// Use the exported Copy method
-	copied := &triefs.Entry{}
-	copied.Copy(original)
+	child := triefs.NewEntry("/docs/readme", "cid1", 100, triefs.MIMEOctetStream, now)
+	child.SetMetadata("checksum", "abc")
+	_, err = trie.AddFile(child)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	// Verify the copy matches
-	if copied.Content.CID != "cid1" {
-		t.Errorf("got %v, want %v", copied.Content.CID, "cid1")
+	// Mutate the original maps that were passed to AddFile
+	first.Metadata["acl"] = "corrupted"
+	child.Metadata["checksum"] = "corrupted"
+	child.Metadata["extra"] = "corrupted"
+
+	f, err := trie.File("/docs/first")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if copied.Entries[0].Content.CID != "cid2" {
-		t.Errorf("got %v, want %v", copied.Entries[0].Content.CID, "cid2")
+	if f.Metadata["acl"] != "private" {
+		t.Errorf("got %v, want %v", f.Metadata["acl"], "private")
 	}
 
-	// Mutate the original's child
-	original.Entries[0].Content.CID = "corrupted"
-	original.Entries = append(original.Entries, &triefs.Entry{
-		Path: "/extra",
-	})
-
-	// The copy must be unaffected
-	if copied.Entries[0].Content.CID != "cid2" {
-		t.Errorf("got %v, want %v", copied.Entries[0].Content.CID, "cid2")
+	f, err = trie.File("/docs/readme")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if len(copied.Entries) != 1 {
-		t.Errorf("got %v, want %v", len(copied.Entries), 1)
+	if !reflect.DeepEqual(f.Metadata, map[string]string{"checksum": "abc"}) {
+		t.Errorf("got %v, want %v", f.Metadata, map[string]string{"checksum": "abc"})
 	}
-}
 
-func TestCommonPrefixSplit(t *testing.T) {
-	t.Parallel()
+	// Mutating a returned copy must not leak into the trie either
+	f.Metadata["checksum"] = "corrupted"
+	f, err = trie.File("/docs/readme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Metadata["checksum"] != "abc" {
+		t.Errorf("got %v, want %v", f.Metadata["checksum"], "abc")
+	}
 
-	now := time.Now()
+	// Replace without metadata keeps it, replace with metadata overwrites it
+	_, _, err = trie.Replace("/docs/readme", &triefs.Content{CID: "cid2", Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ = trie.File("/docs/readme")
+	if f.Metadata["checksum"] != "abc" {
+		t.Errorf("got %v, want %v", f.Metadata["checksum"], "abc")
+	}
+	_, _, err = trie.Replace("/docs/readme", &triefs.Content{CID: "cid3", Size: 1, Metadata: map[string]string{"checksum": "def"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ = trie.File("/docs/readme")
+	if f.Metadata["checksum"] != "def" {
+		t.Errorf("got %v, want %v", f.Metadata["checksum"], "def")
+	}
 
-	cases := []struct {
+	// Metadata survives JSON roundtrip
+	data, err := json.Marshal(trie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trie2 := triefs.Trie{}
+	err = json.Unmarshal(data, &trie2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = trie2.File("/docs/readme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Metadata["checksum"] != "def" {
+		t.Errorf("got %v, want %v", f.Metadata["checksum"], "def")
+	}
+}
+
+func TestHashChanges(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	cases := []struct {
+		name    string
+		change  func(trie *triefs.Trie) error
+		changed bool
+	}{
+		{
+			name: "create ref",
+			change: func(trie *triefs.Trie) error {
+				_, err := trie.CreateRef("/a/file", "bucket", now)
+				return err
+			},
+			changed: true,
+		},
+		{
+			name: "replace",
+			change: func(trie *triefs.Trie) error {
+				_, _, err := trie.Replace("/a/file", &triefs.Content{Name: "file", CID: "cid2", Size: 1, Type: triefs.MIMEOctetStream})
+				return err
+			},
+			changed: true,
+		},
+		{
+			name:    "delete",
+			change:  func(trie *triefs.Trie) error { return trie.Delete("/a/file") },
+			changed: true,
+		},
+		{
+			name:    "move keeping contents",
+			change:  func(trie *triefs.Trie) error { return trie.Move("/a", "/b") },
+			changed: true,
+		},
+		{
+			name: "re-add same file",
+			change: func(trie *triefs.Trie) error {
+				_, err := trie.AddFile(triefs.NewEntry("/a/file", "cid", 1, triefs.MIMEOctetStream, now))
+				if err != triefs.ErrConflict {
+					return err
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := triefs.NewTrie()
+			if _, err := trie.AddFile(triefs.NewEntry("/a/file", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			before, err := trie.Hash()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := tc.change(trie); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			after, err := trie.Hash()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed := before != after; changed != tc.changed {
+				t.Errorf("got changed %v, want %v", changed, tc.changed)
+			}
+		})
+	}
+}
+
+func TestHashMetadata(t *testing.T) {
+	now := time.Now()
+	trie1 := triefs.NewTrie()
+	trie2 := triefs.NewTrie()
+
+	e1 := triefs.NewEntry("/file", "cid", 1, triefs.MIMEOctetStream, now)
+	e1.SetMetadata("k", "v1")
+	e2 := triefs.NewEntry("/file", "cid", 1, triefs.MIMEOctetStream, now)
+	e2.SetMetadata("k", "v2")
+	if _, err := trie1.AddFile(e1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trie2.AddFile(e2); err != nil {
+		t.Fatal(err)
+	}
+
+	hash1, err := trie1.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash2, err := trie2.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash1 == hash2 {
+		t.Errorf("hashes should be different")
+	}
+}
+
+func TestDeepCopyOnCopy(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	original := &triefs.Entry{
+		Content: triefs.Content{
+			Name:      "file",
+			CID:       "cid1",
+			Type:      triefs.MIMEOctetStream,
+			Size:      100,
+			Version:   1,
+			CreatedAt: now.Unix(),
+		},
+		Path: "/dir/file",
+		Entries: []*triefs.Entry{
+			{
+				Content: triefs.Content{
+					Name:      "child",
+					CID:       "cid2",
+					Type:      triefs.MIMEOctetStream,
+					Size:      50,
+					Version:   1,
+					CreatedAt: now.Unix(),
+				},
+				Path: "/dir/file/child",
+			},
+		},
+	}
+
+	// Use the exported Copy method
+	copied := &triefs.Entry{}
+	copied.Copy(original)
+
+	// Verify the copy matches
+	if copied.Content.CID != "cid1" {
+		t.Errorf("got %v, want %v", copied.Content.CID, "cid1")
+	}
+	if copied.Entries[0].Content.CID != "cid2" {
+		t.Errorf("got %v, want %v", copied.Entries[0].Content.CID, "cid2")
+	}
+
+	// Mutate the original's child
+	original.Entries[0].Content.CID = "corrupted"
+	original.Entries = append(original.Entries, &triefs.Entry{
+		Path: "/extra",
+	})
+
+	// The copy must be unaffected
+	if copied.Entries[0].Content.CID != "cid2" {
+		t.Errorf("got %v, want %v", copied.Entries[0].Content.CID, "cid2")
+	}
+	if len(copied.Entries) != 1 {
+		t.Errorf("got %v, want %v", len(copied.Entries), 1)
+	}
+}
+
+func TestCommonPrefixSplit(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	cases := []struct {
 		name    string
 		paths   []string
 		lsPath  string
@@ -4625,8 +5824,1092 @@ func TestUTF8Paths(t *testing.T) {
 		// Create an entry with invalid UTF-8 bytes in the path
 		entry := triefs.NewEntry("/bad/\xff\xfe.txt", "cid", 10, triefs.MIMEOctetStream, now)
 		_, err := trie.AddFile(entry)
-		if err == nil {
-			t.Fatal("expected error for invalid UTF-8 path, got nil")
+		if err != triefs.ErrInvalidUTF8 {
+			t.Fatalf("got %v, want %v", err, triefs.ErrInvalidUTF8)
+		}
+	})
+}
+
+func TestSplitSharedRuneBytes(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	// 😀 U+1F600 and 😁 U+1F601 share 3 of 4 bytes, 🙀 U+1F640 shares 2
+	cases := []struct {
+		name  string
+		paths []string
+	}{
+		{name: "names", paths: []string{"/😀", "/😁", "/🙀"}},
+		{name: "prefixed names", paths: []string{"/x/a😀", "/x/a😁", "/x/a😀b"}},
+		{name: "directories", paths: []string{"/😀/file", "/😁/file", "/😀😁/file"}},
+		{name: "file and directory", paths: []string{"/d/😀", "/d/😁/file", "/d/😀😀"}},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			for _, order := range [][]string{tc.paths, {tc.paths[2], tc.paths[1], tc.paths[0]}} {
+				trie := triefs.NewTrie()
+				for _, p := range order {
+					if _, err := trie.AddFile(triefs.NewEntry(p, "cid-"+p, 1, triefs.MIMEOctetStream, now)); err != nil {
+						t.Fatalf("%v: unexpected error: %v", p, err)
+					}
+				}
+				if err := trie.Validate(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				for _, p := range tc.paths {
+					f, err := trie.File(p)
+					if err != nil || f.CID != "cid-"+p {
+						t.Errorf("%v: got %v %v, want %v", p, f, err, "cid-"+p)
+					}
+				}
+
+				// removing one leaves the others retrievable
+				if err := trie.Delete(order[0]); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				for _, p := range order[1:] {
+					if f, err := trie.File(p); err != nil || f.CID != "cid-"+p {
+						t.Errorf("%v: got %v %v, want %v", p, f, err, "cid-"+p)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		path string
+		err  error
+	}{
+		{path: "/some/dir/file.txt"},
+		{path: "/"},
+		{path: "", err: triefs.ErrEmptyPath},
+		{path: "/bad/\xff\xfe.txt", err: triefs.ErrInvalidUTF8},
+		{path: "/some:dir", err: triefs.ErrIllegalPathChars},
+	}
+
+	for _, tc := range cases {
+		if err := triefs.ValidatePath(tc.path); err != tc.err {
+			t.Errorf("%q: got %v, want %v", tc.path, err, tc.err)
+		}
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		err  error
+	}{
+		{name: "file.txt"},
+		{name: "", err: triefs.ErrEmptyName},
+		{name: "\xff\xfe.txt", err: triefs.ErrInvalidUTF8},
+		{name: "some/file", err: triefs.ErrIllegalNameChars},
+		{name: "some:file", err: triefs.ErrIllegalNameChars},
+	}
+
+	for _, tc := range cases {
+		if err := triefs.ValidateName(tc.name); err != tc.err {
+			t.Errorf("%q: got %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestAncestors(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/a/b/c/deep.txt", "cid1", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/top.txt", "cid2", 2, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		path     string
+		expected []string
+		err      error
+	}{
+		{name: "deep file", path: "/a/b/c/deep.txt", expected: []string{"a", "b", "c"}},
+		{name: "directory", path: "/a/b/", expected: []string{"a"}},
+		{name: "top level file", path: "/top.txt", expected: []string{}},
+		{name: "root", path: "/", expected: []string{}},
+		{name: "missing", path: "/a/b/missing.txt", err: triefs.ErrFileNotExist},
+		{name: "under file", path: "/top.txt/file", err: triefs.ErrFileNotExist},
+		{name: "empty", path: "", err: triefs.ErrEmptyPath},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ancestors, err := trie.Ancestors(tc.path)
+			if err != tc.err {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			names := make([]string, 0)
+			for _, c := range ancestors {
+				if !c.IsDirectory() {
+					t.Errorf("got %v, want directory", c)
+				}
+				names = append(names, c.Name)
+			}
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("got %v, want %v", names, tc.expected)
+			}
+		})
+	}
+}
+
+func TestListSince(t *testing.T) {
+	t.Parallel()
+	old := time.Unix(1000, 0)
+	now := time.Unix(2000, 0)
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/old/file.txt", "cid1", 1, triefs.MIMEOctetStream, old),
+		triefs.NewEntry("/old/empty", "", 0, triefs.MIMEDriveEntry, old),
+		triefs.NewEntry("/new/file.txt", "cid2", 2, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/new/empty", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/old/new.txt", "cid3", 3, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// sub-second part of since is ignored
+	paths := make([]string, 0)
+	for _, e := range trie.ListSince(now.Add(999 * time.Millisecond)) {
+		paths = append(paths, e.Path)
+	}
+	expected := []string{"/new", "/new/empty", "/new/file.txt", "/old/new.txt"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("got %v, want %v", paths, expected)
+	}
+
+	if got := trie.ListSince(old); len(got) != 7 {
+		t.Errorf("got %v entries, want %v", len(got), 7)
+	}
+	if got := trie.ListSince(now.Add(time.Second)); len(got) != 0 {
+		t.Errorf("got %v, want no entries", got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/docs/small.txt", "cid1", 10, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/big.bin", "cid2", 2<<20, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/shared/a/file", "cid3", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/shared/b/huge.bin", "cid4", 5<<20, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/other/c/file", "cid5", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/empty", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{"/shared/a", "/other/c"} {
+		if _, err := trie.CreateRef(p, "bucket", now); err != nil {
+			t.Fatal(err)
 		}
+	}
+
+	cases := []struct {
+		name     string
+		pred     func(path string, c *triefs.Content) bool
+		expected []string
+	}{
+		{
+			name:     "size threshold",
+			pred:     func(_ string, c *triefs.Content) bool { return c.Size > 1<<20 },
+			expected: []string{"/docs/big.bin", "/shared/b/huge.bin"},
+		},
+		{
+			name:     "references",
+			pred:     func(_ string, c *triefs.Content) bool { return c.Type == triefs.MIMEReference },
+			expected: []string{"/other/c", "/shared/a"},
+		},
+		{
+			name:     "directories",
+			pred:     func(_ string, c *triefs.Content) bool { return c.IsDirectory() },
+			expected: []string{"/docs", "/empty", "/other", "/shared", "/shared/b"},
+		},
+		{
+			name:     "none",
+			pred:     func(string, *triefs.Content) bool { return false },
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			paths := make([]string, 0)
+			for _, e := range trie.Filter(tc.pred) {
+				paths = append(paths, e.Path)
+			}
+			if !reflect.DeepEqual(paths, tc.expected) {
+				t.Errorf("got %v, want %v", paths, tc.expected)
+			}
+		})
+	}
+
+	// pred sees the same absolute paths as returned
+	visited := make([]string, 0)
+	trie.Filter(func(path string, _ *triefs.Content) bool {
+		visited = append(visited, path)
+		return false
 	})
+	if got := len(trie.LsRecursive("/")); len(visited) != got {
+		t.Errorf("got %v, want %v", visited, got)
+	}
+}
+
+func TestNearestAncestor(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/abcab/folder1", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/adcac/fdir2/file", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/afcad/fdir1/file", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/akcab1/file/file", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/adcac/fdir3/file", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		path     string
+		expected string
+		cntName  string
+		exists   bool
+	}{
+		{name: "missing directory", path: "/adcac/fdir", expected: "/adcac", cntName: "adcac"},
+		{name: "missing deep path", path: "/adcac/fdir2/file/a/b", expected: "/adcac/fdir2/file", cntName: "file"},
+		{name: "under file", path: "/akcab1/file/file/x", expected: "/akcab1/file/file", cntName: "file"},
+		{name: "existing", path: "/adcac/fdir3/", expected: "/adcac/fdir3", cntName: "fdir3", exists: true},
+		{name: "missing under root", path: "/a/b", expected: "/", cntName: "/"},
+		{name: "root", path: "/", expected: "/", cntName: "/", exists: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			path, c, exists := trie.NearestAncestor(tc.path)
+			if path != tc.expected {
+				t.Errorf("got %v, want %v", path, tc.expected)
+			}
+			if c == nil || c.Name != tc.cntName {
+				t.Errorf("got %v, want content named %v", c, tc.cntName)
+			}
+			if exists != tc.exists {
+				t.Errorf("got %v, want %v", exists, tc.exists)
+			}
+		})
+	}
+}
+
+func TestLsRecursiveSorted(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	paths := []string{"/a/b/file", "/a b/file", "/a/b.txt", "/a-b", "/a/b/c/d", "/a/ab"}
+	expected := []string{
+		"/a", "/a/ab", "/a/b", "/a/b/c", "/a/b/c/d", "/a/b/file", "/a/b.txt",
+		"/a b", "/a b/file", "/a-b",
+	}
+
+	for _, order := range [][]int{{0, 1, 2, 3, 4, 5}, {5, 4, 3, 2, 1, 0}, {2, 5, 0, 3, 1, 4}} {
+		trie := triefs.NewTrie()
+		for _, i := range order {
+			if _, err := trie.AddFile(triefs.NewEntry(paths[i], "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got := make([]string, 0)
+		for _, e := range trie.LsRecursiveSorted("/") {
+			got = append(got, e.Path)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("order %v: got %v, want %v", order, got, expected)
+		}
+
+		got = got[:0]
+		for _, e := range trie.LsRecursiveSorted("/a") {
+			got = append(got, e.Path)
+		}
+		if relative := []string{"/ab", "/b", "/b/c", "/b/c/d", "/b/file", "/b.txt"}; !reflect.DeepEqual(got, relative) {
+			t.Errorf("order %v: got %v, want %v", order, got, relative)
+		}
+	}
+}
+
+func TestIsMarker(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/f", "cid1", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/fiee/file", "cid2", 2, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/file", "cid3", 3, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/files/", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/files/empty", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/files/a", "cid4", 4, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/fi", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	markers := 0
+	var count func(e *triefs.Entry)
+	count = func(e *triefs.Entry) {
+		if e.IsMarker() {
+			markers++
+		}
+		for _, me := range e.Entries {
+			count(me)
+		}
+	}
+	count(trie.Root)
+	if markers == 0 {
+		t.Fatalf("got no markers in %v", trie.Root)
+	}
+
+	leaks := func(p string) bool {
+		if strings.Contains(p, triefs.SpecialPathSymbol) {
+			return true
+		}
+		if p == triefs.Separator {
+			return false
+		}
+		for _, s := range strings.Split(p, triefs.Separator)[1:] {
+			if len(s) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, e := range trie.LsRecursive("/") {
+		if e.IsMarker() || leaks(e.Path) || strings.Contains(e.Name, triefs.SpecialPathSymbol) {
+			t.Errorf("LsRecursive: got marker %v", e)
+		}
+	}
+	for _, dir := range []string{"/", "/files", "/files/empty", "/fi", "/fiee"} {
+		for _, c := range trie.Ls(dir) {
+			if len(c.Name) == 0 || strings.Contains(c.Name, triefs.SpecialPathSymbol) {
+				t.Errorf("Ls %v: got marker %v", dir, c)
+			}
+		}
+		entries, err := trie.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, e := range entries {
+			if e.IsMarker() || leaks(e.Path) {
+				t.Errorf("ReadDir %v: got marker %v", dir, e)
+			}
+		}
+	}
+	for _, p := range trie.Paths() {
+		if leaks(p) {
+			t.Errorf("Paths: got marker %v", p)
+		}
+	}
+	err := trie.Walk("/", func(p string, c *triefs.Content) error {
+		if leaks(p) || strings.Contains(c.Name, triefs.SpecialPathSymbol) {
+			t.Errorf("Walk: got marker %v", p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var check func(name string, e *triefs.Entry)
+	check = func(name string, e *triefs.Entry) {
+		if e.IsMarker() || strings.Contains(e.Name, triefs.SpecialPathSymbol) {
+			t.Errorf("%v: got marker %v", name, e)
+		}
+		for _, me := range e.Entries {
+			check(name, me)
+		}
+	}
+	check("Tree", trie.Tree("/"))
+	check("TreeAll", trie.TreeAll("/"))
+}
+
+func TestStatDeep(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/a/file", "cid1", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/a/b/file", "cid1", 2, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/a/b/c/file", "cid2", 4, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/a/empty", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/d/file", "cid3", 8, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/a/ref", "cid4", 16, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := trie.CreateRef("/a/ref", "bucket", now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		path string
+		size int64
+	}{
+		{path: "/", size: 15},
+		{path: "/a", size: 7},
+		{path: "/a/b/", size: 6},
+		{path: "/a/b/c", size: 4},
+		{path: "/a/empty", size: 0},
+		{path: "/a/b/file", size: 2},
+	}
+	for _, tc := range cases {
+		deep, err := trie.StatDeep(tc.path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if deep.Size != tc.size {
+			t.Errorf("%v: got %v, want %v", tc.path, deep.Size, tc.size)
+		}
+		usage, err := trie.DiskUsage(tc.path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if usage != tc.size {
+			t.Errorf("%v: got %v, want %v", tc.path, usage, tc.size)
+		}
+
+		c, err := trie.Stat(tc.path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if c.IsDirectory() {
+			if c.Size != 0 {
+				t.Errorf("%v: got %v, want %v", tc.path, c.Size, 0)
+			}
+			c.Size = tc.size
+		}
+		if !reflect.DeepEqual(deep, c) {
+			t.Errorf("%v: got %v, want %v", tc.path, deep, c)
+		}
+	}
+
+	if _, err := trie.StatDeep("/missing"); err != triefs.ErrFileNotExist {
+		t.Errorf("got %v, want %v", err, triefs.ErrFileNotExist)
+	}
+	if _, err := trie.DiskUsage("/a/missing"); err != triefs.ErrFileNotExist {
+		t.Errorf("got %v, want %v", err, triefs.ErrFileNotExist)
+	}
+	if _, err := trie.StatDeep(""); err != triefs.ErrEmptyPath {
+		t.Errorf("got %v, want %v", err, triefs.ErrEmptyPath)
+	}
+}
+
+func TestReservedChars(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	reserved := triefs.ReservedChars()
+	if len(reserved) == 0 {
+		t.Fatalf("got no reserved characters")
+	}
+
+	for _, r := range reserved {
+		path := "/some" + string(r) + "dir/file"
+		if err := triefs.ValidatePath(path); err != triefs.ErrIllegalPathChars {
+			t.Errorf("%q: got %v, want %v", path, err, triefs.ErrIllegalPathChars)
+		}
+		if err := triefs.ValidateName("file" + string(r)); err != triefs.ErrIllegalNameChars {
+			t.Errorf("%q: got %v, want %v", r, err, triefs.ErrIllegalNameChars)
+		}
+		trie := triefs.NewTrie()
+		if _, err := trie.AddFile(triefs.NewEntry(path, "cid", 1, triefs.MIMEOctetStream, now)); err == nil {
+			t.Errorf("%q: expected error", path)
+		}
+	}
+}
+
+func TestLsGrouped(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/aaa/fbb/f", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/file", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/fiee/file", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/fieeolder_emtpty", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/aaa/link", "file", 0, triefs.MIMESymlink, now),
+		triefs.NewEntry("/aaa/shared/file", "test_cid", 512, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := trie.CreateRef("/aaa/shared", "bucket", now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := func(contents []*triefs.Content) []string {
+		res := make([]string, 0, len(contents))
+		for _, c := range contents {
+			res = append(res, c.Name)
+		}
+		return res
+	}
+
+	dirs, files, refs := trie.LsGrouped("/aaa")
+	if got, want := names(dirs), []string{"fbb", "fiee", "fieeolder_emtpty"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := names(files), []string{"file", "link"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := names(refs), []string{"shared"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	all := append(append(append([]*triefs.Content{}, dirs...), files...), refs...)
+	if got, want := len(all), len(trie.Ls("/aaa")); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	dirs, files, refs = trie.LsGrouped("/missing")
+	if len(dirs) != 0 || len(files) != 0 || len(refs) != 0 {
+		t.Errorf("got %v %v %v, want empty groups", dirs, files, refs)
+	}
+}
+
+func TestAmbiguousNames(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+
+	// the "add file that is also sub-path" trie, f and file are different names
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/aaa/bbb/f", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/f", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/file/file", "test_cid", 512, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := trie.AmbiguousNames("/aaa"); len(got) != 0 {
+		t.Errorf("got %v, want none", got)
+	}
+	// AddFile refuses to put a directory over a file
+	if _, err := trie.AddFile(triefs.NewEntry("/aaa/f/x", "test_cid", 512, triefs.MIMEOctetStream, now)); err != triefs.ErrConflict {
+		t.Errorf("got %v, want %v", err, triefs.ErrConflict)
+	}
+
+	// a decoded trie may still hold f both as a file and a directory
+	trie = triefs.NewTrie()
+	trie.Root = &triefs.Entry{
+		Content: triefs.NewContent("", "", 0, triefs.MIMEDriveEntry, now),
+		Path:    "/aaa/f",
+		Entries: []*triefs.Entry{
+			{Content: triefs.NewContent("f", "test_cid", 512, triefs.MIMEOctetStream, now), Path: ":"},
+			{Content: triefs.NewContent("x", "test_cid", 512, triefs.MIMEOctetStream, now), Path: "/x"},
+		},
+	}
+	if got, want := trie.AmbiguousNames("/aaa"), []string{"f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := trie.AmbiguousNames("/aaa/f"); len(got) != 0 {
+		t.Errorf("got %v, want none", got)
+	}
+	if got := triefs.NewTrie().AmbiguousNames("/"); len(got) != 0 {
+		t.Errorf("got %v, want none", got)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	cases := []struct {
+		name     string
+		entry    *triefs.Entry
+		expected *triefs.Entry
+	}{
+		{
+			name: "file",
+			entry: &triefs.Entry{
+				Content: triefs.Content{Name: "other", CID: "cid", Size: 512, CreatedAt: now.Unix()},
+				Path:    "aaa//bbb/file/",
+			},
+			expected: triefs.NewEntry("/aaa/bbb/file", "cid", 512, triefs.MIMEOctetStream, now),
+		},
+		{
+			name: "file keeps version",
+			entry: &triefs.Entry{
+				Content: triefs.Content{Name: "file", CID: "cid", Type: "text/plain", Version: 3, CreatedAt: now.Unix()},
+				Path:    "/file",
+			},
+			expected: &triefs.Entry{
+				Content: triefs.Content{Name: "file", CID: "cid", Type: "text/plain", Version: 3, CreatedAt: now.Unix()},
+				Path:    "/file",
+			},
+		},
+		{
+			name: "empty folder",
+			entry: &triefs.Entry{
+				Content: triefs.Content{Name: "dir", CID: "cid", Size: 1, Type: triefs.MIMEDriveEntry, Version: 1, CreatedAt: now.Unix()},
+				Path:    "/aaa/dir/",
+			},
+			expected: triefs.NewEntry("/aaa/dir", "", 0, triefs.MIMEDriveEntry, now),
+		},
+		{
+			name: "directory",
+			entry: &triefs.Entry{
+				Content: triefs.Content{Name: "dir", CID: "cid", Size: 1, Type: triefs.MIMEDriveDirectory, CreatedAt: now.Unix()},
+				Path:    "/dir",
+			},
+			expected: &triefs.Entry{
+				Content: triefs.Content{Name: "dir", Type: triefs.MIMEDriveDirectory, CreatedAt: now.Unix()},
+				Path:    "/dir",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc.entry.Normalize()
+		if !reflect.DeepEqual(tc.entry, tc.expected) {
+			t.Errorf("%v: got %v, want %v", tc.name, tc.entry, tc.expected)
+		}
+	}
+
+	// AddFile normalizes hand-built entries, so they end up like NewEntry ones
+	built, added := triefs.NewTrie(), triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/aaa/bbb/file", "cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/dir", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := built.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for _, e := range []*triefs.Entry{
+		{Content: triefs.Content{Name: "other", CID: "cid", Size: 512, CreatedAt: now.Unix()}, Path: "aaa//bbb/file/"},
+		{Content: triefs.Content{Name: "dir", Type: triefs.MIMEDriveEntry, CreatedAt: now.Unix()}, Path: "/aaa/dir"},
+	} {
+		if _, err := added.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(added.Root, built.Root) {
+		t.Errorf("got %v, want %v", added.Root, built.Root)
+	}
+	if err := added.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNode(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/file", "cid1", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/folder/testfile1", "cid2", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/folder/testfile1-copy", "cid3", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/folder1/file", "cid4", 512, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		path   string
+		label  string
+		marker bool
+		found  bool
+	}{
+		{path: "/f", label: "/f", found: true},
+		{path: "/file", label: "ile", found: true},
+		{path: "/folder1/file", label: "1/file", found: true},
+		{path: "/folder/testfile1", label: "/testfile1", marker: true, found: true},
+		{path: "/folder/testfile1-copy", label: "-copy", found: true},
+		{path: "/fo"},
+		{path: "/folder/test"},
+		{path: "/missing"},
+		{path: "/"},
+	}
+
+	for _, tc := range cases {
+		e, ok := trie.Node(tc.path)
+		if ok != tc.found {
+			t.Fatalf("%v: got %v, want %v", tc.path, ok, tc.found)
+		}
+		if !ok {
+			continue
+		}
+		if e.Path != tc.label {
+			t.Errorf("%v: got %q, want %q", tc.path, e.Path, tc.label)
+		}
+		if tc.path == "/f" {
+			continue
+		}
+
+		// a file marked inside a split node is kept by its marker
+		c := &e.Content
+		if tc.marker {
+			if len(e.Entries) == 0 || !e.Entries[0].IsMarker() {
+				t.Fatalf("%v: got %v, want a marker child", tc.path, e.Entries)
+			}
+			c = &e.Entries[0].Content
+		}
+		f, err := trie.File(tc.path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(c, f) {
+			t.Errorf("%v: got %v, want %v", tc.path, c, f)
+		}
+	}
+
+	// returned nodes are copies
+	e, _ := trie.Node("/file")
+	e.CID = "changed"
+	if f, _ := trie.File("/file"); f.CID != "cid1" {
+		t.Errorf("got %v, want %v", f.CID, "cid1")
+	}
+}
+
+func TestSubtree(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/aaa", "test_cid", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/aaa/file1.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/file2.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/bbb", "test_cid", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/aaa/bba/file", "test_cid", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/bbb/file1.txt", "test_cid", 0, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	sub, err := trie.Subtree("/aaa/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := sub.Ls("/"), trie.Ls("/aaa"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := sub.Ls("/bba"), trie.Ls("/aaa/bba"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// changes don't leak between the tries
+	if err := sub.Delete("/file1.txt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.Stat("/aaa/file1.txt"); err != nil {
+		t.Errorf("got %v, want %v", err, nil)
+	}
+	if _, _, err := trie.Replace("/aaa/file2.txt", &triefs.Content{CID: "changed"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c, err := sub.Stat("/file2.txt"); err != nil || c.CID != "test_cid" {
+		t.Errorf("got %v %v, want %v", c, err, "test_cid")
+	}
+
+	all, err := trie.Subtree("/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := all.Paths(), trie.Paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	errCases := []struct {
+		path string
+		err  error
+	}{
+		{path: "/missing", err: triefs.ErrFileNotExist},
+		{path: "/aaa/file2.txt", err: triefs.ErrNotADirectory},
+		{path: "", err: triefs.ErrEmptyPath},
+	}
+	for _, tc := range errCases {
+		_, err := trie.Subtree(tc.path)
+		if err != tc.err {
+			t.Errorf("%v: got %v, want %v", tc.path, err, tc.err)
+		}
+	}
+}
+
+func TestMount(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/aaa/file1.txt", "cid1", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/bbb", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/aaa/bba/file", "cid2", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/ccc/file", "cid3", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/ddd/bba/other", "cid4", 1, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	sub, err := trie.Subtree("/aaa")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// missing parents are created and existing directories are merged
+	for _, p := range []string{"/new/mount", "/ddd"} {
+		if err := trie.Mount(p, sub); err != nil {
+			t.Fatalf("%v: unexpected error: %v", p, err)
+		}
+		mounted, err := trie.Subtree(p)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p == "/ddd" {
+			if err := mounted.Delete("/bba/other"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if !triefs.EqualExcept(mounted, sub, true) {
+			t.Errorf("%v: got %v, want %v", p, mounted.Paths(), sub.Paths())
+		}
+	}
+
+	empty := triefs.NewTrie()
+	if err := trie.Mount("/empty/dir", empty); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c, err := trie.Stat("/empty/dir"); err != nil || !c.IsDirectory() {
+		t.Errorf("got %v %v, want a directory", c, err)
+	}
+
+	before := trie.Paths()
+	errCases := []struct {
+		path string
+		err  error
+	}{
+		{path: "/aaa", err: triefs.ErrConflict},
+		{path: "/ccc/file", err: triefs.ErrConflict},
+		{path: "/ccc/file/under", err: triefs.ErrConflict},
+		{path: "", err: triefs.ErrEmptyPath},
+	}
+	for _, tc := range errCases {
+		if err := trie.Mount(tc.path, sub); err != tc.err {
+			t.Errorf("%v: got %v, want %v", tc.path, err, tc.err)
+		}
+	}
+	if got := trie.Paths(); !reflect.DeepEqual(got, before) {
+		t.Errorf("got %v, want %v", got, before)
+	}
+}
+
+func TestLsAtDepth(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/aaa/file1.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/bbb/file2.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/bba", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/bbb/ccc/ddd/file3.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/file.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for _, p := range []string{"/", "/aaa", "/bbb/ccc"} {
+		ls := trie.Ls(p)
+		entries := trie.LsAtDepth(p, 1)
+		if len(entries) != len(ls) {
+			t.Fatalf("%v: got %v, want %v", p, len(entries), len(ls))
+		}
+		for i, e := range entries {
+			if !reflect.DeepEqual(&e.Content, ls[i]) || e.Path != trie.JoinPath(p, ls[i].Name) {
+				t.Errorf("%v: got %v, want %v", p, e, ls[i])
+			}
+		}
+	}
+
+	cases := []struct {
+		path     string
+		depth    int
+		expected []string
+	}{
+		{path: "/", depth: 2, expected: []string{"/aaa/bba", "/aaa/bbb", "/aaa/file1.txt", "/bbb/ccc"}},
+		{path: "/", depth: 3, expected: []string{"/aaa/bbb/file2.txt", "/bbb/ccc/ddd"}},
+		{path: "/bbb", depth: 3, expected: []string{"/bbb/ccc/ddd/file3.txt"}},
+		{path: "/", depth: 5, expected: []string{}},
+		{path: "/", depth: 0, expected: []string{}},
+		{path: "/missing", depth: 1, expected: []string{}},
+		{path: "/file.txt", depth: 1, expected: []string{}},
+	}
+	for _, tc := range cases {
+		got := make([]string, 0)
+		for _, e := range trie.LsAtDepth(tc.path, tc.depth) {
+			got = append(got, e.Path)
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%v at %v: got %v, want %v", tc.path, tc.depth, got, tc.expected)
+		}
+	}
+}
+
+func TestIsAncestor(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		a        string
+		b        string
+		ancestor bool
+		sibling  bool
+	}{
+		{a: "/a/b", b: "/a/b/c", ancestor: true},
+		{a: "/a", b: "/a/b/c", ancestor: true},
+		{a: "/", b: "/a", ancestor: true},
+		{a: "a//b/", b: "/a/b/c/", ancestor: true},
+		{a: "/a/b", b: "/a/b"},
+		{a: "/a/b/", b: "/a//b"},
+		{a: "/", b: "/"},
+		{a: "/a/b", b: "/a/bc", sibling: true},
+		{a: "/a/bc", b: "/a/b", sibling: true},
+		{a: "/a/b/c", b: "/a/b"},
+		{a: "/a", b: "/b/a"},
+		{a: "", b: "/a"},
+	}
+	for _, tc := range cases {
+		if got := triefs.IsAncestor(tc.a, tc.b); got != tc.ancestor {
+			t.Errorf("IsAncestor(%q, %q): got %v, want %v", tc.a, tc.b, got, tc.ancestor)
+		}
+		if got := triefs.IsSibling(tc.a, tc.b); got != tc.sibling {
+			t.Errorf("IsSibling(%q, %q): got %v, want %v", tc.a, tc.b, got, tc.sibling)
+		}
+	}
+}
+
+func TestContentJSONSchema(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1700000000, 0)
+	full := triefs.NewContent("file.txt", "cid", 1, "text/plain", now)
+	full.Metadata = map[string]string{"k": "v"}
+	full.DetectedType = "text/plain"
+	full.Checksum = "sum"
+	full.RefEntries = 2
+
+	required := []string{"cid", "content_type", "created_at", "name", "size", "version"}
+	cases := []struct {
+		name     string
+		c        triefs.Content
+		expected []string
+	}{
+		{
+			name:     "required",
+			c:        triefs.NewContent("file.txt", "cid", 1, "text/plain", now),
+			expected: required,
+		},
+		{
+			name:     "all",
+			c:        full,
+			expected: append([]string{"checksum", "detected_type", "metadata", "ref_entries"}, required...),
+		},
+	}
+	keys := func(data []byte) ([]string, map[string]any) {
+		m := make(map[string]any)
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res := make([]string, 0, len(m))
+		for k := range m {
+			res = append(res, k)
+		}
+		sort.Strings(res)
+		return res, m
+	}
+
+	for _, tc := range cases {
+		data, err := json.Marshal(tc.c)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := append([]string(nil), tc.expected...)
+		sort.Strings(expected)
+		got, m := keys(data)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%v: got %v, want %v", tc.name, got, expected)
+		}
+		if m["created_at"] != float64(now.Unix()) {
+			t.Errorf("%v: got %v, want %v", tc.name, m["created_at"], now.Unix())
+		}
+
+		data, err = tc.c.MarshalJSONRFC3339()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, m = keys(data)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%v: got %v, want %v", tc.name, got, expected)
+		}
+		if m["created_at"] != "2023-11-14T22:13:20Z" {
+			t.Errorf("%v: got %v, want %v", tc.name, m["created_at"], "2023-11-14T22:13:20Z")
+		}
+	}
+}
+
+func TestCommonAncestor(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		paths    []string
+		expected string
+	}{
+		{paths: []string{"/a/b/x", "/a/b/y"}, expected: "/a/b"},
+		{paths: []string{"/a/bc", "/a/bd"}, expected: "/a"},
+		{paths: []string{"/a/bc/x", "/a/b/x"}, expected: "/a"},
+		{paths: []string{"/a/b/c"}, expected: "/a/b"},
+		{paths: []string{"/a/b", "/a/b/c"}, expected: "/a"},
+		{paths: []string{"a//b/x/", "/a/b/y/z", "", "/a/b/w"}, expected: "/a/b"},
+		{paths: []string{"/a/x", "/b/x"}, expected: "/"},
+		{paths: []string{"/中文/文件", "/中文/件"}, expected: "/中文"},
+		{paths: []string{"/"}, expected: "/"},
+		{paths: []string{}, expected: ""},
+	}
+	for _, tc := range cases {
+		if got := triefs.CommonAncestor(tc.paths); got != tc.expected {
+			t.Errorf("%v: got %v, want %v", tc.paths, got, tc.expected)
+		}
+	}
 }
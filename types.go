@@ -0,0 +1,110 @@
+package triefs
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// extensionTypes covers common extensions which aren't part of the mime
+// package builtin table, so detection doesn't depend on the system mime.types
+var extensionTypes = map[string]string{
+	".txt":  "text/plain",
+	".md":   "text/markdown",
+	".csv":  "text/csv",
+	".m4a":  "audio/mp4",
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+	".mp4":  "video/mp4",
+	".mov":  "video/quicktime",
+	".zip":  "application/zip",
+}
+
+// DetectType guesses MIME type by the name extension,
+// returns empty string if extension is unknown or missing
+func DetectType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if len(ext) == 0 {
+		return ""
+	}
+
+	if t, ok := extensionTypes[ext]; ok {
+		return t
+	}
+
+	t, _, err := mime.ParseMediaType(mime.TypeByExtension(ext))
+	if err != nil {
+		return ""
+	}
+	return t
+}
+
+// SetAllowedTypes restricts types of added entries, AddFile and the other
+// insert methods fail with ErrDisallowedType for the rest. Directories and
+// references are always allowed and type parameters like charset are
+// ignored. No types allow everything
+func (mt *Trie) SetAllowedTypes(types []string) {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	if len(types) == 0 {
+		mt.allowedTypes = nil
+		return
+	}
+	mt.allowedTypes = make(map[string]bool, len(types))
+	for _, t := range types {
+		mt.allowedTypes[mediaType(t)] = true
+	}
+}
+
+// checkType returns ErrDisallowedType if type t can't be added.
+// Callers must hold at least a read lock.
+func (mt *Trie) checkType(t string) error {
+	if mt.allowedTypes == nil {
+		return nil
+	}
+	if len(t) == 0 {
+		t = MIMEOctetStream
+	}
+
+	switch t {
+	case MIMEDriveEntry, MIMEDriveDirectory, MIMEReference:
+		return nil
+	}
+	if !mt.allowedTypes[mediaType(t)] {
+		return ErrDisallowedType
+	}
+	return nil
+}
+
+// mediaType returns t without parameters
+func mediaType(t string) string {
+	base, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		return strings.ToLower(t)
+	}
+	return base
+}
+
+// InferTypes fills DetectedType of every MIMEOctetStream entry
+// with the type guessed from its name
+func (mt *Trie) InferTypes() {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	if mt.Root != nil {
+		inferTypes(mt.Root)
+		mt.cache.clear()
+		mt.epoch++
+	}
+}
+
+func inferTypes(subtrie *Entry) {
+	if subtrie.Type == MIMEOctetStream {
+		subtrie.DetectedType = DetectType(subtrie.Name)
+	}
+	for _, me := range subtrie.Entries {
+		inferTypes(me)
+	}
+}
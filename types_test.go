@@ -0,0 +1,120 @@
+package triefs_test
+
+import (
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestDetectType(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{name: "notes.txt", expected: "text/plain"},
+		{name: "logo.png", expected: "image/png"},
+		{name: "LOGO.PNG", expected: "image/png"},
+		{name: "01. 孤悲.m4a", expected: "audio/mp4"},
+		{name: "Makefile", expected: ""},
+		{name: ".hidden", expected: ""},
+		{name: "archive.unknownext", expected: ""},
+		{name: "", expected: ""},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := triefs.DetectType(tc.name); got != tc.expected {
+				t.Errorf("got %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestInferTypes(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/さだまさし/孤悲/01. 孤悲.m4a", "cid1", 100, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/notes.txt", "cid2", 100, "", now),
+		triefs.NewEntry("/docs/logo.png", "cid3", 100, "image/png", now),
+		triefs.NewEntry("/docs/README", "cid4", 100, "", now),
+		triefs.NewEntry("/docs/empty", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	trie.InferTypes()
+
+	cases := []struct {
+		path     string
+		typ      string
+		detected string
+	}{
+		{path: "/さだまさし/孤悲/01. 孤悲.m4a", typ: triefs.MIMEOctetStream, detected: "audio/mp4"},
+		{path: "/docs/notes.txt", typ: triefs.MIMEOctetStream, detected: "text/plain"},
+		// only octet-stream entries are inferred
+		{path: "/docs/logo.png", typ: "image/png", detected: ""},
+		{path: "/docs/README", typ: triefs.MIMEOctetStream, detected: ""},
+		{path: "/docs/empty", typ: triefs.MIMEDriveDirectory, detected: ""},
+	}
+	for _, tc := range cases {
+		c, err := trie.Stat(tc.path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if c.Type != tc.typ {
+			t.Errorf("%s: got type %v, want %v", tc.path, c.Type, tc.typ)
+		}
+		if c.DetectedType != tc.detected {
+			t.Errorf("%s: got %v, want %v", tc.path, c.DetectedType, tc.detected)
+		}
+	}
+}
+
+func TestAllowedTypes(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	trie.SetAllowedTypes([]string{"image/png", "text/plain"})
+
+	cases := []struct {
+		entry *triefs.Entry
+		err   error
+	}{
+		{entry: triefs.NewEntry("/logo.png", "", 0, "image/png", now)},
+		{entry: triefs.NewEntry("/logo.png(1)", "", 0, "image/png", now)},
+		{entry: triefs.NewEntry("/notes.txt", "cid", 1, "text/plain; charset=utf-8", now)},
+		{entry: triefs.NewEntry("/dir", "", 0, triefs.MIMEDriveEntry, now)},
+		{entry: triefs.NewEntry("/blob", "cid", 1, triefs.MIMEOctetStream, now), err: triefs.ErrDisallowedType},
+		{entry: triefs.NewEntry("/untyped", "cid", 1, "", now), err: triefs.ErrDisallowedType},
+		{entry: triefs.NewEntry("/photo.jpg", "cid", 1, "image/jpeg", now), err: triefs.ErrDisallowedType},
+	}
+	for _, tc := range cases {
+		if _, err := trie.AddFile(tc.entry); err != tc.err {
+			t.Errorf("%v: got %v, want %v", tc.entry.Path, err, tc.err)
+		}
+	}
+
+	if _, err := trie.AddFileUnique(triefs.NewEntry("/blob", "cid", 1, triefs.MIMEOctetStream, now)); err != triefs.ErrDisallowedType {
+		t.Errorf("got %v, want %v", err, triefs.ErrDisallowedType)
+	}
+	c := triefs.NewContent("blob", "cid", 1, triefs.MIMEOctetStream, now)
+	if _, err := trie.Upsert("/logo.png", &c); err != triefs.ErrDisallowedType {
+		t.Errorf("got %v, want %v", err, triefs.ErrDisallowedType)
+	}
+	if _, err := trie.CreateRef("/dir", "bucket", now); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	trie.SetAllowedTypes(nil)
+	if _, err := trie.AddFile(triefs.NewEntry("/blob", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package triefs
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var copySuffix = regexp.MustCompile(` \((\d+)\)$`)
+
+// AddFileUnique adds file like AddFile does but if a file with the same name
+// already exists in the target directory it stores the entry under the first
+// free name with " (n)" suffix inserted before the extension, e.g. "logo (1).png".
+// Returns the stored entry with its final path. Conflicts with directories
+// aren't resolved and return ErrConflict
+func (mt *Trie) AddFileUnique(e *Entry) (*Entry, error) {
+	mt.lock.Lock()
+	defer mt.unlock()
+
+	if e == nil {
+		return nil, ErrConflict
+	}
+	if mt.noEmptyDirs && e.Type == MIMEDriveEntry {
+		return nil, ErrEmptyDirNotAllowed
+	}
+	err := mt.checkType(e.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	m := e.copy()
+	if mt.custom() {
+		m = mt.internalEntry(e)
+	}
+	err = m.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	m.Path = CleanPath(m.Path)
+	if m.Type != MIMEDriveEntry && mt.Root != nil {
+		if existing := stat(m.Path, mt.Root); existing != nil && existing.IsDirectory() {
+			return nil, ErrConflict
+		}
+		dir := filepath.Dir(m.Path)
+		m.Path = JoinPath(dir, mt.suggestName(dir, filepath.Base(m.Path)))
+		m.Name = filepath.Base(m.Path)
+	}
+
+	_, err = mt.addFile(m)
+	if err != nil {
+		return nil, err
+	}
+	mt.notify(OpCreate, m.Path, nil)
+	return mt.swapEntry(&Entry{Content: *m.Content.copy(), Path: m.Path}), nil
+}
+
+// SuggestName returns name if nothing in dir is called so, otherwise the
+// first free name with " (n)" suffix, the one AddFileUnique would pick.
+// Both files and directories take names. The suffix goes before the
+// extension, unless name is taken by a directory, then it's appended
+func (mt *Trie) SuggestName(dir, name string) string {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	d := CleanPath(mt.swap(dir))
+	return mt.swap(mt.suggestName(d, mt.swap(name)))
+}
+
+// suggestName is the lock-free core of SuggestName, dir is a cleaned path.
+// Callers must hold at least a read lock.
+func (mt *Trie) suggestName(dir, name string) string {
+	if mt.Root == nil {
+		return name
+	}
+	existing := stat(JoinPath(dir, name), mt.Root)
+	if existing == nil {
+		return name
+	}
+
+	numbered := numberedName
+	if existing.IsDirectory() {
+		numbered = suffixedName
+	}
+	for n := 1; ; n++ {
+		candidate := numbered(name, n)
+		if stat(JoinPath(dir, candidate), mt.Root) == nil {
+			return candidate
+		}
+	}
+}
+
+// numberedName returns name with " (n)" suffix before the extension,
+// an existing suffix is incremented, so "logo (1).png" becomes "logo (n+1).png"
+func numberedName(name string, n int) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if len(base) == 0 {
+		// dot files like ".hidden" have no extension
+		base, ext = name, ""
+	}
+	return suffixedName(base, n) + ext
+}
+
+// suffixedName appends " (n)" suffix to name incrementing an existing one
+func suffixedName(name string, n int) string {
+	if m := copySuffix.FindStringSubmatch(name); m != nil {
+		if prev, err := strconv.Atoi(m[1]); err == nil {
+			n += prev
+		}
+		name = strings.TrimSuffix(name, m[0])
+	}
+	return fmt.Sprintf("%s (%d)", name, n)
+}
@@ -0,0 +1,171 @@
+package triefs_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestAddFileUnique(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+
+	expected := []string{"/img/logo.png", "/img/logo (1).png", "/img/logo (2).png"}
+	for i, want := range expected {
+		e, err := trie.AddFileUnique(triefs.NewEntry("/img/logo.png", "cid", int64(i), triefs.MIMEOctetStream, now))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if e.Path != want {
+			t.Errorf("got %v, want %v", e.Path, want)
+		}
+		if e.Name != want[len("/img/"):] {
+			t.Errorf("got %v, want %v", e.Name, want[len("/img/"):])
+		}
+
+		f, err := trie.File(want)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if f.Size != int64(i) {
+			t.Errorf("got %v, want %v", f.Size, i)
+		}
+	}
+}
+
+func TestAddFileUniqueNames(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	cases := []struct {
+		name     string
+		existing []string
+		path     string
+		expected string
+	}{
+		{
+			name:     "no conflict",
+			path:     "/logo.png",
+			expected: "/logo.png",
+		},
+		{
+			name:     "no extension",
+			existing: []string{"/README"},
+			path:     "/README",
+			expected: "/README (1)",
+		},
+		{
+			name:     "dot file",
+			existing: []string{"/.hidden"},
+			path:     "/.hidden",
+			expected: "/.hidden (1)",
+		},
+		{
+			name:     "increment existing suffix",
+			existing: []string{"/logo (1).png"},
+			path:     "/logo (1).png",
+			expected: "/logo (2).png",
+		},
+		{
+			name:     "skip taken numbers",
+			existing: []string{"/a.tar.gz", "/a.tar (1).gz", "/a.tar (2).gz"},
+			path:     "/a.tar.gz",
+			expected: "/a.tar (3).gz",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := triefs.NewTrie()
+			for _, p := range tc.existing {
+				if _, err := trie.AddFile(triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			e, err := trie.AddFileUnique(triefs.NewEntry(tc.path, "cid", 1, triefs.MIMEOctetStream, now))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if e.Path != tc.expected {
+				t.Errorf("got %v, want %v", e.Path, tc.expected)
+			}
+		})
+	}
+}
+
+func TestAddFileUniqueDirectoryConflict(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	if _, err := trie.AddFile(triefs.NewEntry("/logo.png/inner", "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := trie.AddFile(triefs.NewEntry("/empty", "", 0, triefs.MIMEDriveEntry, now)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := trie.AddFileUnique(triefs.NewEntry("/logo.png", "cid", 1, triefs.MIMEOctetStream, now))
+	if err != triefs.ErrConflict {
+		t.Errorf("got %v, want %v", err, triefs.ErrConflict)
+	}
+	_, err = trie.AddFileUnique(triefs.NewEntry("/empty", "cid", 1, triefs.MIMEOctetStream, now))
+	if err != triefs.ErrConflict {
+		t.Errorf("got %v, want %v", err, triefs.ErrConflict)
+	}
+
+	expected := []string{"/empty", "/logo.png", "/logo.png/inner"}
+	if got := trie.Paths(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+}
+
+func TestSuggestName(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/logo.png", "cid", 1, "image/png", now),
+		triefs.NewEntry("/logo (1).png", "cid", 1, "image/png", now),
+		triefs.NewEntry("/docs/README", "cid", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/v1.2", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/docs/report (1).pdf/inner", "cid", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/docs/report.pdf", "cid", 1, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		dir      string
+		name     string
+		expected string
+	}{
+		{dir: "/", name: "new.png", expected: "new.png"},
+		{dir: "/", name: "logo.png", expected: "logo (2).png"},
+		{dir: "/", name: "docs", expected: "docs (1)"},
+		{dir: "/docs/", name: "README", expected: "README (1)"},
+		{dir: "/docs", name: "v1.2", expected: "v1.2 (1)"},
+		// directories take names too
+		{dir: "/docs", name: "report.pdf", expected: "report (2).pdf"},
+		{dir: "/missing", name: "logo.png", expected: "logo.png"},
+	}
+	for _, tc := range cases {
+		if got := trie.SuggestName(tc.dir, tc.name); got != tc.expected {
+			t.Errorf("%v %v: got %v, want %v", tc.dir, tc.name, got, tc.expected)
+		}
+	}
+
+	e, err := trie.AddFileUnique(triefs.NewEntry("/docs/report.pdf", "cid", 1, triefs.MIMEOctetStream, now))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.Path != "/docs/report (2).pdf" {
+		t.Errorf("got %v, want %v", e.Path, "/docs/report (2).pdf")
+	}
+}
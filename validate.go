@@ -0,0 +1,165 @@
+package triefs
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validate checks the structural invariants of the trie and returns
+// ErrInvalidTrie describing the first violated one and the path where it
+// was found. It's meant for debugging and for tries decoded from outside
+func (mt *Trie) Validate() error {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if mt.Root == nil {
+		return mt.checkCount()
+	}
+	if mt.Root.IsMarker() || !strings.HasPrefix(mt.Root.Path, Separator) {
+		return invalidTrie("root path must start with separator", mt.Root.Path)
+	}
+	err := validateEntry("", mt.Root)
+	if err != nil {
+		return err
+	}
+	err = mt.checkMarkers()
+	if err != nil {
+		return err
+	}
+	return mt.checkCount()
+}
+
+// checkCount compares numbers of entries kept for Len and the total size
+// kept for SetQuota with a traversal.
+// Callers must hold at least a read lock.
+func (mt *Trie) checkCount() error {
+	used, files, dirs := mt.count(Separator)
+	if files != mt.files || dirs != mt.dirs {
+		reason := fmt.Sprintf("counted %d files and %d directories, found %d and %d", mt.files, mt.dirs, files, dirs)
+		return invalidTrie(reason, Separator)
+	}
+	if used != mt.used {
+		reason := fmt.Sprintf("counted %d bytes used, found %d", mt.used, used)
+		return invalidTrie(reason, Separator)
+	}
+	return nil
+}
+
+// checkMarkers verifies that removals left no orphaned ":" markers behind.
+// A file marker needs a real sibling, otherwise the node must have been
+// merged back into a leaf, and only empty directories keep a lone marker.
+// A node with a single real child must have been merged with it as well.
+// Callers must hold at least a read lock.
+func (mt *Trie) checkMarkers() error {
+	if mt.Root == nil {
+		return nil
+	}
+	return checkMarkers("", mt.Root)
+}
+
+func checkMarkers(prefix string, e *Entry) error {
+	path := prefix + e.Path
+	if len(e.Entries) == 1 {
+		me := e.Entries[0]
+		switch {
+		case !me.IsMarker():
+			return invalidTrie("node with a single child is not merged", path)
+		case me.Type != MIMEDriveEntry:
+			return invalidTrie("file marker without sibling", path)
+		}
+	}
+
+	for _, me := range e.Entries {
+		if me.IsMarker() {
+			continue
+		}
+		err := checkMarkers(path, me)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func invalidTrie(reason string, path string) error {
+	return fmt.Errorf("%w: %s at %q", ErrInvalidTrie, reason, path)
+}
+
+func validateEntry(prefix string, e *Entry) error {
+	if e.IsMarker() {
+		return validateMarker(prefix, e)
+	}
+
+	path := prefix + e.Path
+	switch {
+	case len(e.Path) == 0:
+		return invalidTrie("empty path label", path)
+	case !utf8.ValidString(e.Path):
+		return invalidTrie("path label is not valid UTF-8", path)
+	case strings.Contains(e.Path, SpecialPathSymbol):
+		return invalidTrie("path label contains marker symbol", path)
+	case strings.Contains(path, DoubleSeparator):
+		return invalidTrie("path contains empty segment", path)
+	}
+
+	if len(e.Entries) == 0 {
+		if e.Type == MIMEDriveEntry {
+			return invalidTrie("leaf directory without marker", path)
+		}
+		return validateContent(path, &e.Content)
+	}
+
+	if e.Type != MIMEDriveEntry {
+		return invalidTrie("inner node is not a directory entry", path)
+	}
+	if len(e.CID) != 0 || e.Size != 0 {
+		return invalidTrie("directory has CID or size", path)
+	}
+
+	seen := make(map[rune]bool)
+	for _, me := range e.Entries {
+		r, _ := utf8.DecodeRuneInString(me.Path)
+		if seen[r] {
+			return invalidTrie(fmt.Sprintf("siblings share first rune %q", r), path)
+		}
+		seen[r] = true
+
+		err := validateEntry(path, me)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateMarker checks ":" node which marks its parent path as an entry
+func validateMarker(path string, e *Entry) error {
+	if len(e.Entries) != 0 {
+		return invalidTrie("marker has children", path)
+	}
+	if strings.HasSuffix(path, Separator) {
+		return invalidTrie("marker after separator", path)
+	}
+	if e.Type == MIMEDriveEntry {
+		if len(e.CID) != 0 || e.Size != 0 {
+			return invalidTrie("directory has CID or size", path)
+		}
+		return nil
+	}
+	return validateContent(path, &e.Content)
+}
+
+func validateContent(path string, c *Content) error {
+	if c.Type == MIMEDriveDirectory {
+		return invalidTrie("directory type is stored", path)
+	}
+	if _, _, err := mime.ParseMediaType(c.Type); err != nil {
+		return invalidTrie(fmt.Sprintf("unknown type %q", c.Type), path)
+	}
+	if strings.Contains(c.Name, Separator) || strings.Contains(c.Name, SpecialPathSymbol) {
+		return invalidTrie("name contains illegal characters", path)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package triefs_test
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func TestValidateTrie(t *testing.T) {
+	t.Parallel()
+	for _, trie := range []*triefs.Trie{triefs.NewTrie(), tarTrie(t), nestedTrie(t)} {
+		if err := trie.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestValidateCorruptedTrie(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	file := func(path string, cid string) *triefs.Entry {
+		return triefs.NewEntry(path, cid, 1, triefs.MIMEOctetStream, now)
+	}
+	dir := func(path string, entries ...*triefs.Entry) *triefs.Entry {
+		e := triefs.NewEntry(path, "", 0, triefs.MIMEDriveEntry, now)
+		e.Entries = entries
+		return e
+	}
+	marker := func() *triefs.Entry {
+		return triefs.NewEntry("", "", 0, triefs.MIMEDriveEntry, now).Entries[0]
+	}
+
+	cases := []struct {
+		name   string
+		root   *triefs.Entry
+		reason string
+		path   string
+	}{
+		{
+			name:   "relative root",
+			root:   file("a", "cid"),
+			reason: "root path",
+			path:   "a",
+		},
+		{
+			name:   "siblings share first rune",
+			root:   dir("/a", file("bc", "cid"), file("bd", "cid")),
+			reason: "siblings share first rune",
+			path:   "/a",
+		},
+		{
+			name: "unknown type",
+			root: func() *triefs.Entry {
+				e := file("/a", "cid")
+				e.Type = "not a type"
+				return e
+			}(),
+			reason: "unknown type",
+			path:   "/a",
+		},
+		{
+			name: "stored directory type",
+			root: func() *triefs.Entry {
+				e := file("/a", "")
+				e.Type = triefs.MIMEDriveDirectory
+				return e
+			}(),
+			reason: "directory type",
+			path:   "/a",
+		},
+		{
+			name: "directory with cid",
+			root: func() *triefs.Entry {
+				e := dir("/a", file("/b", "cid"), file("c", "cid"))
+				e.CID = "cid"
+				return e
+			}(),
+			reason: "directory has CID",
+			path:   "/a",
+		},
+		{
+			name: "marker with children",
+			root: func() *triefs.Entry {
+				m := marker()
+				m.Entries = append(m.Entries, marker())
+				return dir("/a", file("b", "cid"), m)
+			}(),
+			reason: "marker has children",
+			path:   "/a",
+		},
+		{
+			name:   "marker symbol in label",
+			root:   dir("/a", file(":b", "cid"), file("c", "cid")),
+			reason: "marker symbol",
+			path:   "/a:b",
+		},
+		{
+			name:   "empty segment",
+			root:   dir("/a", file("//b", "cid"), file("c", "cid")),
+			reason: "empty segment",
+			path:   "/a//b",
+		},
+		{
+			name:   "leaf directory without marker",
+			root:   dir("/a", dir("/b"), file("c", "cid")),
+			reason: "leaf directory",
+			path:   "/a/b",
+		},
+		{
+			name: "file marker without sibling",
+			root: func() *triefs.Entry {
+				m := marker()
+				m.Content = file("/a/b", "cid").Content
+				return dir("/a", dir("/b", m), file("c", "cid"))
+			}(),
+			reason: "file marker without sibling",
+			path:   "/a/b",
+		},
+		{
+			name:   "single child not merged",
+			root:   dir("/a", dir("/b", file("/c", "cid")), file("c", "cid")),
+			reason: "single child",
+			path:   "/a/b",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := triefs.NewTrie()
+			trie.Root = tc.root
+
+			err := trie.Validate()
+			if !errors.Is(err, triefs.ErrInvalidTrie) {
+				t.Fatalf("got %v, want %v", err, triefs.ErrInvalidTrie)
+			}
+			if !strings.Contains(err.Error(), tc.reason) || !strings.Contains(err.Error(), `"`+tc.path+`"`) {
+				t.Errorf("got %v, want %q at %q", err, tc.reason, tc.path)
+			}
+		})
+	}
+}
+
+func TestValidateAfterEmptyFolderOnInnerNode(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/NG", "cid", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/Nz", "cid", 1, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/N", "", 0, triefs.MIMEDriveEntry, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := trie.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateRandomMutations(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	segments := []string{"a", "ab", "abc", "b", "ba"}
+	rnd := rand.New(rand.NewSource(877))
+	randomPath := func() string {
+		parts := make([]string, 1+rnd.Intn(3))
+		for i := range parts {
+			parts[i] = segments[rnd.Intn(len(segments))]
+		}
+		return "/" + strings.Join(parts, "/")
+	}
+
+	for run := 0; run < 50; run++ {
+		trie := triefs.NewTrie()
+		steps := make([]string, 0)
+		for i := 0; i < 40; i++ {
+			p := randomPath()
+			switch rnd.Intn(3) {
+			case 0:
+				steps = append(steps, "delete "+p)
+				_ = trie.Delete(p)
+			case 1:
+				steps = append(steps, "add dir "+p)
+				_, _ = trie.AddFile(triefs.NewEntry(p, "", 0, triefs.MIMEDriveEntry, now))
+			default:
+				steps = append(steps, "add "+p)
+				_, _ = trie.AddFile(triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now))
+			}
+
+			if err := trie.Validate(); err != nil {
+				t.Fatalf("got %v after %v", err, strings.Join(steps, ", "))
+			}
+		}
+	}
+}
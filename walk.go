@@ -0,0 +1,182 @@
+package triefs
+
+import (
+	"context"
+	"io/fs"
+	"sort"
+)
+
+// walkCheckInterval is the number of entries WalkContext visits between
+// checks of its context
+const walkCheckInterval = 64
+
+// Walk traverses passed path and all its descendants in pre-order calling fn
+// with the absolute path of every entry. Children are visited in name order.
+// Returning fs.SkipDir from fn skips the directory (or the remaining siblings
+// of a file), any other error aborts the walk and is returned
+func (mt *Trie) Walk(path string, fn func(path string, c *Content) error) error {
+	p := mt.CleanPath(path)
+	return mt.WalkDepth(p, -1, func(relPath string, c *Content, _ int) error {
+		return fn(mt.JoinPath(p, relPath), c)
+	})
+}
+
+// WalkContext is similar to Walk but stops once ctx is done returning
+// ctx.Err(). The context is checked before the walk and then every
+// walkCheckInterval entries, so a few more entries may be visited after
+// cancellation
+func (mt *Trie) WalkContext(ctx context.Context, path string, fn func(path string, c *Content) error) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	visited := 0
+	return mt.Walk(path, func(p string, c *Content) error {
+		visited++
+		if visited%walkCheckInterval == 0 {
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+		}
+		return fn(p, c)
+	})
+}
+
+// WalkOrder is the order in which directories are visited relative to their
+// descendants
+type WalkOrder int
+
+const (
+	// PreOrder visits a directory before its descendants
+	PreOrder WalkOrder = iota
+	// PostOrder visits a directory after all its descendants
+	PostOrder
+)
+
+// WalkOrder is similar to Walk but visits entries in the passed order. With
+// PostOrder fn can safely delete the entry it's called with, since its
+// descendants were already visited. fs.SkipDir returned for a directory has no
+// effect in PostOrder, for a file it skips the remaining siblings
+func (mt *Trie) WalkOrder(path string, order WalkOrder, fn func(path string, c *Content) error) error {
+	if order == PreOrder {
+		return mt.Walk(path, fn)
+	}
+	if len(path) == 0 {
+		return ErrEmptyPath
+	}
+
+	p := mt.CleanPath(path)
+	start, err := mt.Stat(p)
+	if err != nil {
+		return err
+	}
+	if start.IsDirectory() {
+		err = mt.walkPost(p, fn)
+		if err != nil {
+			return err
+		}
+	}
+	err = fn(p, start)
+	if err == fs.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (mt *Trie) walkPost(path string, fn func(string, *Content) error) error {
+	for _, c := range mt.children(path) {
+		p := mt.JoinPath(path, c.Name)
+		if c.IsDirectory() {
+			err := mt.walkPost(p, fn)
+			if err != nil {
+				return err
+			}
+		}
+		err := fn(p, c)
+		if err == fs.SkipDir {
+			if c.IsDirectory() {
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkDepth is similar to Walk but visits entries at most maxDepth levels below path,
+// -1 means unlimited. The path itself is visited at depth 0 with relPath "/",
+// its children at depth 1 and so on. Relative paths are the same as returned by LsRecursive.
+// The trie is not locked while fn runs, so fn may modify the trie
+func (mt *Trie) WalkDepth(path string, maxDepth int, fn func(relPath string, c *Content, depth int) error) error {
+	if len(path) == 0 {
+		return ErrEmptyPath
+	}
+
+	p := mt.CleanPath(path)
+	start, err := mt.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	root := string(mt.Separator())
+	err = fn(root, start, 0)
+	if err == fs.SkipDir {
+		return nil
+	}
+	if err != nil || !start.IsDirectory() {
+		return err
+	}
+	return mt.walkDir(p, root, 1, maxDepth, fn)
+}
+
+func (mt *Trie) walkDir(path string, relPath string, depth int, maxDepth int, fn func(string, *Content, int) error) error {
+	if maxDepth >= 0 && depth > maxDepth {
+		return nil
+	}
+
+	for _, c := range mt.children(path) {
+		rel := mt.JoinPath(relPath, c.Name)
+		err := fn(rel, c, depth)
+		if err == fs.SkipDir {
+			if c.IsDirectory() {
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c.IsDirectory() {
+			err = mt.walkDir(mt.JoinPath(path, c.Name), rel, depth+1, maxDepth, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// children returns copies of the immediate children of path sorted by name
+func (mt *Trie) children(path string) []*Content {
+	mt.lock.RLock()
+	defer mt.lock.RUnlock()
+
+	if mt.Root == nil {
+		return []*Content{}
+	}
+
+	contents := list(mt.swap(path), mt.Root)
+	res := make([]*Content, len(contents))
+	for i, c := range contents {
+		res[i] = mt.swapContent(c.copy())
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
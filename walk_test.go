@@ -0,0 +1,284 @@
+package triefs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"testing"
+	"time"
+
+	triefs "github.com/kalambet/trie-fs"
+)
+
+func nestedTrie(t *testing.T) *triefs.Trie {
+	trie := triefs.NewTrie()
+	now := time.Now()
+	dirs := []*triefs.Entry{
+		triefs.NewEntry("/folder/f1/f2/f3/f4", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/folder/f/f2/f3/f4", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/folder/f/f/f3/f4", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/folder/f/f/f/f4", "", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/folder/f/f/f/f", "", 0, triefs.MIMEDriveEntry, now),
+	}
+
+	for _, d := range dirs {
+		_, err := trie.AddFile(d)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return trie
+}
+
+func TestWalkDepth(t *testing.T) {
+	t.Parallel()
+
+	trie := nestedTrie(t)
+	cases := []struct {
+		name     string
+		maxDepth int
+		expected []string
+		depths   []int
+	}{
+		{
+			name:     "depth 0",
+			maxDepth: 0,
+			expected: []string{"/"},
+			depths:   []int{0},
+		},
+		{
+			name:     "depth 1",
+			maxDepth: 1,
+			expected: []string{"/", "/f", "/f1"},
+			depths:   []int{0, 1, 1},
+		},
+		{
+			name:     "unlimited",
+			maxDepth: -1,
+			expected: []string{
+				"/",
+				"/f", "/f/f", "/f/f/f", "/f/f/f/f", "/f/f/f/f4", "/f/f/f3", "/f/f/f3/f4",
+				"/f/f2", "/f/f2/f3", "/f/f2/f3/f4",
+				"/f1", "/f1/f2", "/f1/f2/f3", "/f1/f2/f3/f4",
+			},
+			depths: []int{0, 1, 2, 3, 4, 4, 3, 4, 2, 3, 4, 1, 2, 3, 4},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			paths := make([]string, 0)
+			depths := make([]int, 0)
+			err := trie.WalkDepth("/folder", tc.maxDepth, func(relPath string, c *triefs.Content, depth int) error {
+				if !c.IsDirectory() {
+					t.Errorf("got %v, want directory", c.Type)
+				}
+				paths = append(paths, relPath)
+				depths = append(depths, depth)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(paths, tc.expected) {
+				t.Errorf("got %v, want %v", paths, tc.expected)
+			}
+			if !reflect.DeepEqual(depths, tc.depths) {
+				t.Errorf("got %v, want %v", depths, tc.depths)
+			}
+		})
+	}
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+
+	trie := nestedTrie(t)
+
+	// Abort on error
+	errStop := errors.New("stop")
+	visited := 0
+	err := trie.Walk("/folder", func(path string, c *triefs.Content) error {
+		visited++
+		if path == "/folder/f/f" {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("got %v, want %v", err, errStop)
+	}
+	if visited != 3 {
+		t.Errorf("got %v, want %v", visited, 3)
+	}
+
+	// Skip directory
+	paths := make([]string, 0)
+	err = trie.Walk("/", func(path string, c *triefs.Content) error {
+		paths = append(paths, path)
+		if path == "/folder/f" {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"/", "/folder", "/folder/f", "/folder/f1", "/folder/f1/f2", "/folder/f1/f2/f3", "/folder/f1/f2/f3/f4"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("got %v, want %v", paths, expected)
+	}
+
+	// Missing path
+	err = trie.Walk("/missing", func(string, *triefs.Content) error { return nil })
+	if err != triefs.ErrFileNotExist {
+		t.Errorf("got %v, want %v", err, triefs.ErrFileNotExist)
+	}
+}
+
+func TestToMap(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for _, e := range []*triefs.Entry{
+		triefs.NewEntry("/aaa", "test_cid", 0, triefs.MIMEDriveEntry, now),
+		triefs.NewEntry("/aaa/bbb/file1.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/aaa/bba/file2.txt", "test_cid", 512, triefs.MIMEOctetStream, now),
+		triefs.NewEntry("/bbb/aaa/file1.txt", "test_cid", 0, triefs.MIMEOctetStream, now),
+	} {
+		if _, err := trie.AddFile(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	file := func(name string, size int64) map[string]any {
+		return map[string]any{"name": name, "cid": "test_cid", "size": size, "type": triefs.MIMEOctetStream}
+	}
+	expected := map[string]any{
+		"aaa": map[string]any{
+			"bbb": map[string]any{"file1.txt": file("file1.txt", 512)},
+			"bba": map[string]any{"file2.txt": file("file2.txt", 512)},
+		},
+		"bbb": map[string]any{
+			"aaa": map[string]any{"file1.txt": file("file1.txt", 0)},
+		},
+	}
+	if got := trie.ToMap("/"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+	if got := trie.ToMap("/aaa/"); !reflect.DeepEqual(got, expected["aaa"]) {
+		t.Errorf("got %v, want %v", got, expected["aaa"])
+	}
+	if got := trie.ToMap("/missing"); len(got) != 0 {
+		t.Errorf("got %v, want empty map", got)
+	}
+}
+
+func TestWalkOrder(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name     string
+		order    triefs.WalkOrder
+		expected []string
+	}{
+		{
+			name:  "pre-order",
+			order: triefs.PreOrder,
+			expected: []string{
+				"/folder/f/f",
+				"/folder/f/f/f", "/folder/f/f/f/f", "/folder/f/f/f/f4",
+				"/folder/f/f/f3", "/folder/f/f/f3/f4",
+			},
+		},
+		{
+			name:  "post-order",
+			order: triefs.PostOrder,
+			expected: []string{
+				"/folder/f/f/f/f", "/folder/f/f/f/f4", "/folder/f/f/f",
+				"/folder/f/f/f3/f4", "/folder/f/f/f3",
+				"/folder/f/f",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trie := nestedTrie(t)
+			visited := make([]string, 0)
+			err := trie.WalkOrder("/folder/f/f", tc.order, func(path string, c *triefs.Content) error {
+				visited = append(visited, path)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(visited, tc.expected) {
+				t.Errorf("got %v, want %v", visited, tc.expected)
+			}
+		})
+	}
+}
+
+func TestWalkPostOrderDelete(t *testing.T) {
+	t.Parallel()
+	trie := nestedTrie(t)
+	err := trie.WalkOrder("/folder", triefs.PostOrder, func(path string, c *triefs.Content) error {
+		return trie.Delete(path)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries := trie.LsRecursive("/"); len(entries) != 0 {
+		t.Errorf("got %v, want empty trie", entries)
+	}
+}
+
+func TestWalkContext(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	trie := triefs.NewTrie()
+	for i := 0; i < 1000; i++ {
+		p := fmt.Sprintf("/d%d/file%d", i%10, i)
+		if _, err := trie.AddFile(triefs.NewEntry(p, "cid", 1, triefs.MIMEOctetStream, now)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	visited := 0
+	err := trie.WalkContext(context.Background(), "/", func(path string, c *triefs.Content) error {
+		visited++
+		return nil
+	})
+	if err != nil || visited != 1011 {
+		t.Errorf("got %v %v, want %v", visited, err, 1011)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	visited = 0
+	err = trie.WalkContext(ctx, "/", func(path string, c *triefs.Content) error {
+		visited++
+		if visited == 10 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if visited >= 100 {
+		t.Errorf("got %v visited entries, want the walk to stop early", visited)
+	}
+
+	visited = 0
+	err = trie.WalkContext(ctx, "/", func(path string, c *triefs.Content) error {
+		visited++
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || visited != 0 {
+		t.Errorf("got %v %v, want %v", visited, err, context.Canceled)
+	}
+}